@@ -0,0 +1,130 @@
+// This file is automatically generated. Do not modify.
+
+package scribe
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+var _ = fmt.Sprintf
+
+type ResultCode int32
+
+const (
+	ResultCodeOk       ResultCode = 0
+	ResultCodeTryLater ResultCode = 1
+)
+
+var (
+	ResultCodeByName = map[string]ResultCode{
+		"ResultCode.OK":        ResultCodeOk,
+		"ResultCode.TRY_LATER": ResultCodeTryLater,
+	}
+	ResultCodeByValue = map[ResultCode]string{
+		ResultCodeOk:       "ResultCode.OK",
+		ResultCodeTryLater: "ResultCode.TRY_LATER",
+	}
+)
+
+func (e ResultCode) String() string {
+	name := ResultCodeByValue[e]
+	if name == "" {
+		name = fmt.Sprintf("Unknown enum value ResultCode(%d)", e)
+	}
+	return name
+}
+
+func (e ResultCode) MarshalJSON() ([]byte, error) {
+	name := ResultCodeByValue[e]
+	if name == "" {
+		name = strconv.Itoa(int(e))
+	}
+	return []byte("\"" + name + "\""), nil
+}
+
+func (e *ResultCode) UnmarshalJSON(b []byte) error {
+	st := string(b)
+	if st[0] == '"' {
+		*e = ResultCode(ResultCodeByName[st[1:len(st)-1]])
+		return nil
+	}
+	i, err := strconv.Atoi(st)
+	*e = ResultCode(i)
+	return err
+}
+
+type LogEntry struct {
+	Category string `thrift:"1,required" json:"category"`
+	Message  string `thrift:"2,required" json:"message"`
+}
+
+type Scribe interface {
+	Log(messages []*LogEntry) (ResultCode, error)
+}
+
+type ScribeServer struct {
+	Implementation Scribe
+}
+
+func (s *ScribeServer) Log(req *ScribeLogRequest, res *ScribeLogResponse) error {
+	val, err := s.Implementation.Log(req.Messages)
+	res.Value = &val
+	return err
+}
+
+type ScribeProcessor struct {
+	Server *ScribeServer
+}
+
+func NewScribeProcessor(impl Scribe) *ScribeProcessor {
+	return &ScribeProcessor{
+		Server: &ScribeServer{Implementation: impl},
+	}
+}
+
+func (p *ScribeProcessor) NewRequest(method string) interface{} {
+	switch method {
+	case "Log":
+		return &ScribeLogRequest{}
+	}
+	return nil
+}
+
+func (p *ScribeProcessor) Process(ctx context.Context, method string, req interface{}) (interface{}, error) {
+	switch method {
+	case "Log":
+		res := &ScribeLogResponse{}
+		err := p.Server.Log(req.(*ScribeLogRequest), res)
+		return res, err
+	}
+	return nil, fmt.Errorf("unknown method %s", method)
+}
+
+// ScribeIdempotentMethods are the methods of Scribe that are safe to retry.
+var ScribeIdempotentMethods = map[string]bool{}
+
+type ScribeLogRequest struct {
+	Messages []*LogEntry `thrift:"1,required" json:"messages"`
+}
+
+type ScribeLogResponse struct {
+	Value *ResultCode `thrift:"0" json:"value,omitempty"`
+}
+
+type ScribeClient struct {
+	Client RPCClient
+}
+
+func (s *ScribeClient) Log(messages []*LogEntry) (ret ResultCode, err error) {
+	req := &ScribeLogRequest{
+		Messages: messages,
+	}
+	res := &ScribeLogResponse{}
+	err = s.Client.Call("Log", req, res)
+	if err == nil && res.Value != nil {
+		ret = *res.Value
+	}
+	return
+}
@@ -0,0 +1,15 @@
+namespace go scribe
+
+enum ResultCode {
+  OK,
+  TRY_LATER
+}
+
+struct LogEntry {
+  1: required string category,
+  2: required string message
+}
+
+service scribe {
+  ResultCode Log(1: required list<LogEntry> messages)
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"compress/zlib"
+	"flag"
 	"fmt"
+	"io"
 	"net"
 
 	"github.com/samuel/go-thrift/examples/scribe"
@@ -9,12 +12,19 @@ import (
 )
 
 func main() {
+	compress := flag.Bool("zlib", false, "compress messages with zlib")
+	flag.Parse()
+
 	conn, err := net.Dial("tcp", "127.0.0.1:1463")
 	if err != nil {
 		panic(err)
 	}
 
-	t := thrift.NewTransport(thrift.NewFramedReadWriteCloser(conn, 0), thrift.BinaryProtocol)
+	var rwc io.ReadWriteCloser = conn
+	if *compress {
+		rwc = thrift.NewZlibReadWriteCloser(conn, zlib.DefaultCompression)
+	}
+	t := thrift.NewTransport(thrift.NewFramedReadWriteCloser(rwc, 0), thrift.BinaryProtocol)
 	client := thrift.NewClient(t, false)
 	scr := scribe.ScribeClient{Client: client}
 	res, err := scr.Log([]*scribe.LogEntry{{Category: "category", Message: "message"}})
@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"net"
-	"net/rpc"
 
 	"github.com/samuel/go-thrift/examples/scribe"
 	"github.com/samuel/go-thrift/thrift"
@@ -22,20 +21,15 @@ func (s *scribeServiceImplementation) Log(messages []*scribe.LogEntry) (scribe.R
 
 func main() {
 	scribeService := new(scribeServiceImplementation)
-	rpc.RegisterName("Thrift", &scribe.ScribeServer{Implementation: scribeService})
 
 	ln, err := net.Listen("tcp", ":1463")
 	if err != nil {
 		panic(err)
 	}
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			fmt.Printf("ERROR: %+v\n", err)
-			continue
-		}
-		fmt.Printf("New connection %+v\n", conn)
-		t := thrift.NewTransport(thrift.NewFramedReadWriteCloser(conn, 0), thrift.BinaryProtocol)
-		go rpc.ServeCodec(thrift.NewServerCodec(t))
+	// A nil transport function accepts framed or unframed binary, compact,
+	// or JSON clients with or without zlib compression
+	server := thrift.NewServer(scribe.NewScribeProcessor(scribeService), nil)
+	if err := server.Serve(ln); err != nil {
+		fmt.Printf("ERROR: %+v\n", err)
 	}
 }
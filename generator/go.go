@@ -24,6 +24,7 @@ import (
 
 var (
 	flagGoBinarystring = flag.Bool("go.binarystring", false, "Always use string for binary instead of []byte")
+	flagGoContext      = flag.Bool("go.context", false, "Generate clients and services that take a context.Context as the first argument")
 	flagGoImportPrefix = flag.String("go.importprefix", "", "Prefix for Thrift-generated go package imports")
 	flagGoJSONEnumnum  = flag.Bool("go.json.enumnum", false, "For JSON marshal enums by number instead of name")
 	flagGoPointers     = flag.Bool("go.pointers", false, "Make all fields pointers")
@@ -60,6 +61,7 @@ type GoGenerator struct {
 	Format      bool
 	Pointers    bool
 	SignedBytes bool
+	Context     bool // generate client and service methods that take a context.Context
 }
 
 var goKeywords = map[string]bool{
@@ -90,22 +92,6 @@ var goKeywords = map[string]bool{
 	"var":         true,
 }
 
-var basicTypes = map[string]bool{
-	"byte":   true,
-	"bool":   true,
-	"string": true,
-	"i16":    true,
-	"i32":    true,
-	"i64":    true,
-	"double": true,
-}
-
-func init() {
-	if *flagGoBinarystring {
-		basicTypes["binary"] = true
-	}
-}
-
 func validGoIdent(id string) string {
 	if goKeywords[id] {
 		return "_" + id
@@ -254,6 +240,14 @@ func (g *GoGenerator) resolveType(typ *parser.Type) string {
 	return typ.Name
 }
 
+// isValuePointer reports whether the optional result field of a response
+// is a pointer to a value of typ rather than the value itself (e.g. for
+// basic types and enums without -go.pointers).
+func (g *GoGenerator) isValuePointer(typ *parser.Type) bool {
+	return strings.HasPrefix(g.formatType(g.pkg, g.thrift, typ, toOptional), "*") &&
+		!strings.HasPrefix(g.formatType(g.pkg, g.thrift, typ, 0), "*")
+}
+
 func (g *GoGenerator) formatField(field *parser.Field) string {
 	tags := ""
 	jsonTags := ""
@@ -283,6 +277,19 @@ func (g *GoGenerator) formatArguments(arguments []*parser.Field) string {
 	return strings.Join(args, ", ")
 }
 
+// formatContextArguments formats arguments for a service method, prefixed
+// with a context when generating with -go.context.
+func (g *GoGenerator) formatContextArguments(arguments []*parser.Field) string {
+	args := g.formatArguments(arguments)
+	if !g.Context {
+		return args
+	}
+	if args == "" {
+		return "ctx context.Context"
+	}
+	return "ctx context.Context, " + args
+}
+
 func (g *GoGenerator) formatReturnType(typ *parser.Type, named bool) string {
 	if typ == nil || typ.Name == "void" {
 		if named {
@@ -479,7 +486,7 @@ func (g *GoGenerator) writeService(out io.Writer, svc *parser.Service) error {
 		method := svc.Methods[k]
 		g.write(out,
 			"\t%s(%s) %s\n",
-			camelCase(method.Name), g.formatArguments(method.Arguments),
+			camelCase(method.Name), g.formatContextArguments(method.Arguments),
 			g.formatReturnType(method.ReturnType, false))
 	}
 	g.write(out, "}\n")
@@ -502,34 +509,14 @@ func (g *GoGenerator) writeService(out io.Writer, svc *parser.Service) error {
 			resArg = fmt.Sprintf(", res *%s%sResponse", svcName, mName)
 		}
 		g.write(out, "\nfunc (s *%sServer) %s(req *%s%sRequest%s) error {\n", svcName, mName, svcName, mName, resArg)
-		var args []string
-		for _, arg := range method.Arguments {
-			aName := camelCase(arg.Name)
-			args = append(args, "req."+aName)
-		}
-		isVoid := method.ReturnType == nil || method.ReturnType.Name == "void"
-		val := ""
-		if !isVoid {
-			val = "val, "
-		}
-		g.write(out, "\t%serr := s.Implementation.%s(%s)\n", val, mName, strings.Join(args, ", "))
-		if len(method.Exceptions) > 0 {
-			g.write(out, "\tswitch e := err.(type) {\n")
-			for _, ex := range method.Exceptions {
-				g.write(out, "\tcase %s:\n\t\tres.%s = e\n\t\terr = nil\n", g.formatType(g.pkg, g.thrift, ex.Type, 0), camelCase(ex.Name))
-			}
-			g.write(out, "\t}\n")
-		}
-		if !isVoid {
-			if !g.Pointers && basicTypes[g.resolveType(method.ReturnType)] {
-				g.write(out, "\tres.Value = &val\n")
-			} else {
-				g.write(out, "\tres.Value = val\n")
-			}
-		}
+		// net/rpc has no context to pass to the implementation
+		g.writeImplementationCall(out, svc, method, "s.Implementation", "context.Background()", "\t")
 		g.write(out, "\treturn err\n}\n")
 	}
 
+	g.writeProcessor(out, svc, methodNames)
+	g.writeIdempotentMethods(out, svc, methodNames)
+
 	for _, k := range methodNames {
 		// Request struct
 		method := svc.Methods[k]
@@ -565,13 +552,14 @@ func (g *GoGenerator) writeService(out io.Writer, svc *parser.Service) error {
 	for _, k := range methodNames {
 		method := svc.Methods[k]
 		methodName := camelCase(method.Name)
-		returnType := "err error"
+		returnType := "(err error)"
 		if !method.Oneway {
 			returnType = g.formatReturnType(method.ReturnType, true)
 		}
+		arguments := g.formatContextArguments(method.Arguments)
 		g.write(out, "\nfunc (s *%sClient) %s(%s) %s {\n",
 			svcName, methodName,
-			g.formatArguments(method.Arguments),
+			arguments,
 			returnType)
 
 		// Request
@@ -590,7 +578,11 @@ func (g *GoGenerator) writeService(out io.Writer, svc *parser.Service) error {
 		}
 
 		// Call
-		g.write(out, "\terr = s.Client.Call(\"%s\", req, res)\n", method.Name)
+		if g.Context {
+			g.write(out, "\terr = s.Client.Call(ctx, \"%s\", req, res)\n", method.Name)
+		} else {
+			g.write(out, "\terr = s.Client.Call(\"%s\", req, res)\n", method.Name)
+		}
 
 		// Exceptions
 		if len(method.Exceptions) > 0 {
@@ -603,7 +595,7 @@ func (g *GoGenerator) writeService(out io.Writer, svc *parser.Service) error {
 		}
 
 		if method.ReturnType != nil && method.ReturnType.Name != "void" {
-			if !g.Pointers && basicTypes[g.resolveType(method.ReturnType)] {
+			if g.isValuePointer(method.ReturnType) {
 				g.write(out, "\tif err == nil && res.Value != nil {\n\t ret = *res.Value\n}\n")
 			} else {
 				g.write(out, "\tif err == nil {\n\tret = res.Value\n}\n")
@@ -616,6 +608,147 @@ func (g *GoGenerator) writeService(out io.Writer, svc *parser.Service) error {
 	return nil
 }
 
+// writeImplementationCall writes the call of method on impl for a request
+// in req, setting the result and declared exceptions in res and leaving
+// any other error in err. ctx is the context passed with -go.context.
+func (g *GoGenerator) writeImplementationCall(out io.Writer, svc *parser.Service, method *parser.Method, impl, ctx, indent string) {
+	mName := camelCase(method.Name)
+	var args []string
+	if g.Context {
+		args = append(args, ctx)
+	}
+	for _, arg := range method.Arguments {
+		args = append(args, "req."+camelCase(arg.Name))
+	}
+	isVoid := method.ReturnType == nil || method.ReturnType.Name == "void"
+	val := ""
+	if !isVoid {
+		val = "val, "
+	}
+	g.write(out, "%s%serr := %s.%s(%s)\n", indent, val, impl, mName, strings.Join(args, ", "))
+	if len(method.Exceptions) > 0 {
+		g.write(out, "%sswitch e := err.(type) {\n", indent)
+		for _, ex := range method.Exceptions {
+			g.write(out, "%scase %s:\n%s\tres.%s = e\n%s\terr = nil\n",
+				indent, g.formatType(g.pkg, g.thrift, ex.Type, 0), indent, camelCase(ex.Name), indent)
+		}
+		g.write(out, "%s}\n", indent)
+	}
+	if !isVoid {
+		if g.isValuePointer(method.ReturnType) {
+			g.write(out, "%sres.Value = &val\n", indent)
+		} else {
+			g.write(out, "%sres.Value = val\n", indent)
+		}
+	}
+}
+
+// writeIdempotentMethods writes the table of methods annotated with
+// (idempotent = "true") that a retrying client may call again on failure.
+func (g *GoGenerator) writeIdempotentMethods(out io.Writer, svc *parser.Service, methodNames []string) {
+	svcName := camelCase(svc.Name)
+	g.write(out, "\n// %sIdempotentMethods are the methods of %s that are safe to retry.\n", svcName, svcName)
+	g.write(out, "var %sIdempotentMethods = map[string]bool{\n", svcName)
+	for _, k := range methodNames {
+		method := svc.Methods[k]
+		if isIdempotent(method) {
+			g.write(out, "\t\"%s\": true,\n", method.Name)
+		}
+	}
+	g.write(out, "}\n")
+	if svc.Extends != "" {
+		g.write(out, "\nfunc init() {\n\tfor k, v := range %sIdempotentMethods {\n\t\t%sIdempotentMethods[k] = v\n\t}\n}\n",
+			camelCase(svc.Extends), svcName)
+	}
+}
+
+func isIdempotent(method *parser.Method) bool {
+	for _, a := range method.Annotations {
+		if a.Name == "idempotent" {
+			v, _ := strconv.ParseBool(a.Value)
+			return v
+		}
+	}
+	return false
+}
+
+// writeProcessor writes a processor for svc that can be used with
+// thrift.Server.
+func (g *GoGenerator) writeProcessor(out io.Writer, svc *parser.Service, methodNames []string) {
+	svcName := camelCase(svc.Name)
+	parent := ""
+	if svc.Extends != "" {
+		parent = camelCase(svc.Extends)
+		if i := strings.LastIndex(parent, "."); i >= 0 {
+			parent = parent[:i+1] + "New" + parent[i+1:] + "Processor"
+		} else {
+			parent = "New" + parent + "Processor"
+		}
+	}
+
+	g.write(out, "\ntype %sProcessor struct {\n\tServer *%sServer\n", svcName, svcName)
+	if parent != "" {
+		g.write(out, "\tparent interface {\n"+
+			"\t\tNewRequest(method string) interface{}\n"+
+			"\t\tProcess(ctx context.Context, method string, req interface{}) (interface{}, error)\n"+
+			"\t}\n")
+	}
+	g.write(out, "}\n")
+
+	g.write(out, "\nfunc New%sProcessor(impl %s) *%sProcessor {\n\treturn &%sProcessor{\n\t\tServer: &%sServer{Implementation: impl},\n",
+		svcName, svcName, svcName, svcName, svcName)
+	if parent != "" {
+		g.write(out, "\t\tparent: %s(impl),\n", parent)
+	}
+	g.write(out, "\t}\n}\n")
+
+	g.write(out, "\nfunc (p *%sProcessor) NewRequest(method string) interface{} {\n\tswitch method {\n", svcName)
+	for _, k := range methodNames {
+		method := svc.Methods[k]
+		g.write(out, "\tcase \"%s\":\n\t\treturn &%s%sRequest{}\n", method.Name, svcName, camelCase(method.Name))
+	}
+	g.write(out, "\t}\n")
+	if parent != "" {
+		g.write(out, "\treturn p.parent.NewRequest(method)\n}\n")
+	} else {
+		g.write(out, "\treturn nil\n}\n")
+	}
+
+	g.write(out, "\nfunc (p *%sProcessor) Process(ctx context.Context, method string, req interface{}) (interface{}, error) {\n\tswitch method {\n", svcName)
+	for _, k := range methodNames {
+		method := svc.Methods[k]
+		mName := camelCase(method.Name)
+		g.write(out, "\tcase \"%s\":\n", method.Name)
+		switch {
+		case g.Context:
+			// Call the implementation directly so it gets the request's context
+			if len(method.Arguments) > 0 {
+				g.write(out, "\t\treq := req.(*%s%sRequest)\n", svcName, mName)
+			}
+			if !method.Oneway {
+				g.write(out, "\t\tres := &%s%sResponse{}\n", svcName, mName)
+			}
+			g.writeImplementationCall(out, svc, method, "p.Server.Implementation", "ctx", "\t\t")
+			if method.Oneway {
+				g.write(out, "\t\treturn nil, err\n")
+			} else {
+				g.write(out, "\t\treturn res, err\n")
+			}
+		case method.Oneway:
+			g.write(out, "\t\treturn nil, p.Server.%s(req.(*%s%sRequest))\n", mName, svcName, mName)
+		default:
+			g.write(out, "\t\tres := &%s%sResponse{}\n\t\terr := p.Server.%s(req.(*%s%sRequest), res)\n\t\treturn res, err\n",
+				svcName, mName, mName, svcName, mName)
+		}
+	}
+	g.write(out, "\t}\n")
+	if parent != "" {
+		g.write(out, "\treturn p.parent.Process(ctx, method, req)\n}\n")
+	} else {
+		g.write(out, "\treturn nil, fmt.Errorf(\"unknown method %%s\", method)\n}\n")
+	}
+}
+
 func (g *GoGenerator) generateSingle(out io.Writer, thriftPath string, thrift *parser.Thrift) {
 	packageName := g.Packages[thriftPath].Name
 	g.thrift = thrift
@@ -626,6 +759,9 @@ func (g *GoGenerator) generateSingle(out io.Writer, thriftPath string, thrift *p
 
 	// Imports
 	imports := []string{"fmt"}
+	if len(thrift.Services) > 0 {
+		imports = []string{"context", "fmt"}
+	}
 	if len(thrift.Enums) > 0 {
 		imports = append(imports, "strconv")
 	}
@@ -801,9 +937,15 @@ func (g *GoGenerator) Generate(outPath string) (err error) {
 			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 			os.Exit(2)
 		}
-		_, err = fi.WriteString(fmt.Sprintf("package %s\n\ntype RPCClient interface {\n"+
-			"\tCall(method string, request interface{}, response interface{}) error\n"+
-			"}\n", name))
+		if g.Context {
+			_, err = fi.WriteString(fmt.Sprintf("package %s\n\nimport \"context\"\n\ntype RPCClient interface {\n"+
+				"\tCall(ctx context.Context, method string, request interface{}, response interface{}) error\n"+
+				"}\n", name))
+		} else {
+			_, err = fi.WriteString(fmt.Sprintf("package %s\n\ntype RPCClient interface {\n"+
+				"\tCall(method string, request interface{}, response interface{}) error\n"+
+				"}\n", name))
+		}
 		fi.Close()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
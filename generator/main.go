@@ -90,6 +90,7 @@ func main() {
 		ThriftFiles: parsedThrift,
 		Format:      true,
 		SignedBytes: *flagGoSignedBytes,
+		Context:     *flagGoContext,
 	}
 	err = generator.Generate(outpath)
 	if err != nil {
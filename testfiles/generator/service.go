@@ -0,0 +1,189 @@
+// This file is automatically generated. Do not modify.
+
+package gentest
+
+import (
+	"context"
+	"fmt"
+)
+
+var _ = fmt.Sprintf
+
+type Base interface {
+	Version() (*int32, error)
+}
+
+type BaseServer struct {
+	Implementation Base
+}
+
+func (s *BaseServer) Version(req *BaseVersionRequest, res *BaseVersionResponse) error {
+	val, err := s.Implementation.Version()
+	res.Value = val
+	return err
+}
+
+type BaseProcessor struct {
+	Server *BaseServer
+}
+
+func NewBaseProcessor(impl Base) *BaseProcessor {
+	return &BaseProcessor{
+		Server: &BaseServer{Implementation: impl},
+	}
+}
+
+func (p *BaseProcessor) NewRequest(method string) interface{} {
+	switch method {
+	case "version":
+		return &BaseVersionRequest{}
+	}
+	return nil
+}
+
+func (p *BaseProcessor) Process(ctx context.Context, method string, req interface{}) (interface{}, error) {
+	switch method {
+	case "version":
+		res := &BaseVersionResponse{}
+		err := p.Server.Version(req.(*BaseVersionRequest), res)
+		return res, err
+	}
+	return nil, fmt.Errorf("unknown method %s", method)
+}
+
+// BaseIdempotentMethods are the methods of Base that are safe to retry.
+var BaseIdempotentMethods = map[string]bool{
+	"version": true,
+}
+
+type BaseVersionRequest struct {
+}
+
+type BaseVersionResponse struct {
+	Value *int32 `thrift:"0" json:"value,omitempty"`
+}
+
+type BaseClient struct {
+	Client RPCClient
+}
+
+func (s *BaseClient) Version() (ret *int32, err error) {
+	req := &BaseVersionRequest{}
+	res := &BaseVersionResponse{}
+	err = s.Client.Call("version", req, res)
+	if err == nil {
+		ret = res.Value
+	}
+	return
+}
+
+type Echo interface {
+	Base
+	Echo(msg *string) (*string, error)
+	Notify(code *int32) error
+}
+
+type EchoServer struct {
+	BaseServer
+	Implementation Echo
+}
+
+func (s *EchoServer) Echo(req *EchoEchoRequest, res *EchoEchoResponse) error {
+	val, err := s.Implementation.Echo(req.Msg)
+	res.Value = val
+	return err
+}
+
+func (s *EchoServer) Notify(req *EchoNotifyRequest) error {
+	err := s.Implementation.Notify(req.Code)
+	return err
+}
+
+type EchoProcessor struct {
+	Server *EchoServer
+	parent interface {
+		NewRequest(method string) interface{}
+		Process(ctx context.Context, method string, req interface{}) (interface{}, error)
+	}
+}
+
+func NewEchoProcessor(impl Echo) *EchoProcessor {
+	return &EchoProcessor{
+		Server: &EchoServer{Implementation: impl},
+		parent: NewBaseProcessor(impl),
+	}
+}
+
+func (p *EchoProcessor) NewRequest(method string) interface{} {
+	switch method {
+	case "echo":
+		return &EchoEchoRequest{}
+	case "notify":
+		return &EchoNotifyRequest{}
+	}
+	return p.parent.NewRequest(method)
+}
+
+func (p *EchoProcessor) Process(ctx context.Context, method string, req interface{}) (interface{}, error) {
+	switch method {
+	case "echo":
+		res := &EchoEchoResponse{}
+		err := p.Server.Echo(req.(*EchoEchoRequest), res)
+		return res, err
+	case "notify":
+		return nil, p.Server.Notify(req.(*EchoNotifyRequest))
+	}
+	return p.parent.Process(ctx, method, req)
+}
+
+// EchoIdempotentMethods are the methods of Echo that are safe to retry.
+var EchoIdempotentMethods = map[string]bool{
+	"echo": true,
+}
+
+func init() {
+	for k, v := range BaseIdempotentMethods {
+		EchoIdempotentMethods[k] = v
+	}
+}
+
+type EchoEchoRequest struct {
+	Msg *string `thrift:"1,required" json:"msg"`
+}
+
+type EchoEchoResponse struct {
+	Value *string `thrift:"0" json:"value,omitempty"`
+}
+
+type EchoNotifyRequest struct {
+	Code *int32 `thrift:"1,required" json:"code"`
+}
+
+func (r *EchoNotifyRequest) Oneway() bool {
+	return true
+}
+
+type EchoClient struct {
+	BaseClient
+}
+
+func (s *EchoClient) Echo(msg *string) (ret *string, err error) {
+	req := &EchoEchoRequest{
+		Msg: msg,
+	}
+	res := &EchoEchoResponse{}
+	err = s.Client.Call("echo", req, res)
+	if err == nil {
+		ret = res.Value
+	}
+	return
+}
+
+func (s *EchoClient) Notify(code *int32) (err error) {
+	req := &EchoNotifyRequest{
+		Code: code,
+	}
+	var res interface{} = nil
+	err = s.Client.Call("notify", req, res)
+	return
+}
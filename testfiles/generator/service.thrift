@@ -0,0 +1,10 @@
+namespace go gentest
+
+service Base {
+  i32 version() (idempotent = "true")
+}
+
+service Echo extends Base {
+  string echo(1: string msg) (idempotent = "true"),
+  oneway void notify(1: i32 code)
+}
@@ -0,0 +1,7 @@
+package gentest
+
+import "context"
+
+type RPCClient interface {
+	Call(ctx context.Context, method string, request interface{}, response interface{}) error
+}
@@ -0,0 +1,165 @@
+// This file is automatically generated. Do not modify.
+
+package gentest
+
+import (
+	"context"
+	"fmt"
+)
+
+var _ = fmt.Sprintf
+
+type NotFound struct {
+	Key string `thrift:"1,required" json:"key"`
+}
+
+func (e *NotFound) Error() string {
+	return fmt.Sprintf("NotFound{Key: %+v}", e.Key)
+}
+
+type Store interface {
+	Get(ctx context.Context, key string) (string, error)
+	Ping(ctx context.Context) error
+	Put(ctx context.Context, key string, value string) error
+}
+
+type StoreServer struct {
+	Implementation Store
+}
+
+func (s *StoreServer) Get(req *StoreGetRequest, res *StoreGetResponse) error {
+	val, err := s.Implementation.Get(context.Background(), req.Key)
+	switch e := err.(type) {
+	case *NotFound:
+		res.Nf = e
+		err = nil
+	}
+	res.Value = &val
+	return err
+}
+
+func (s *StoreServer) Ping(req *StorePingRequest, res *StorePingResponse) error {
+	err := s.Implementation.Ping(context.Background())
+	return err
+}
+
+func (s *StoreServer) Put(req *StorePutRequest) error {
+	err := s.Implementation.Put(context.Background(), req.Key, req.Value)
+	return err
+}
+
+type StoreProcessor struct {
+	Server *StoreServer
+}
+
+func NewStoreProcessor(impl Store) *StoreProcessor {
+	return &StoreProcessor{
+		Server: &StoreServer{Implementation: impl},
+	}
+}
+
+func (p *StoreProcessor) NewRequest(method string) interface{} {
+	switch method {
+	case "get":
+		return &StoreGetRequest{}
+	case "ping":
+		return &StorePingRequest{}
+	case "put":
+		return &StorePutRequest{}
+	}
+	return nil
+}
+
+func (p *StoreProcessor) Process(ctx context.Context, method string, req interface{}) (interface{}, error) {
+	switch method {
+	case "get":
+		req := req.(*StoreGetRequest)
+		res := &StoreGetResponse{}
+		val, err := p.Server.Implementation.Get(ctx, req.Key)
+		switch e := err.(type) {
+		case *NotFound:
+			res.Nf = e
+			err = nil
+		}
+		res.Value = &val
+		return res, err
+	case "ping":
+		res := &StorePingResponse{}
+		err := p.Server.Implementation.Ping(ctx)
+		return res, err
+	case "put":
+		req := req.(*StorePutRequest)
+		err := p.Server.Implementation.Put(ctx, req.Key, req.Value)
+		return nil, err
+	}
+	return nil, fmt.Errorf("unknown method %s", method)
+}
+
+// StoreIdempotentMethods are the methods of Store that are safe to retry.
+var StoreIdempotentMethods = map[string]bool{
+	"get":  true,
+	"ping": true,
+}
+
+type StoreGetRequest struct {
+	Key string `thrift:"1,required" json:"key"`
+}
+
+type StoreGetResponse struct {
+	Value *string   `thrift:"0" json:"value,omitempty"`
+	Nf    *NotFound `thrift:"1" json:"nf,omitempty"`
+}
+
+type StorePingRequest struct {
+}
+
+type StorePingResponse struct {
+}
+
+type StorePutRequest struct {
+	Key   string `thrift:"1,required" json:"key"`
+	Value string `thrift:"2,required" json:"value"`
+}
+
+func (r *StorePutRequest) Oneway() bool {
+	return true
+}
+
+type StoreClient struct {
+	Client RPCClient
+}
+
+func (s *StoreClient) Get(ctx context.Context, key string) (ret string, err error) {
+	req := &StoreGetRequest{
+		Key: key,
+	}
+	res := &StoreGetResponse{}
+	err = s.Client.Call(ctx, "get", req, res)
+	if err == nil {
+		switch {
+		case res.Nf != nil:
+			err = res.Nf
+		}
+	}
+	if err == nil && res.Value != nil {
+		ret = *res.Value
+	}
+	return
+}
+
+func (s *StoreClient) Ping(ctx context.Context) (err error) {
+	req := &StorePingRequest{}
+	res := &StorePingResponse{}
+	err = s.Client.Call(ctx, "ping", req, res)
+	return
+}
+
+func (s *StoreClient) Put(ctx context.Context, key string, value string) (err error) {
+	req := &StorePutRequest{
+		Key:   key,
+		Value: value,
+	}
+	var res interface{} = nil
+	err = s.Client.Call(ctx, "put", req, res)
+	return
+}
@@ -0,0 +1,11 @@
+namespace go gentest
+
+exception NotFound {
+  1: string key
+}
+
+service Store {
+  string get(1: string key) throws (1: NotFound nf) (idempotent = "true"),
+  void ping() (idempotent = "true"),
+  oneway void put(1: string key, 2: string value)
+}
@@ -0,0 +1,353 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"errors"
+	"math/rand"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoHosts is the error returned by a Balancer when there are no hosts
+// available to make a call.
+var ErrNoHosts = errors.New("thrift.balancer: no hosts available")
+
+// DefaultProbeInterval is how often a Balancer tries to connect to hosts
+// that are down when BalancerConfig.ProbeInterval is not set.
+const DefaultProbeInterval = time.Second
+
+// Host is a server that a Balancer sends calls to.
+type Host struct {
+	addr    string
+	pending int32
+	down    int32
+	pool    *Pool
+}
+
+// Addr returns the address of the host.
+func (h *Host) Addr() string {
+	return h.addr
+}
+
+// Pending returns the number of calls in progress to the host.
+func (h *Host) Pending() int {
+	return int(atomic.LoadInt32(&h.pending))
+}
+
+// Up returns true if the host isn't marked down.
+func (h *Host) Up() bool {
+	return atomic.LoadInt32(&h.down) == 0
+}
+
+func (h *Host) setDown(down bool) {
+	v := int32(0)
+	if down {
+		v = 1
+	}
+	atomic.StoreInt32(&h.down, v)
+}
+
+// Picker chooses the host for a call. hosts is never empty and only
+// contains hosts that are up.
+type Picker interface {
+	Pick(hosts []*Host) *Host
+}
+
+type roundRobinPicker struct {
+	next uint64
+}
+
+// NewRoundRobinPicker returns a Picker that uses each host in turn.
+func NewRoundRobinPicker() Picker {
+	return &roundRobinPicker{}
+}
+
+func (p *roundRobinPicker) Pick(hosts []*Host) *Host {
+	n := atomic.AddUint64(&p.next, 1)
+	return hosts[(n-1)%uint64(len(hosts))]
+}
+
+type leastPendingPicker struct{}
+
+// NewLeastPendingPicker returns a Picker that chooses the host with the
+// fewest calls in progress.
+func NewLeastPendingPicker() Picker {
+	return leastPendingPicker{}
+}
+
+func (leastPendingPicker) Pick(hosts []*Host) *Host {
+	best := hosts[0]
+	for _, h := range hosts[1:] {
+		if h.Pending() < best.Pending() {
+			best = h
+		}
+	}
+	return best
+}
+
+type p2cPicker struct{}
+
+// NewP2CPicker returns a Picker that chooses two hosts at random and uses
+// the one with fewer calls in progress (power of two choices).
+func NewP2CPicker() Picker {
+	return p2cPicker{}
+}
+
+func (p2cPicker) Pick(hosts []*Host) *Host {
+	if len(hosts) == 1 {
+		return hosts[0]
+	}
+	i := rand.Intn(len(hosts))
+	j := rand.Intn(len(hosts) - 1)
+	if j >= i {
+		j++
+	}
+	if hosts[j].Pending() < hosts[i].Pending() {
+		return hosts[j]
+	}
+	return hosts[i]
+}
+
+// BalancerConfig configures a Balancer.
+type BalancerConfig struct {
+	// Dial opens a connection to addr. It defaults to a framed binary
+	// protocol connection over TCP.
+	Dial func(addr string) (*rpc.Client, error)
+	// Picker chooses the host for each call. It defaults to round-robin.
+	Picker Picker
+	// Pool configures the connection pool for each host.
+	Pool PoolConfig
+	// ProbeInterval is how often hosts that are down are probed by
+	// connecting to them. It defaults to DefaultProbeInterval.
+	ProbeInterval time.Duration
+	// ResolveInterval is how often the resolver is called to update the
+	// list of hosts. 0 means the hosts are only resolved once.
+	ResolveInterval time.Duration
+}
+
+// Balancer is a client that implements the RPCClient interface of
+// generated clients by spreading calls across a set of hosts. A host is
+// marked down when connecting to it fails or the first call on a new
+// connection returns a transport error, and is probed in the background
+// until it can be connected to again. Transport errors on connections that
+// were already used (e.g. closed by the server while idle) only close the
+// connection. Calls that fail to connect are retried on another host since the
+// request was never sent.
+type Balancer struct {
+	resolver Resolver
+	cfg      BalancerConfig
+
+	mu     sync.RWMutex
+	hosts  map[string]*Host
+	list   []*Host // hosts in the order returned by the resolver
+	closed bool
+	done   chan struct{}
+}
+
+// hostDialError wraps errors from connecting to a host so Call can tell
+// that the request wasn't sent.
+type hostDialError struct {
+	err error
+}
+
+func (e hostDialError) Error() string {
+	return e.err.Error()
+}
+
+// NewBalancer returns a Balancer for the hosts returned by r.
+func NewBalancer(r Resolver, cfg BalancerConfig) (*Balancer, error) {
+	if cfg.Dial == nil {
+		cfg.Dial = func(addr string) (*rpc.Client, error) {
+			return Dial("tcp", addr, true, BinaryProtocol, false)
+		}
+	}
+	if cfg.Picker == nil {
+		cfg.Picker = NewRoundRobinPicker()
+	}
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = DefaultProbeInterval
+	}
+	b := &Balancer{
+		resolver: r,
+		cfg:      cfg,
+		hosts:    make(map[string]*Host),
+		done:     make(chan struct{}),
+	}
+	if err := b.resolve(); err != nil {
+		return nil, err
+	}
+	go b.maintain()
+	return b, nil
+}
+
+// Call makes a request to a host chosen by the picker.
+func (b *Balancer) Call(method string, request interface{}, response interface{}) error {
+	var tried map[*Host]bool
+	var lastErr error
+	for {
+		h := b.pick(tried)
+		if h == nil {
+			if lastErr != nil {
+				return lastErr
+			}
+			return ErrNoHosts
+		}
+		atomic.AddInt32(&h.pending, 1)
+		first, err := h.pool.call(method, request, response)
+		atomic.AddInt32(&h.pending, -1)
+		switch e := err.(type) {
+		case hostDialError:
+			h.setDown(true)
+			lastErr = e.err
+		default:
+			if err != ErrPoolClosed {
+				if first && isConnError(err) {
+					h.setDown(true)
+				}
+				return err
+			}
+			// The host was removed by the resolver before the request was sent
+		}
+		if tried == nil {
+			tried = make(map[*Host]bool)
+		}
+		tried[h] = true
+	}
+}
+
+// Hosts returns the current hosts.
+func (b *Balancer) Hosts() []*Host {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]*Host(nil), b.list...)
+}
+
+// Close closes the connections to all hosts.
+func (b *Balancer) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	hosts := b.list
+	b.hosts = make(map[string]*Host)
+	b.list = nil
+	b.mu.Unlock()
+
+	for _, h := range hosts {
+		h.pool.Close()
+	}
+	return nil
+}
+
+func (b *Balancer) pick(tried map[*Host]bool) *Host {
+	b.mu.RLock()
+	up := make([]*Host, 0, len(b.list))
+	for _, h := range b.list {
+		if h.Up() && !tried[h] {
+			up = append(up, h)
+		}
+	}
+	b.mu.RUnlock()
+	if len(up) == 0 {
+		return nil
+	}
+	return b.cfg.Picker.Pick(up)
+}
+
+func (b *Balancer) newHost(addr string) *Host {
+	dial := b.cfg.Dial
+	return &Host{
+		addr: addr,
+		pool: NewPool(func() (*rpc.Client, error) {
+			c, err := dial(addr)
+			if err != nil {
+				return nil, hostDialError{err}
+			}
+			return c, nil
+		}, b.cfg.Pool),
+	}
+}
+
+// resolve updates the hosts from the resolver.
+func (b *Balancer) resolve() error {
+	addrs, err := b.resolver.Resolve()
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	hosts := make(map[string]*Host, len(addrs))
+	list := make([]*Host, 0, len(addrs))
+	for _, addr := range addrs {
+		if hosts[addr] != nil {
+			continue
+		}
+		h := b.hosts[addr]
+		if h == nil {
+			h = b.newHost(addr)
+		}
+		hosts[addr] = h
+		list = append(list, h)
+	}
+	var removed []*Host
+	for addr, h := range b.hosts {
+		if hosts[addr] == nil {
+			removed = append(removed, h)
+		}
+	}
+	b.hosts = hosts
+	b.list = list
+	b.mu.Unlock()
+
+	for _, h := range removed {
+		h.pool.Close()
+	}
+	return nil
+}
+
+// probe tries to connect to hosts that are down and marks them up if it
+// succeeds.
+func (b *Balancer) probe() {
+	for _, h := range b.Hosts() {
+		if h.Up() {
+			continue
+		}
+		if c, err := b.cfg.Dial(h.addr); err == nil {
+			c.Close()
+			h.setDown(false)
+		}
+	}
+}
+
+func (b *Balancer) maintain() {
+	probe := time.NewTicker(b.cfg.ProbeInterval)
+	defer probe.Stop()
+	var resolve <-chan time.Time
+	if b.cfg.ResolveInterval > 0 {
+		t := time.NewTicker(b.cfg.ResolveInterval)
+		defer t.Stop()
+		resolve = t.C
+	}
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-probe.C:
+			b.probe()
+		case <-resolve:
+			// Errors leave the current hosts in place
+			b.resolve()
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/rpc"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// hostTestService replies with the ID of the server.
+type hostTestService struct {
+	id int32
+}
+
+func (s *hostTestService) Success(req *TestRequest, res *TestResponse) error {
+	res.Value = s.id
+	return nil
+}
+
+func startHostServer(id int32) string {
+	srv := rpc.NewServer()
+	srv.RegisterName("Thrift", &hostTestService{id})
+	l, addr := listenTCP()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go srv.ServeCodec(NewServerCodec(NewTransport(NewFramedReadWriteCloser(conn, 0), BinaryProtocol)))
+		}
+	}()
+	return addr
+}
+
+type testResolver struct {
+	mu    sync.Mutex
+	addrs []string
+}
+
+func (r *testResolver) Resolve() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.addrs, nil
+}
+
+func (r *testResolver) set(addrs ...string) {
+	r.mu.Lock()
+	r.addrs = addrs
+	r.mu.Unlock()
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	addrs := []string{startHostServer(1), startHostServer(2)}
+	b, err := NewBalancer(StaticResolver(addrs), BalancerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	counts := make(map[int32]int)
+	for i := 0; i < 4; i++ {
+		res := &TestResponse{}
+		if err := b.Call("Success", &TestRequest{}, res); err != nil {
+			t.Fatal(err)
+		}
+		counts[res.Value]++
+	}
+	if !reflect.DeepEqual(counts, map[int32]int{1: 2, 2: 2}) {
+		t.Fatalf("Expected calls to alternate between hosts instead of %+v", counts)
+	}
+}
+
+func TestBalancerFailover(t *testing.T) {
+	addr := startHostServer(1)
+	const badAddr = "bad:1"
+	var allowBad int32
+	dial := func(a string) (*rpc.Client, error) {
+		if a == badAddr {
+			if atomic.LoadInt32(&allowBad) == 0 {
+				return nil, errors.New("connection refused")
+			}
+			a = addr
+		}
+		return Dial("tcp", a, true, BinaryProtocol, false)
+	}
+	b, err := NewBalancer(StaticResolver{badAddr, addr}, BalancerConfig{
+		Dial:          dial,
+		ProbeInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for i := 0; i < 4; i++ {
+		if err := b.Call("Success", &TestRequest{}, &TestResponse{}); err != nil {
+			t.Fatalf("Expected the call to fail over instead of %+v", err)
+		}
+	}
+	hosts := b.Hosts()
+	if hosts[0].Addr() != badAddr || hosts[0].Up() {
+		t.Fatalf("Expected %s to be marked down", badAddr)
+	}
+	if !hosts[1].Up() {
+		t.Fatalf("Expected %s to be up", addr)
+	}
+
+	// Probing brings the host back up
+	atomic.StoreInt32(&allowBad, 1)
+	for i := 0; i < 100 && !hosts[0].Up(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !hosts[0].Up() {
+		t.Fatalf("Expected %s to be probed back up", badAddr)
+	}
+
+	// All hosts down
+	b2, err := NewBalancer(StaticResolver{badAddr}, BalancerConfig{
+		Dial: func(string) (*rpc.Client, error) { return nil, errors.New("connection refused") },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b2.Close()
+	if err := b2.Call("Success", &TestRequest{}, &TestResponse{}); err == nil || err.Error() != "connection refused" {
+		t.Fatalf("Expected the dial error instead of %+v", err)
+	}
+	if err := b2.Call("Success", &TestRequest{}, &TestResponse{}); err != ErrNoHosts {
+		t.Fatalf("Expected ErrNoHosts instead of %+v", err)
+	}
+}
+
+func TestBalancerResolve(t *testing.T) {
+	addr1 := startHostServer(1)
+	addr2 := startHostServer(2)
+	r := &testResolver{addrs: []string{addr1}}
+	b, err := NewBalancer(r, BalancerConfig{ResolveInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	res := &TestResponse{}
+	if err := b.Call("Success", &TestRequest{}, res); err != nil {
+		t.Fatal(err)
+	} else if res.Value != 1 {
+		t.Fatalf("Expected host 1 instead of %d", res.Value)
+	}
+
+	r.set(addr2)
+	for i := 0; i < 100; i++ {
+		if h := b.Hosts(); len(h) == 1 && h[0].Addr() == addr2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := b.Call("Success", &TestRequest{}, res); err != nil {
+		t.Fatal(err)
+	} else if res.Value != 2 {
+		t.Fatalf("Expected host 2 instead of %d", res.Value)
+	}
+}
+
+func TestBalancerStaleConn(t *testing.T) {
+	addr := startHostServer(1)
+	var mu sync.Mutex
+	var conns []net.Conn
+	var failFirst bool
+	dial := func(a string) (*rpc.Client, error) {
+		conn, err := net.Dial("tcp", a)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		conns = append(conns, conn)
+		if failFirst {
+			conn.Close()
+		}
+		mu.Unlock()
+		return NewClient(NewTransport(NewFramedReadWriteCloser(conn, 0), BinaryProtocol), false), nil
+	}
+	b, err := NewBalancer(StaticResolver{addr}, BalancerConfig{Dial: dial, ProbeInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.Call("Success", &TestRequest{}, &TestResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pooled connection is broken
+	mu.Lock()
+	conns[0].Close()
+	mu.Unlock()
+	if err := b.Call("Success", &TestRequest{}, &TestResponse{}); err == nil {
+		t.Fatal("Expected the call on the closed connection to fail")
+	}
+	host := b.Hosts()[0]
+	if !host.Up() {
+		t.Fatal("Expected an error on a pooled connection to leave the host up")
+	}
+	if err := b.Call("Success", &TestRequest{}, &TestResponse{}); err != nil {
+		t.Fatalf("Expected the call to use a new connection instead of %+v", err)
+	}
+
+	// A new connection that fails right away marks the host down
+	mu.Lock()
+	failFirst = true
+	conns[1].Close()
+	mu.Unlock()
+	b.Call("Success", &TestRequest{}, &TestResponse{})
+	if err := b.Call("Success", &TestRequest{}, &TestResponse{}); err == nil {
+		t.Fatal("Expected the call on the new connection to fail")
+	}
+	if host.Up() {
+		t.Fatal("Expected an error on a new connection to mark the host down")
+	}
+}
+
+func TestPickers(t *testing.T) {
+	hosts := []*Host{{addr: "a", pending: 3}, {addr: "b", pending: 1}, {addr: "c", pending: 2}}
+	if h := NewLeastPendingPicker().Pick(hosts); h.Addr() != "b" {
+		t.Fatalf("Expected least pending host b instead of %s", h.Addr())
+	}
+	// With two hosts power of two choices always picks the least pending
+	for i := 0; i < 10; i++ {
+		if h := NewP2CPicker().Pick(hosts[1:]); h.Addr() != "b" {
+			t.Fatalf("Expected host b instead of %s", h.Addr())
+		}
+	}
+	rr := NewRoundRobinPicker()
+	for i, exp := range []string{"a", "b", "c", "a"} {
+		if h := rr.Pick(hosts); h.Addr() != exp {
+			t.Fatalf("Expected pick %d to be %s instead of %s", i, exp, h.Addr())
+		}
+	}
+	// The counter wrapping around stays in range
+	rr = &roundRobinPicker{next: math.MaxUint64 - 1}
+	for i, exp := range []string{"c", "a", "a", "b"} {
+		if h := rr.Pick(hosts); h.Addr() != exp {
+			t.Fatalf("Expected pick %d after wrapping to be %s instead of %s", i, exp, h.Addr())
+		}
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	f, err := ioutil.TempFile("", "go-thrift-hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# hosts\nhost1:9160\n\n  host2:9160  \n")
+	f.Close()
+
+	addrs, err := FileResolver(f.Name()).Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"host1:9160", "host2:9160"}; !reflect.DeepEqual(addrs, exp) {
+		t.Fatalf("Expected %+v instead of %+v", exp, addrs)
+	}
+}
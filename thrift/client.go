@@ -45,6 +45,10 @@ func Dial(network, address string, framed bool, protocol ProtocolBuilder, suppor
 	if err != nil {
 		return nil, err
 	}
+	return newConnClient(conn, framed, protocol, supportOnewayRequests), nil
+}
+
+func newConnClient(conn net.Conn, framed bool, protocol ProtocolBuilder, supportOnewayRequests bool) *rpc.Client {
 	var c io.ReadWriteCloser = conn
 	if framed {
 		c = NewFramedReadWriteCloser(conn, DefaultMaxFrameSize)
@@ -57,7 +61,7 @@ func Dial(network, address string, framed bool, protocol ProtocolBuilder, suppor
 		codec.onewayRequests = make(chan pendingRequest, maxPendingRequests)
 		codec.twowayRequests = make(chan pendingRequest, maxPendingRequests)
 	}
-	return rpc.NewClientWithCodec(codec), nil
+	return rpc.NewClientWithCodec(codec)
 }
 
 // NewClient returns a new rpc.Client to handle requests to the set of
@@ -80,22 +84,20 @@ func NewClientCodec(conn Transport, supportOnewayRequests bool) rpc.ClientCodec
 }
 
 func (c *clientCodec) WriteRequest(request *rpc.Request, thriftStruct interface{}) error {
-	if err := c.conn.WriteMessageBegin(request.ServiceMethod, MessageTypeCall, int32(request.Seq)); err != nil {
-		return err
+	ow := false
+	if o, ok := thriftStruct.(oneway); ok {
+		ow = o.Oneway()
 	}
-	if err := EncodeStruct(c.conn, thriftStruct); err != nil {
-		return err
+	if ow && !c.enableOneway {
+		return ErrOnewayNotEnabled
 	}
-	if err := c.conn.WriteMessageEnd(); err != nil {
-		return err
+	mtype := byte(MessageTypeCall)
+	if ow {
+		mtype = MessageTypeOneway
 	}
-	if err := c.conn.Flush(); err != nil {
+	if err := writeMessage(c.conn, request.ServiceMethod, mtype, int32(request.Seq), thriftStruct); err != nil {
 		return err
 	}
-	ow := false
-	if o, ok := thriftStruct.(oneway); ok {
-		ow = o.Oneway()
-	}
 	if c.enableOneway {
 		var err error
 		if ow {
@@ -114,8 +116,6 @@ func (c *clientCodec) WriteRequest(request *rpc.Request, thriftStruct interface{
 		if err != nil {
 			return err
 		}
-	} else if ow {
-		return ErrOnewayNotEnabled
 	}
 
 	return nil
@@ -163,6 +163,18 @@ func (c *clientCodec) ReadResponseBody(thriftStruct interface{}) error {
 	return c.conn.ReadMessageEnd()
 }
 
+// ReadHeaders returns the headers of the last reply read if the transport
+// carries them.
+func (c *clientCodec) ReadHeaders() map[string]string {
+	return readCodecHeaders(c.conn)
+}
+
+// SetWriteHeader sets a header to send with the next request if the
+// transport carries them.
+func (c *clientCodec) SetWriteHeader(key, value string) {
+	setCodecHeader(c.conn, key, value)
+}
+
 func (c *clientCodec) Close() error {
 	if cl, ok := c.conn.(io.Closer); ok {
 		return cl.Close()
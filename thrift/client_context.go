@@ -0,0 +1,329 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClientClosed is the error returned by calls made on a client after
+// it has been closed.
+var ErrClientClosed = errors.New("thrift.client: client closed")
+
+// ErrTooManyDroppedCalls is the error a ContextClient is closed with when
+// too many cancelled calls are still waiting for their replies.
+var ErrTooManyDroppedCalls = errors.New("thrift.client: too many calls waiting for dropped replies")
+
+// DefaultMaxDroppedCalls is the number of cancelled calls a ContextClient
+// keeps waiting for their replies before it's closed.
+const DefaultMaxDroppedCalls = 1024
+
+// ContextClient is a Thrift RPC client that doesn't depend on net/rpc.
+// Calls take a context that bounds how long a call waits and can cancel
+// it. A reply that arrives after its call was cancelled or reached its
+// deadline is dropped based on its sequence ID. If more than
+// DefaultMaxDroppedCalls such replies are outstanding the server is
+// assumed to be stuck and the client is closed with ErrTooManyDroppedCalls.
+// Calls may be made concurrently in which case their requests are
+// pipelined on the connection and replies may arrive in any order.
+type ContextClient struct {
+	t    Transport
+	conn net.Conn
+	wsem chan struct{} // held while a request is written
+
+	mu           sync.Mutex
+	seq          int32
+	pending      map[int32]*pendingCall
+	dropped      int // pending calls that were cancelled
+	maxDropped   int
+	err          error // set once the client is no longer usable
+	interceptors []ClientInterceptor
+	invoker      ClientInvoker
+}
+
+type pendingCall struct {
+	res     interface{} // nil if the reply should be dropped
+	dropped bool        // the call was cancelled
+	done    chan error
+}
+
+// DialContext connects to a Thrift RPC server at the specified network
+// address using the specified protocol and returns a ContextClient.
+func DialContext(ctx context.Context, network, address string, framed bool, protocol ProtocolBuilder) (*ContextClient, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return newConnContextClient(conn, framed, protocol), nil
+}
+
+func newConnContextClient(conn net.Conn, framed bool, protocol ProtocolBuilder) *ContextClient {
+	var c io.ReadWriteCloser = conn
+	if framed {
+		c = NewFramedReadWriteCloser(conn, DefaultMaxFrameSize)
+	}
+	return NewContextClient(NewTransport(c, protocol), conn)
+}
+
+// NewContextClient returns a ContextClient making requests over t. If conn
+// is not nil it should be the connection underlying t and the deadline of
+// a call is set on it while its request is written. Reaching it or
+// cancelling the call while writing leaves the stream in an unknown state
+// so the client is closed. Waiting for a reply doesn't affect the
+// connection.
+func NewContextClient(t Transport, conn net.Conn) *ContextClient {
+	c := &ContextClient{
+		t:          t,
+		conn:       conn,
+		wsem:       make(chan struct{}, 1),
+		pending:    make(map[int32]*pendingCall),
+		maxDropped: DefaultMaxDroppedCalls,
+	}
+	c.invoker = c.call
+	go c.readLoop()
+	return c
+}
+
+// Use adds interceptors that are called in order for every call.
+func (c *ContextClient) Use(interceptors ...ClientInterceptor) {
+	c.mu.Lock()
+	c.interceptors = append(c.interceptors, interceptors...)
+	c.invoker = chainClient(c.interceptors, c.call)
+	c.mu.Unlock()
+}
+
+// Call makes a request for method and waits for the response to be decoded
+// into res. If req is a oneway request then res is ignored and Call returns
+// once the request is written.
+func (c *ContextClient) Call(ctx context.Context, method string, req, res interface{}) error {
+	c.mu.Lock()
+	c.seq++
+	seq := c.seq
+	invoker := c.invoker
+	intercepted := len(c.interceptors) > 0
+	c.mu.Unlock()
+	if intercepted {
+		ctx = context.WithValue(ctx, contextCallKey{}, &contextCall{})
+	}
+	return invoker(ctx, method, seq, req, res)
+}
+
+// contextCall tracks whether the sequence ID allocated for a call has been
+// sent so that an interceptor calling next again gets a new one.
+type contextCall struct {
+	sent int32
+}
+
+type contextCallKey struct{}
+
+func (c *ContextClient) call(ctx context.Context, method string, seq int32, req, res interface{}) error {
+	if cc, ok := ctx.Value(contextCallKey{}).(*contextCall); ok && !atomic.CompareAndSwapInt32(&cc.sent, 0, 1) {
+		// next was called again (e.g. by an interceptor retrying the call)
+		c.mu.Lock()
+		c.seq++
+		seq = c.seq
+		c.mu.Unlock()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ow := false
+	if o, ok := req.(oneway); ok {
+		ow = o.Oneway()
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	var call *pendingCall
+	if !ow {
+		call = &pendingCall{res: res, done: make(chan error, 1)}
+		c.pending[seq] = call
+	}
+	c.mu.Unlock()
+
+	select {
+	case c.wsem <- struct{}{}:
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, seq)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+	var stop func()
+	if c.conn != nil {
+		deadline, _ := ctx.Deadline()
+		c.conn.SetWriteDeadline(deadline)
+		stop = c.interruptWrite(ctx)
+	}
+	err := c.writeRequest(method, seq, ow, req, OutgoingMetadata(ctx))
+	if stop != nil {
+		stop()
+	}
+	<-c.wsem
+	if err != nil {
+		c.fail(err)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+	if ow {
+		return nil
+	}
+
+	select {
+	case err := <-call.done:
+		return err
+	case <-ctx.Done():
+		c.mu.Lock()
+		if _, ok := c.pending[seq]; ok {
+			// Leave the call pending so the reply is dropped when it arrives
+			call.res = nil
+			call.dropped = true
+			c.dropped++
+			tooMany := c.dropped > c.maxDropped
+			c.mu.Unlock()
+			if tooMany {
+				c.fail(ErrTooManyDroppedCalls)
+			}
+			return ctx.Err()
+		}
+		c.mu.Unlock()
+		// The reply is already being read
+		return <-call.done
+	}
+}
+
+// interruptWrite sets a write deadline in the past if ctx is done before
+// the returned function is called so that a blocked write returns. The
+// returned function waits for the watching goroutine to exit so that it
+// can't affect a later write.
+func (c *ContextClient) interruptWrite(ctx context.Context) func() {
+	if ctx.Done() == nil {
+		return nil
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			c.conn.SetWriteDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+func (c *ContextClient) writeRequest(method string, seq int32, ow bool, req interface{}, md map[string]string) error {
+	mtype := byte(MessageTypeCall)
+	if ow {
+		mtype = MessageTypeOneway
+	}
+	if h, ok := c.t.(HeaderReadWriter); ok {
+		for k, v := range md {
+			h.SetWriteHeader(k, v)
+		}
+	}
+	return writeMessage(c.t, method, mtype, seq, req)
+}
+
+func (c *ContextClient) readLoop() {
+	for {
+		_, mtype, seq, err := c.t.ReadMessageBegin()
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		c.mu.Lock()
+		call := c.pending[seq]
+		delete(c.pending, seq)
+		var res interface{}
+		if call != nil {
+			res = call.res
+			if call.dropped {
+				c.dropped--
+			}
+		}
+		c.mu.Unlock()
+
+		if call == nil {
+			c.fail(&ApplicationException{"unexpected sequence ID in reply", ExceptionBadSequenceID})
+			return
+		}
+
+		var callErr error
+		switch {
+		case mtype == MessageTypeException:
+			exc := &ApplicationException{}
+			err = DecodeStruct(c.t, exc)
+			callErr = exc
+		case res == nil:
+			err = SkipValue(c.t, TypeStruct)
+		default:
+			err = DecodeStruct(c.t, res)
+			if _, ok := err.(*MissingRequiredField); ok {
+				// The whole struct was read so the stream is still usable
+				callErr = err
+				err = nil
+			}
+		}
+		if err == nil {
+			err = c.t.ReadMessageEnd()
+		}
+		if err != nil {
+			call.done <- err
+			c.fail(err)
+			return
+		}
+		call.done <- callErr
+	}
+}
+
+// fail marks the client unusable, closes the transport, and fails all
+// pending calls.
+func (c *ContextClient) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	err = c.err
+	pending := c.pending
+	c.pending = make(map[int32]*pendingCall)
+	c.dropped = 0
+	c.mu.Unlock()
+
+	c.t.Close()
+	for _, call := range pending {
+		call.done <- err
+	}
+}
+
+// Close closes the connection. Pending calls return ErrClientClosed.
+func (c *ContextClient) Close() error {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil
+	}
+	c.err = ErrClientClosed
+	c.mu.Unlock()
+	return c.t.Close()
+}
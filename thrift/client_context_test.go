@@ -0,0 +1,236 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestContextClient(t *testing.T) {
+	once.Do(startServer)
+
+	c, err := DialContext(context.Background(), "tcp", serverAddr, true, BinaryProtocol)
+	if err != nil {
+		t.Fatalf("DialContext returned error: %+v", err)
+	}
+	defer c.Close()
+
+	req := &TestRequest{123}
+	res := &TestResponse{789}
+	if err := c.Call(context.Background(), "Success", req, res); err != nil {
+		t.Fatalf("ContextClient.Call returned error: %+v", err)
+	}
+	if res.Value != req.Value {
+		t.Fatalf("Response value wrong: %d != %d", res.Value, req.Value)
+	}
+
+	if err := c.Call(context.Background(), "Fail", req, res); err == nil {
+		t.Fatal("ContextClient.Call didn't return an error as expected")
+	} else if e, ok := err.(*ApplicationException); !ok || e.Message != "fail" {
+		t.Fatalf("Expected an ApplicationException instead of %+v", err)
+	}
+
+	// The client is still usable after an exception
+	if err := c.Call(context.Background(), "Success", req, res); err != nil {
+		t.Fatalf("ContextClient.Call returned error: %+v", err)
+	}
+}
+
+// delayedServer replies to the first request only after receiving the
+// second so that the reply to the first arrives late.
+func delayedServer(t *testing.T, conn net.Conn) {
+	tr := NewTransport(conn, BinaryProtocol)
+	defer tr.Close()
+	var seqs []int32
+	var reqs []*TestRequest
+	for i := 0; i < 2; i++ {
+		_, _, seq, err := tr.ReadMessageBegin()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		req := &TestRequest{}
+		if err := DecodeStruct(tr, req); err != nil {
+			t.Error(err)
+			return
+		}
+		tr.ReadMessageEnd()
+		seqs = append(seqs, seq)
+		reqs = append(reqs, req)
+	}
+	for i, seq := range seqs {
+		tr.WriteMessageBegin("Success", MessageTypeReply, seq)
+		EncodeStruct(tr, &TestResponse{reqs[i].Value})
+		tr.WriteMessageEnd()
+		tr.Flush()
+	}
+}
+
+func TestContextClientCancel(t *testing.T) {
+	cli, srv := net.Pipe()
+	go delayedServer(t, srv)
+	c := NewContextClient(NewTransport(cli, BinaryProtocol), cli)
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	res1 := &TestResponse{}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Call(ctx, "Success", &TestRequest{1}, res1)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("Expected context.Canceled instead of %+v", err)
+	}
+
+	// The late reply to the first call must be dropped
+	res2 := &TestResponse{}
+	if err := c.Call(context.Background(), "Success", &TestRequest{2}, res2); err != nil {
+		t.Fatalf("ContextClient.Call returned error: %+v", err)
+	}
+	if res2.Value != 2 {
+		t.Fatalf("Expected response value 2 instead of %d", res2.Value)
+	}
+	if res1.Value != 0 {
+		t.Fatalf("Cancelled call's response was modified: %d", res1.Value)
+	}
+}
+
+func TestContextClientDeadline(t *testing.T) {
+	cli, srv := net.Pipe()
+	defer srv.Close()
+	go func() {
+		// Never reply to the first request
+		tr := NewTransport(srv, BinaryProtocol)
+		for {
+			_, _, seq, err := tr.ReadMessageBegin()
+			if err != nil {
+				return
+			}
+			req := &TestRequest{}
+			if err := DecodeStruct(tr, req); err != nil {
+				return
+			}
+			tr.ReadMessageEnd()
+			if req.Value != 1 {
+				writeMessage(tr, "Success", MessageTypeReply, seq, &TestResponse{req.Value})
+			}
+		}
+	}()
+	c := NewContextClient(NewTransport(cli, BinaryProtocol), cli)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := c.Call(ctx, "Success", &TestRequest{1}, &TestResponse{}); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded instead of %+v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Call took %s with a 20ms deadline", d)
+	}
+
+	// Reaching the deadline doesn't close the client
+	res := &TestResponse{}
+	if err := c.Call(context.Background(), "Success", &TestRequest{2}, res); err != nil {
+		t.Fatalf("ContextClient.Call returned error: %+v", err)
+	} else if res.Value != 2 {
+		t.Fatalf("Expected response value 2 instead of %d", res.Value)
+	}
+}
+
+func TestContextClientConcurrent(t *testing.T) {
+	cli, srv := net.Pipe()
+	// Both requests must be written before either is answered
+	go delayedServer(t, srv)
+	c := NewContextClient(NewTransport(cli, BinaryProtocol), cli)
+	defer c.Close()
+
+	errc := make(chan error, 2)
+	for i := int32(1); i <= 2; i++ {
+		go func(v int32) {
+			res := &TestResponse{}
+			err := c.Call(context.Background(), "Success", &TestRequest{v}, res)
+			if err == nil && res.Value != v {
+				err = fmt.Errorf("expected response value %d instead of %d", v, res.Value)
+			}
+			errc <- err
+		}(i)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestContextClientCancelWrite(t *testing.T) {
+	cli, srv := net.Pipe()
+	// Nothing is read so the request can't be written
+	defer srv.Close()
+	c := NewContextClient(NewTransport(cli, BinaryProtocol), cli)
+	defer c.Close()
+
+	// Without a deadline only cancelling can interrupt the write
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Call(ctx, "Success", &TestRequest{1}, &TestResponse{})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errc:
+		if err != context.Canceled {
+			t.Fatalf("Expected context.Canceled instead of %+v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Cancelling didn't interrupt the write")
+	}
+}
+
+func TestContextClientMaxDropped(t *testing.T) {
+	cli, srv := net.Pipe()
+	defer srv.Close()
+	go func() {
+		// Read requests but never reply
+		tr := NewTransport(srv, BinaryProtocol)
+		for {
+			if _, _, _, err := tr.ReadMessageBegin(); err != nil {
+				return
+			}
+			if err := DecodeStruct(tr, &TestRequest{}); err != nil {
+				return
+			}
+			tr.ReadMessageEnd()
+		}
+	}()
+	c := NewContextClient(NewTransport(cli, BinaryProtocol), cli)
+	defer c.Close()
+	c.maxDropped = 2
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := c.Call(ctx, "Success", &TestRequest{1}, &TestResponse{})
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("Expected context.DeadlineExceeded instead of %+v", err)
+		}
+	}
+	c.mu.Lock()
+	n := len(c.pending)
+	c.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("Expected dropped calls to be released instead of %d pending", n)
+	}
+	if err := c.Call(context.Background(), "Success", &TestRequest{1}, &TestResponse{}); err != ErrTooManyDroppedCalls {
+		t.Fatalf("Expected ErrTooManyDroppedCalls instead of %+v", err)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+)
+
+// Implements rpc.ClientCodec matching replies to calls by sequence ID
+type pipelinedClientCodec struct {
+	conn        Transport
+	headers     chan responseHeader // headers read by readLoop
+	next        chan struct{}       // tells readLoop the body has been read
+	onewayReady chan struct{}
+	closed      chan struct{}
+	closeOnce   sync.Once
+	bodyUnread  bool // the body of the last header hasn't been read (only used by the rpc.Client reader)
+
+	mu      sync.Mutex
+	pending map[int32]uint64 // sequence ID in message -> rpc sequence ID
+	oneways []pendingRequest // sent oneway requests waiting for a fake reply
+}
+
+type responseHeader struct {
+	name  string
+	mtype byte
+	seq   int32
+	err   error
+}
+
+// DialPipelined connects to a Thrift RPC server at the specified network
+// address using the specified protocol and returns a client using a
+// pipelined codec (see NewPipelinedClientCodec).
+func DialPipelined(network, address string, framed bool, protocol ProtocolBuilder) (*rpc.Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	var c io.ReadWriteCloser = conn
+	if framed {
+		c = NewFramedReadWriteCloser(conn, DefaultMaxFrameSize)
+	}
+	return NewPipelinedClient(NewTransport(c, protocol)), nil
+}
+
+// NewPipelinedClient returns a new rpc.Client using a pipelined codec on
+// conn (see NewPipelinedClientCodec).
+func NewPipelinedClient(conn Transport) *rpc.Client {
+	return rpc.NewClientWithCodec(NewPipelinedClientCodec(conn))
+}
+
+// NewPipelinedClientCodec returns a new rpc.ClientCodec that keeps a map of
+// pending calls by sequence ID so replies may arrive in any order. This
+// allows requests to be pipelined to servers that handle them
+// concurrently. A reply with an unknown sequence ID fails the connection
+// with an ApplicationException of type ExceptionBadSequenceID. Oneway
+// requests are always supported and complete once they're written.
+func NewPipelinedClientCodec(conn Transport) rpc.ClientCodec {
+	c := &pipelinedClientCodec{
+		conn:        conn,
+		headers:     make(chan responseHeader),
+		next:        make(chan struct{}),
+		onewayReady: make(chan struct{}, 1),
+		closed:      make(chan struct{}),
+		pending:     make(map[int32]uint64),
+	}
+	go c.readLoop()
+	return c
+}
+
+// readLoop reads message headers so that ReadResponseHeader can wait for
+// either a reply or a oneway request to complete.
+func (c *pipelinedClientCodec) readLoop() {
+	for {
+		name, mtype, seq, err := c.conn.ReadMessageBegin()
+		select {
+		case c.headers <- responseHeader{name, mtype, seq, err}:
+		case <-c.closed:
+			return
+		}
+		if err != nil {
+			return
+		}
+		select {
+		case <-c.next:
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *pipelinedClientCodec) WriteRequest(request *rpc.Request, thriftStruct interface{}) error {
+	ow := false
+	if o, ok := thriftStruct.(oneway); ok {
+		ow = o.Oneway()
+	}
+	mtype := byte(MessageTypeCall)
+	seq := int32(request.Seq)
+	if ow {
+		mtype = MessageTypeOneway
+	} else {
+		// Add the call before writing since the reply may be read first
+		c.mu.Lock()
+		c.pending[seq] = request.Seq
+		c.mu.Unlock()
+	}
+	err := writeMessage(c.conn, request.ServiceMethod, mtype, seq, thriftStruct)
+
+	c.mu.Lock()
+	if err != nil {
+		delete(c.pending, seq)
+	} else if ow {
+		c.oneways = append(c.oneways, pendingRequest{request.ServiceMethod, request.Seq})
+	}
+	c.mu.Unlock()
+	if err == nil && ow {
+		select {
+		case c.onewayReady <- struct{}{}:
+		default:
+		}
+	}
+	return err
+}
+
+func (c *pipelinedClientCodec) ReadResponseHeader(response *rpc.Response) error {
+	for {
+		c.mu.Lock()
+		if len(c.oneways) > 0 {
+			ow := c.oneways[0]
+			c.oneways = c.oneways[1:]
+			c.mu.Unlock()
+			response.ServiceMethod = ow.method
+			response.Seq = ow.seq
+			return nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.onewayReady:
+		case <-c.closed:
+			return io.EOF
+		case h := <-c.headers:
+			if h.err != nil {
+				return h.err
+			}
+			return c.readHeader(response, h)
+		}
+	}
+}
+
+func (c *pipelinedClientCodec) readHeader(response *rpc.Response, h responseHeader) error {
+	c.mu.Lock()
+	seq, ok := c.pending[h.seq]
+	delete(c.pending, h.seq)
+	c.mu.Unlock()
+	if !ok {
+		return &ApplicationException{"unexpected sequence ID in reply", ExceptionBadSequenceID}
+	}
+	response.ServiceMethod = h.name
+	response.Seq = seq
+	if h.mtype == MessageTypeException {
+		exception := &ApplicationException{}
+		if err := DecodeStruct(c.conn, exception); err != nil {
+			return err
+		}
+		response.Error = exception.String()
+		if err := c.conn.ReadMessageEnd(); err != nil {
+			return err
+		}
+		return c.readNext()
+	}
+	c.bodyUnread = true
+	return nil
+}
+
+func (c *pipelinedClientCodec) ReadResponseBody(thriftStruct interface{}) error {
+	if !c.bodyUnread {
+		// Fake reply to a oneway request or an exception that's already been read
+		return nil
+	}
+	c.bodyUnread = false
+	var err error
+	if thriftStruct == nil {
+		// rpc.Client is discarding the reply
+		err = SkipValue(c.conn, TypeStruct)
+	} else {
+		err = DecodeStruct(c.conn, thriftStruct)
+	}
+	if _, ok := err.(*MissingRequiredField); !ok && err != nil {
+		// The stream is in an unknown state. rpc.Client keeps reading
+		// after a body error so close to stop it.
+		c.Close()
+		return err
+	}
+	if e := c.conn.ReadMessageEnd(); e != nil {
+		c.Close()
+		return e
+	}
+	if e := c.readNext(); e != nil {
+		return e
+	}
+	return err
+}
+
+// readNext lets readLoop read the next message.
+func (c *pipelinedClientCodec) readNext() error {
+	select {
+	case c.next <- struct{}{}:
+		return nil
+	case <-c.closed:
+		return io.EOF
+	}
+}
+
+func (c *pipelinedClientCodec) ReadHeaders() map[string]string {
+	return readCodecHeaders(c.conn)
+}
+
+func (c *pipelinedClientCodec) SetWriteHeader(key, value string) {
+	setCodecHeader(c.conn, key, value)
+}
+
+func (c *pipelinedClientCodec) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	if cl, ok := c.conn.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
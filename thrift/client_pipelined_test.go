@@ -0,0 +1,156 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+type scriptedRequest struct {
+	name  string
+	mtype byte
+	seq   int32
+	value int32
+}
+
+// readScriptedRequest reads a request with a TestRequest body.
+func readScriptedRequest(t *testing.T, tr Transport) scriptedRequest {
+	name, mtype, seq, err := tr.ReadMessageBegin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &TestRequest{}
+	if err := DecodeStruct(tr, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.ReadMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+	return scriptedRequest{name, mtype, seq, req.Value}
+}
+
+// tcpPipe returns both ends of a TCP connection which unlike net.Pipe
+// buffers writes.
+func tcpPipe(t *testing.T) (net.Conn, net.Conn) {
+	ln, addr := listenTCP()
+	defer ln.Close()
+	cconn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sconn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cconn, sconn
+}
+
+func writeScriptedReply(t *testing.T, tr Transport, seq int32, value int32) {
+	if err := writeMessage(tr, "Success", MessageTypeReply, seq, &TestResponse{value}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPipelinedClientOutOfOrder(t *testing.T) {
+	cconn, sconn := tcpPipe(t)
+	client := NewPipelinedClient(NewTransport(cconn, BinaryProtocol))
+	defer client.Close()
+	server := NewTransport(sconn, BinaryProtocol)
+
+	res1, res2 := &TestResponse{}, &TestResponse{}
+	call1 := client.Go("Success", &TestRequest{1}, res1, nil)
+	req1 := readScriptedRequest(t, server)
+	call2 := client.Go("Success", &TestRequest{2}, res2, nil)
+	req2 := readScriptedRequest(t, server)
+
+	// Reply in reverse order
+	writeScriptedReply(t, server, req2.seq, req2.value)
+	writeScriptedReply(t, server, req1.seq, req1.value)
+	for i, c := range []*rpc.Call{call1, call2} {
+		if call := <-c.Done; call.Error != nil {
+			t.Fatalf("Call %d returned error: %+v", i+1, call.Error)
+		}
+	}
+	if res1.Value != 1 || res2.Value != 2 {
+		t.Fatalf("Replies matched to the wrong calls: %d %d", res1.Value, res2.Value)
+	}
+}
+
+func TestPipelinedClientOneway(t *testing.T) {
+	cconn, sconn := tcpPipe(t)
+	client := NewPipelinedClient(NewTransport(cconn, BinaryProtocol))
+	defer client.Close()
+	server := NewTransport(sconn, BinaryProtocol)
+
+	res := &TestResponse{}
+	call := client.Go("Success", &TestRequest{1}, res, nil)
+	req := readScriptedRequest(t, server)
+
+	// The oneway request completes while the other call is waiting for its reply
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Call("Notify", &TestOneWayRequest{2}, nil)
+	}()
+	if ow := readScriptedRequest(t, server); ow.mtype != MessageTypeOneway || ow.value != 2 {
+		t.Fatalf("Expected a oneway request with value 2 instead of %+v", ow)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Oneway call returned error: %+v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Oneway call didn't complete")
+	}
+
+	writeScriptedReply(t, server, req.seq, req.value)
+	if c := <-call.Done; c.Error != nil {
+		t.Fatalf("Call returned error: %+v", c.Error)
+	} else if res.Value != 1 {
+		t.Fatalf("Expected 1 instead of %d", res.Value)
+	}
+}
+
+func TestPipelinedClientBadSequenceID(t *testing.T) {
+	cconn, sconn := tcpPipe(t)
+	client := NewPipelinedClient(NewTransport(cconn, BinaryProtocol))
+	defer client.Close()
+	server := NewTransport(sconn, BinaryProtocol)
+
+	call := client.Go("Success", &TestRequest{1}, &TestResponse{}, nil)
+	req := readScriptedRequest(t, server)
+	writeScriptedReply(t, server, req.seq+100, req.value)
+	c := <-call.Done
+	if e, ok := c.Error.(*ApplicationException); !ok || e.Type != ExceptionBadSequenceID {
+		t.Fatalf("Expected a bad sequence ID exception instead of %+v", c.Error)
+	}
+}
+
+func TestPipelinedClientServer(t *testing.T) {
+	once.Do(startServer)
+
+	c, err := DialPipelined("tcp", serverAddr, true, BinaryProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	res := &TestResponse{}
+	if err := c.Call("Success", &TestRequest{123}, res); err != nil {
+		t.Fatal(err)
+	} else if res.Value != 123 {
+		t.Fatalf("Expected 123 instead of %d", res.Value)
+	}
+	if err := c.Call("Fail", &TestRequest{1}, res); err == nil || err.Error() != "Internal Error: fail" {
+		t.Fatalf("Expected an internal error instead of %+v", err)
+	}
+	if err := c.Call("Success", &TestRequest{456}, res); err != nil {
+		t.Fatal(err)
+	} else if res.Value != 456 {
+		t.Fatalf("Expected 456 instead of %d", res.Value)
+	}
+}
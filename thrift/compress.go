@@ -0,0 +1,199 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"sync"
+)
+
+// CompressWriter is a compressing writer. Flush must write all pending
+// data so that it can be decompressed by the peer without closing the
+// stream (e.g. a sync flush for zlib).
+type CompressWriter interface {
+	io.WriteCloser
+	Flusher
+}
+
+// Compressor creates compressing writers and decompressing readers for a
+// compression format.
+type Compressor interface {
+	NewReader(r io.Reader) (io.ReadCloser, error)
+	NewWriter(w io.Writer) (CompressWriter, error)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{
+		"zlib":    ZlibCompressor{zlib.DefaultCompression},
+		"gzip":    GzipCompressor{gzip.DefaultCompression},
+		"deflate": DeflateCompressor{flate.DefaultCompression},
+	}
+)
+
+// RegisterCompressor makes a compressor available by name. zlib, gzip,
+// and deflate are registered by default. Other formats such as snappy or
+// zstd need a third-party package so they aren't built in. Registering a
+// name again replaces the previous compressor.
+func RegisterCompressor(name string, c Compressor) {
+	compressorsMu.Lock()
+	compressors[name] = c
+	compressorsMu.Unlock()
+}
+
+// GetCompressor returns the compressor registered with name or nil if
+// there isn't one.
+func GetCompressor(name string) Compressor {
+	compressorsMu.RLock()
+	c := compressors[name]
+	compressorsMu.RUnlock()
+	return c
+}
+
+// ZlibCompressor is a Compressor for the zlib format with the given level.
+type ZlibCompressor struct {
+	Level int
+}
+
+func (c ZlibCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+func (c ZlibCompressor) NewWriter(w io.Writer) (CompressWriter, error) {
+	return zlib.NewWriterLevel(w, c.Level)
+}
+
+// GzipCompressor is a Compressor for the gzip format with the given level.
+type GzipCompressor struct {
+	Level int
+}
+
+func (c GzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (c GzipCompressor) NewWriter(w io.Writer) (CompressWriter, error) {
+	return gzip.NewWriterLevel(w, c.Level)
+}
+
+// DeflateCompressor is a Compressor for raw deflate with the given level.
+type DeflateCompressor struct {
+	Level int
+}
+
+func (c DeflateCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+func (c DeflateCompressor) NewWriter(w io.Writer) (CompressWriter, error) {
+	return flate.NewWriter(w, c.Level)
+}
+
+// CompressedReadWriteCloser compresses everything written to and
+// decompresses everything read from the wrapped io.ReadWriteCloser. Data is
+// only sent once Flush is called at which point the wrapped value is also
+// flushed if it implements Flusher. It composes with NewTransport and
+// FramedReadWriteCloser in either order.
+type CompressedReadWriteCloser struct {
+	wrapped    io.ReadWriteCloser
+	compressor Compressor
+
+	rmu    sync.Mutex // guards r and closed
+	r      io.ReadCloser
+	closed bool
+
+	wmu sync.Mutex // guards w
+	w   CompressWriter
+}
+
+// NewCompressedReadWriteCloser returns a CompressedReadWriteCloser using the
+// given compressor.
+func NewCompressedReadWriteCloser(wrapped io.ReadWriteCloser, c Compressor) *CompressedReadWriteCloser {
+	return &CompressedReadWriteCloser{
+		wrapped:    wrapped,
+		compressor: c,
+	}
+}
+
+// NewZlibReadWriteCloser returns a CompressedReadWriteCloser that's
+// compatible with TZlibTransport in other Thrift libraries. level is one
+// of the compress/zlib levels.
+func NewZlibReadWriteCloser(wrapped io.ReadWriteCloser, level int) *CompressedReadWriteCloser {
+	return NewCompressedReadWriteCloser(wrapped, ZlibCompressor{level})
+}
+
+func (c *CompressedReadWriteCloser) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	// The reader is created lazily since it reads the stream header
+	if c.r == nil {
+		r, err := c.compressor.NewReader(c.wrapped)
+		if err != nil {
+			return 0, err
+		}
+		c.r = r
+	}
+	return c.r.Read(p)
+}
+
+func (c *CompressedReadWriteCloser) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.w == nil {
+		w, err := c.compressor.NewWriter(c.wrapped)
+		if err != nil {
+			return 0, err
+		}
+		c.w = w
+	}
+	return c.w.Write(p)
+}
+
+func (c *CompressedReadWriteCloser) Flush() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.w == nil {
+		return nil
+	}
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	if f, ok := c.wrapped.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close flushes and closes the compressing writer and closes the wrapped
+// value. It may be called while another goroutine is blocked in Read in
+// which case the decompressing reader is released once Read returns.
+func (c *CompressedReadWriteCloser) Close() error {
+	var err error
+	c.wmu.Lock()
+	if c.w != nil {
+		err = c.w.Close()
+		if f, ok := c.wrapped.(Flusher); ok && err == nil {
+			err = f.Flush()
+		}
+	}
+	c.wmu.Unlock()
+	// Closing the wrapped value first unblocks a pending Read
+	if e := c.wrapped.Close(); err == nil {
+		err = e
+	}
+	c.rmu.Lock()
+	c.closed = true
+	if c.r != nil {
+		c.r.Close()
+	}
+	c.rmu.Unlock()
+	return err
+}
@@ -0,0 +1,99 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestCompressedReadWriteCloser(t *testing.T) {
+	for _, name := range []string{"zlib", "gzip", "deflate"} {
+		c := GetCompressor(name)
+		if c == nil {
+			t.Fatalf("Compressor %s not registered", name)
+		}
+		buf := &ClosingBuffer{&bytes.Buffer{}}
+		rwc := NewCompressedReadWriteCloser(buf, c)
+		msg := strings.Repeat("compressible ", 100)
+		if _, err := io.WriteString(rwc, msg); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		// At most the stream header should be written before flushing
+		if buf.Len() > 10 {
+			t.Fatalf("%s: wrote %d bytes before flush", name, buf.Len())
+		}
+		if err := rwc.Flush(); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if buf.Len() >= len(msg)/5 {
+			t.Fatalf("%s: expected compressed size < %d instead of %d", name, len(msg)/5, buf.Len())
+		}
+		out := make([]byte, len(msg))
+		if _, err := io.ReadFull(rwc, out); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if string(out) != msg {
+			t.Fatalf("%s: read back '%s'", name, out)
+		}
+	}
+}
+
+func TestZlibReadWriteCloserCompatible(t *testing.T) {
+	// Output must be a plain zlib stream as written by TZlibTransport
+	buf := &ClosingBuffer{&bytes.Buffer{}}
+	rwc := NewZlibReadWriteCloser(buf, zlib.BestCompression)
+	io.WriteString(rwc, "hello")
+	if err := rwc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zlib.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "hello" {
+		t.Fatalf("Expected 'hello' instead of '%s'", b)
+	}
+}
+
+func TestZlibTransportRPC(t *testing.T) {
+	once.Do(startServer)
+
+	framed := func(c io.ReadWriteCloser) io.ReadWriteCloser { return NewFramedReadWriteCloser(c, 0) }
+	zlibbed := func(c io.ReadWriteCloser) io.ReadWriteCloser {
+		return NewZlibReadWriteCloser(c, zlib.DefaultCompression)
+	}
+	cases := []struct {
+		name string
+		wrap func(io.ReadWriteCloser) io.ReadWriteCloser
+	}{
+		{"zlib", zlibbed},
+		{"framed zlib", func(c io.ReadWriteCloser) io.ReadWriteCloser { return framed(zlibbed(c)) }},
+		{"zlib framed", func(c io.ReadWriteCloser) io.ReadWriteCloser { return zlibbed(framed(c)) }},
+	}
+	for _, tc := range cases {
+		cli, srv := net.Pipe()
+		go ServeConn(NewTransport(tc.wrap(srv), BinaryProtocol))
+		c := NewClient(NewTransport(tc.wrap(cli), BinaryProtocol), false)
+		for i := int32(0); i < 3; i++ {
+			req := &TestRequest{123 + i}
+			res := &TestResponse{789}
+			if err := c.Call("Success", req, res); err != nil {
+				t.Fatalf("%s: Client.Call returned error: %+v", tc.name, err)
+			}
+			if res.Value != req.Value {
+				t.Fatalf("%s: Response value wrong: %d != %d", tc.name, res.Value, req.Value)
+			}
+		}
+		c.Close()
+	}
+}
@@ -146,8 +146,8 @@ func (e *encoder) writeValue(v reflect.Value, thriftType byte) {
 	case TypeMap:
 		keyType := v.Type().Key()
 		valueType := v.Type().Elem()
-		keyThriftType := fieldType(keyType)
-		valueThriftType := fieldType(valueType)
+		keyThriftType := mustFieldType(keyType)
+		valueThriftType := mustFieldType(valueType)
 		if er := e.w.WriteMapBegin(keyThriftType, valueThriftType, v.Len()); er != nil {
 			e.error(er)
 		}
@@ -161,7 +161,7 @@ func (e *encoder) writeValue(v reflect.Value, thriftType byte) {
 		if elemType.Kind() == reflect.Uint8 {
 			err = e.w.WriteBytes(v.Bytes())
 		} else {
-			elemThriftType := fieldType(elemType)
+			elemThriftType := mustFieldType(elemType)
 			if er := e.w.WriteListBegin(elemThriftType, v.Len()); er != nil {
 				e.error(er)
 			}
@@ -174,7 +174,7 @@ func (e *encoder) writeValue(v reflect.Value, thriftType byte) {
 	case TypeSet:
 		if v.Type().Kind() == reflect.Slice {
 			elemType := v.Type().Elem()
-			elemThriftType := fieldType(elemType)
+			elemThriftType := mustFieldType(elemType)
 			if er := e.w.WriteSetBegin(elemThriftType, v.Len()); er != nil {
 				e.error(er)
 			}
@@ -186,7 +186,7 @@ func (e *encoder) writeValue(v reflect.Value, thriftType byte) {
 		} else if v.Type().Kind() == reflect.Map {
 			elemType := v.Type().Key()
 			valueType := v.Type().Elem()
-			elemThriftType := fieldType(elemType)
+			elemThriftType := mustFieldType(elemType)
 			if valueType.Kind() == reflect.Bool {
 				n := 0
 				for _, k := range v.MapKeys() {
@@ -28,6 +28,15 @@ type Flusher interface {
 	Flush() error
 }
 
+// ZeroCopyReader is implemented by readers that hold data in memory (e.g.
+// bytes.Buffer and FramedReadWriteCloser) and can return it without copying.
+type ZeroCopyReader interface {
+	io.Reader
+	// Next returns a slice containing up to the next n bytes which is only
+	// valid until the reader's buffer is modified or reused.
+	Next(n int) []byte
+}
+
 type FramedReadWriteCloser struct {
 	wrapped       io.ReadWriteCloser
 	limitedReader *io.LimitedReader
@@ -36,6 +45,11 @@ type FramedReadWriteCloser struct {
 	wtmp          []byte
 	rbuf          *bytes.Buffer
 	wbuf          *bytes.Buffer
+	lent          bool // slices of rbuf have been returned by Next
+	metrics       Metrics
+	readSize      int    // size of the frame read until its method is known
+	readPending   bool   // readSize hasn't been recorded
+	writeMethod   string // method of the message in wbuf
 }
 
 func NewFramedReadWriteCloser(wrapped io.ReadWriteCloser, maxFrameSize int) *FramedReadWriteCloser {
@@ -53,6 +67,27 @@ func NewFramedReadWriteCloser(wrapped io.ReadWriteCloser, maxFrameSize int) *Fra
 	}
 }
 
+// SetMetrics records the size of every frame read and written in m. Frames
+// are recorded for the method of the message in them when messages are read
+// and written through a Transport from NewTransport.
+func (f *FramedReadWriteCloser) SetMetrics(m Metrics) {
+	f.metrics = m
+}
+
+// readMethod records the frame being read as carrying a message for method.
+func (f *FramedReadWriteCloser) readMethod(method string) {
+	if f.metrics != nil && f.readPending {
+		f.metrics.ObserveFrame(method, true, f.readSize)
+		f.readPending = false
+	}
+}
+
+// setWriteMethod sets the method recorded for the frame written by the
+// next Flush.
+func (f *FramedReadWriteCloser) setWriteMethod(method string) {
+	f.writeMethod = method
+}
+
 func (f *FramedReadWriteCloser) Read(p []byte) (int, error) {
 	if err := f.fillBuffer(); err != nil {
 		return 0, err
@@ -67,12 +102,27 @@ func (f *FramedReadWriteCloser) ReadByte() (byte, error) {
 	return f.rbuf.ReadByte()
 }
 
+// Next returns a slice of up to the next n bytes of the current frame
+// without copying. The slice remains valid until Release is called, after
+// which the frame buffer may be reused for later frames.
+func (f *FramedReadWriteCloser) Next(n int) []byte {
+	f.lent = true
+	return f.rbuf.Next(n)
+}
+
+// Release tells the transport that slices returned by Next for frames that
+// have been completely read are no longer in use so the frame buffer can be
+// reused. Without calling Release a new buffer is allocated for every frame
+// after Next has been used.
+func (f *FramedReadWriteCloser) Release() {
+	f.lent = false
+}
+
 func (f *FramedReadWriteCloser) fillBuffer() error {
 	if f.rbuf.Len() > 0 {
 		return nil
 	}
 
-	f.rbuf.Reset()
 	if _, err := io.ReadFull(f.wrapped, f.rtmp); err != nil {
 		return err
 	}
@@ -80,6 +130,13 @@ func (f *FramedReadWriteCloser) fillBuffer() error {
 	if frameSize > f.maxFrameSize {
 		return ErrFrameTooBig{frameSize, f.maxFrameSize}
 	}
+	if f.lent {
+		// Slices of the old buffer may still be in use so it can't be reused
+		f.rbuf = bytes.NewBuffer(make([]byte, 0, frameSize))
+		f.lent = false
+	} else {
+		f.rbuf.Reset()
+	}
 	f.limitedReader.N = frameSize
 	written, err := io.Copy(f.rbuf, f.limitedReader)
 	if err != nil {
@@ -88,6 +145,12 @@ func (f *FramedReadWriteCloser) fillBuffer() error {
 	if written < frameSize {
 		return io.EOF
 	}
+	if f.metrics != nil {
+		// The previous frame didn't start with a message
+		f.readMethod("")
+		f.readSize = int(frameSize)
+		f.readPending = true
+	}
 	return nil
 }
 
@@ -109,13 +172,22 @@ func (f *FramedReadWriteCloser) Close() error {
 func (f *FramedReadWriteCloser) Flush() error {
 	frameSize := uint32(f.wbuf.Len())
 	if frameSize > 0 {
+		defer f.setWriteMethod("")
 		binary.BigEndian.PutUint32(f.wtmp, frameSize)
 		if _, err := f.wrapped.Write(f.wtmp); err != nil {
 			return err
 		}
 		_, err := io.Copy(f.wrapped, f.wbuf)
 		f.wbuf.Reset()
-		return err
+		if err != nil {
+			return err
+		}
+		if f.metrics != nil {
+			f.metrics.ObserveFrame(f.writeMethod, false, int(frameSize))
+		}
+		if fl, ok := f.wrapped.(Flusher); ok {
+			return fl.Flush()
+		}
 	}
 	return nil
 }
@@ -0,0 +1,222 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// StreamingFramedReadWriteCloser is a framed transport that doesn't buffer
+// whole frames. Reads stream directly from the wrapped reader once the frame
+// header has been read. Frames can be written without buffering using
+// WriteFrame which calculates the size in a first pass. Write buffers the
+// frame until Flush the same as FramedReadWriteCloser.
+type StreamingFramedReadWriteCloser struct {
+	wrapped       io.ReadWriteCloser
+	br            *bufio.Reader
+	bw            *bufio.Writer
+	limitedReader *io.LimitedReader
+	maxFrameSize  int64
+	rtmp          []byte
+	wtmp          []byte
+	wbuf          *bytes.Buffer
+}
+
+func NewStreamingFramedReadWriteCloser(wrapped io.ReadWriteCloser, maxFrameSize int) *StreamingFramedReadWriteCloser {
+	if maxFrameSize == 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+	br := bufio.NewReader(wrapped)
+	return &StreamingFramedReadWriteCloser{
+		wrapped:       wrapped,
+		br:            br,
+		bw:            bufio.NewWriter(wrapped),
+		limitedReader: &io.LimitedReader{R: br, N: 0},
+		maxFrameSize:  int64(maxFrameSize),
+		rtmp:          make([]byte, 4),
+		wtmp:          make([]byte, 4),
+		wbuf:          &bytes.Buffer{},
+	}
+}
+
+// nextFrame reads the next frame header if the current frame has been
+// completely read.
+func (f *StreamingFramedReadWriteCloser) nextFrame() error {
+	for f.limitedReader.N == 0 {
+		if _, err := io.ReadFull(f.br, f.rtmp); err != nil {
+			return err
+		}
+		frameSize := int64(binary.BigEndian.Uint32(f.rtmp))
+		if frameSize > f.maxFrameSize {
+			return ErrFrameTooBig{frameSize, f.maxFrameSize}
+		}
+		f.limitedReader.N = frameSize
+	}
+	return nil
+}
+
+func (f *StreamingFramedReadWriteCloser) Read(p []byte) (int, error) {
+	if err := f.nextFrame(); err != nil {
+		return 0, err
+	}
+	n, err := f.limitedReader.Read(p)
+	if err == io.EOF && f.limitedReader.N > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *StreamingFramedReadWriteCloser) ReadByte() (byte, error) {
+	if err := f.nextFrame(); err != nil {
+		return 0, err
+	}
+	b, err := f.br.ReadByte()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err == nil {
+		f.limitedReader.N--
+	}
+	return b, err
+}
+
+func (f *StreamingFramedReadWriteCloser) Write(p []byte) (int, error) {
+	n, err := f.wbuf.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if ln := int64(f.wbuf.Len()); ln > f.maxFrameSize {
+		return n, &ErrFrameTooBig{ln, f.maxFrameSize}
+	}
+	return n, nil
+}
+
+// WriteFrame writes a frame without buffering it by calling fn twice: first
+// to calculate the size of the frame and then to write it after the frame
+// header. fn must write exactly the same bytes both times.
+func (f *StreamingFramedReadWriteCloser) WriteFrame(fn func(w io.Writer) error) error {
+	var size countingWriter
+	if err := fn(&size); err != nil {
+		return err
+	}
+	if int64(size) > f.maxFrameSize {
+		return ErrFrameTooBig{int64(size), f.maxFrameSize}
+	}
+	binary.BigEndian.PutUint32(f.wtmp, uint32(size))
+	if _, err := f.bw.Write(f.wtmp); err != nil {
+		return err
+	}
+	w := &frameWriter{w: f.bw, n: int64(size)}
+	if err := fn(w); err != nil {
+		return err
+	}
+	if w.n != 0 {
+		return ProtocolError{"StreamingFramed", "frame size changed while writing"}
+	}
+	return f.bw.Flush()
+}
+
+func (f *StreamingFramedReadWriteCloser) Close() error {
+	return f.wrapped.Close()
+}
+
+// Flush writes any data written with Write as a frame.
+func (f *StreamingFramedReadWriteCloser) Flush() error {
+	if f.wbuf.Len() == 0 {
+		return nil
+	}
+	err := f.WriteFrame(func(w io.Writer) error {
+		_, err := w.Write(f.wbuf.Bytes())
+		return err
+	})
+	f.wbuf.Reset()
+	return err
+}
+
+// countingWriter discards everything written to it while counting the bytes.
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// frameWriter writes at most n bytes to w.
+type frameWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.n {
+		return 0, ProtocolError{"StreamingFramed", "frame size changed while writing"}
+	}
+	n, err := w.w.Write(p)
+	w.n -= int64(n)
+	return n, err
+}
+
+type streamingFramedTransport struct {
+	ProtocolReader
+	ProtocolWriter
+	f   *StreamingFramedReadWriteCloser
+	out *switchWriter
+}
+
+// NewStreamingFramedTransport returns a Transport using a
+// StreamingFramedReadWriteCloser. Reads stream from the connection without
+// holding a whole frame in memory. Messages written by the codecs, clients,
+// and Server of this package are encoded twice, first to calculate the
+// frame size and then straight to the connection, so they aren't buffered
+// either. Anything else written with the protocol methods is buffered until
+// Flush as with FramedReadWriteCloser.
+func NewStreamingFramedTransport(rwc io.ReadWriteCloser, p ProtocolBuilder, maxFrameSize int) Transport {
+	f := NewStreamingFramedReadWriteCloser(rwc, maxFrameSize)
+	out := &switchWriter{w: f}
+	return &streamingFramedTransport{
+		ProtocolReader: p.NewProtocolReader(f),
+		ProtocolWriter: p.NewProtocolWriter(out),
+		f:              f,
+		out:            out,
+	}
+}
+
+// WriteMessage writes v as a message in its own frame. It's encoded once to
+// count its size and again to write it after the frame header.
+func (t *streamingFramedTransport) WriteMessage(name string, messageType byte, seqid int32, v interface{}) error {
+	if err := t.f.Flush(); err != nil {
+		return err
+	}
+	defer func() { t.out.w = t.f }()
+	return t.f.WriteFrame(func(w io.Writer) error {
+		t.out.w = w
+		if err := t.ProtocolWriter.WriteMessageBegin(name, messageType, seqid); err != nil {
+			return err
+		}
+		if err := EncodeStruct(t.ProtocolWriter, v); err != nil {
+			return err
+		}
+		return t.ProtocolWriter.WriteMessageEnd()
+	})
+}
+
+func (t *streamingFramedTransport) Flush() error {
+	return t.f.Flush()
+}
+
+func (t *streamingFramedTransport) Close() error {
+	return t.f.Close()
+}
+
+// switchWriter forwards writes to w which can be changed between writes.
+type switchWriter struct {
+	w io.Writer
+}
+
+func (w *switchWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
@@ -0,0 +1,167 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"testing"
+)
+
+func TestStreamingFramed(t *testing.T) {
+	buf := &ClosingBuffer{&bytes.Buffer{}}
+	framed := NewStreamingFramedReadWriteCloser(buf, 1024)
+	if _, err := framed.Write([]byte{1, 2, 3, 4}); err != nil {
+		t.Fatalf("StreamingFramed: error on Write %s", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("StreamingFramed: wrote %d bytes before flush", buf.Len())
+	}
+	if err := framed.Flush(); err != nil {
+		t.Fatalf("StreamingFramed: error on Flush %s", err)
+	}
+	if err := framed.WriteFrame(func(w io.Writer) error {
+		_, err := w.Write([]byte{5, 6})
+		return err
+	}); err != nil {
+		t.Fatalf("StreamingFramed: error on WriteFrame %s", err)
+	}
+	expected := []byte{0, 0, 0, 4, 1, 2, 3, 4, 0, 0, 0, 2, 5, 6}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatalf("StreamingFramed: expected output %+v but got %+v", expected, buf.Bytes())
+	}
+
+	// Reads never cross frames and empty frames are skipped
+	buf = &ClosingBuffer{bytes.NewBuffer([]byte{0, 0, 0, 2, 5, 6, 0, 0, 0, 0, 0, 0, 0, 1, 7})}
+	framed = NewStreamingFramedReadWriteCloser(buf, 1024)
+	out := make([]byte, 4)
+	if n, err := framed.Read(out); err != nil {
+		t.Fatalf("StreamingFramed: error from Read %s", err)
+	} else if n != 2 || out[0] != 5 || out[1] != 6 {
+		t.Fatalf("StreamingFramed: expected {5,6} from Read instead %+v", out[:n])
+	}
+	if b, err := framed.ReadByte(); err != nil {
+		t.Fatalf("StreamingFramed: error from ReadByte %s", err)
+	} else if b != 7 {
+		t.Fatalf("StreamingFramed: expected 7 from ReadByte instead %d", b)
+	}
+	if _, err := framed.ReadByte(); err != io.EOF {
+		t.Fatalf("StreamingFramed: expected io.EOF instead of %+v", err)
+	}
+}
+
+func TestStreamingFramedErrors(t *testing.T) {
+	buf := &ClosingBuffer{bytes.NewBuffer([]byte{0, 0, 4, 1})}
+	framed := NewStreamingFramedReadWriteCloser(buf, 1024)
+	if _, err := framed.Read(make([]byte, 1)); err != (ErrFrameTooBig{1025, 1024}) {
+		t.Fatalf("StreamingFramed: expected ErrFrameTooBig instead of %+v", err)
+	}
+	if err := framed.WriteFrame(func(w io.Writer) error {
+		_, err := w.Write(make([]byte, 1025))
+		return err
+	}); err != (ErrFrameTooBig{1025, 1024}) {
+		t.Fatalf("StreamingFramed: expected ErrFrameTooBig instead of %+v", err)
+	}
+
+	n := 0
+	if err := framed.WriteFrame(func(w io.Writer) error {
+		n++
+		_, err := w.Write(make([]byte, n))
+		return err
+	}); err == nil {
+		t.Fatal("StreamingFramed: expected an error when the frame size changes")
+	}
+
+	buf = &ClosingBuffer{bytes.NewBuffer([]byte{0, 0, 0, 4, 1})}
+	framed = NewStreamingFramedReadWriteCloser(buf, 1024)
+	if _, err := ioutil.ReadAll(framed); err != io.ErrUnexpectedEOF {
+		t.Fatalf("StreamingFramed: expected io.ErrUnexpectedEOF instead of %+v", err)
+	}
+}
+
+func TestStreamingFramedTransport(t *testing.T) {
+	once.Do(startServer)
+
+	for name, p := range map[string]ProtocolBuilder{"binary": BinaryProtocol, "compact": CompactProtocol, "json": JSONProtocol} {
+		cli, srv := net.Pipe()
+		go ServeConn(NewTransport(NewFramedReadWriteCloser(srv, 0), p))
+		c := NewClient(NewStreamingFramedTransport(cli, p, 0), false)
+		for i := int32(0); i < 3; i++ {
+			req := &TestRequest{123 + i}
+			res := &TestResponse{789}
+			if err := c.Call("Success", req, res); err != nil {
+				t.Fatalf("%s: Client.Call returned error: %+v", name, err)
+			}
+			if res.Value != req.Value {
+				t.Fatalf("%s: Response value wrong: %d != %d", name, res.Value, req.Value)
+			}
+		}
+		c.Close()
+	}
+}
+
+func TestStreamingFramedTransportLarge(t *testing.T) {
+	s := &TestStruct2{Str: "foo", Binary: make([]byte, 1<<20)}
+	for i := range s.Binary {
+		s.Binary[i] = byte(i)
+	}
+	buf := &ClosingBuffer{&bytes.Buffer{}}
+	tr := NewStreamingFramedTransport(buf, BinaryProtocol, 2<<20)
+	if err := EncodeStruct(tr, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	s2 := &TestStruct2{}
+	if err := DecodeStruct(tr, s2); err != nil {
+		t.Fatal(err)
+	}
+	if s2.Str != s.Str || !bytes.Equal(s2.Binary, s.Binary) {
+		t.Fatal("StreamingFramedTransport: decoded struct doesn't match")
+	}
+}
+
+// discardConn discards everything written to it.
+type discardConn struct{}
+
+func (discardConn) Read(p []byte) (int, error)  { return 0, io.EOF }
+func (discardConn) Write(p []byte) (int, error) { return len(p), nil }
+func (discardConn) Close() error                { return nil }
+
+// allocatedBytes returns the number of bytes allocated by fn.
+func allocatedBytes(fn func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestStreamingFramedTransportWriteAllocs(t *testing.T) {
+	s := &jsonTestStruct{List: make([]int32, 1<<16)}
+	tr := NewStreamingFramedTransport(discardConn{}, BinaryProtocol, 1<<20)
+	// Warm up
+	if err := writeMessage(tr, "test", MessageTypeCall, 1, s); err != nil {
+		t.Fatal(err)
+	}
+	encode := allocatedBytes(func() {
+		EncodeStruct(NewBinaryProtocolWriter(ioutil.Discard, true), s)
+	})
+	var err error
+	write := allocatedBytes(func() {
+		err = writeMessage(tr, "test", MessageTypeCall, 2, s)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Besides encoding twice the frame (over 256KB) isn't held in memory
+	if write > 2*encode+16<<10 {
+		t.Fatalf("Writing the frame allocated %d bytes while encoding it allocates %d", write, encode)
+	}
+}
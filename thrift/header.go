@@ -0,0 +1,536 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"sync"
+)
+
+// The header transport is compatible with the fbthrift and Apache Thrift
+// THeaderTransport. Every message is sent as a frame:
+//
+//	LENGTH         uint32  length of the rest of the frame
+//	MAGIC          uint16  0x0FFF
+//	FLAGS          uint16
+//	SEQUENCE ID    uint32
+//	HEADER SIZE    uint16  size of the header in 32-bit words
+//	HEADER                 protocol id, transforms, and info blocks
+//	PAYLOAD                message encoded with the protocol
+//
+// All integers in the header are unsigned varints and strings are prefixed
+// by their varint length.
+
+const (
+	headerMagic     = 0x0fff
+	headerMagicMask = 0xffff0000
+	maxHeaderSize   = 0xffff * 4
+)
+
+// Protocol IDs used in the header to identify the payload protocol.
+const (
+	HeaderProtocolBinary  = 0
+	HeaderProtocolCompact = 2
+)
+
+// Transform IDs for payload transforms. Only zlib is supported.
+const (
+	HeaderTransformZlib   = 1
+	HeaderTransformHMAC   = 2
+	HeaderTransformSnappy = 3
+	HeaderTransformQLZ    = 4
+	HeaderTransformZstd   = 5
+)
+
+const (
+	headerInfoPadding       = 0
+	headerInfoKeyValue      = 1
+	headerInfoPersistentKey = 2
+)
+
+// HeaderReadWriter is implemented by transports that carry key/value
+// headers with every message.
+type HeaderReadWriter interface {
+	// ReadHeaders returns the headers of the last message read.
+	ReadHeaders() map[string]string
+	// SetWriteHeader sets a header to send with the next message written.
+	SetWriteHeader(key, value string)
+}
+
+// The net/rpc client and server codecs implement HeaderReadWriter by
+// passing headers to and from their transport, and so do the codecs
+// wrapping them.
+
+func readCodecHeaders(codec interface{}) map[string]string {
+	if h, ok := codec.(HeaderReadWriter); ok {
+		return h.ReadHeaders()
+	}
+	return nil
+}
+
+func setCodecHeader(codec interface{}, key, value string) {
+	if h, ok := codec.(HeaderReadWriter); ok {
+		h.SetWriteHeader(key, value)
+	}
+}
+
+// HeaderTransport is a Transport that speaks the THeader protocol. The
+// payload protocol of incoming messages is detected from the header, and
+// replies are written using the same protocol and transforms as the last
+// message read. Framed binary or compact messages without a header (from
+// clients that don't speak THeader) are also accepted and replied to in
+// kind, without headers.
+type HeaderTransport struct {
+	ProtocolReader
+	ProtocolWriter
+
+	rwc          io.ReadWriteCloser
+	maxFrameSize int64
+	rtmp         []byte
+	wtmp         []byte
+
+	rframe                []byte
+	rbuf                  *bytes.Reader
+	binaryReader          ProtocolReader
+	compactReader         ProtocolReader
+	readProtocolID        int
+	readTransforms        []int
+	readHeaders           map[string]string // replaced for every message, guarded by mu
+	persistentReadHeaders map[string]string
+
+	wbuf                   *bytes.Buffer
+	binaryWriter           ProtocolWriter
+	compactWriter          ProtocolWriter
+	mu                     sync.Mutex // guards the write state which is also set by reads and by handlers
+	writeProtocolID        int
+	writeTransforms        []int
+	writeFramed            bool // write plain frames without a header
+	writeHeaders           map[string]string
+	persistentWriteHeaders map[string]string
+	writeSeqID             int32
+	writeMethod            string
+
+	metrics       Metrics
+	readFrameSize int
+}
+
+// NewHeaderTransport returns a new header transport wrapping rwc. The
+// protocolID (HeaderProtocolBinary or HeaderProtocolCompact) is used to
+// write messages until one is read. A maxFrameSize of 0 uses
+// DefaultMaxFrameSize.
+func NewHeaderTransport(rwc io.ReadWriteCloser, protocolID int, maxFrameSize int) *HeaderTransport {
+	if maxFrameSize == 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+	t := &HeaderTransport{
+		rwc:                    rwc,
+		maxFrameSize:           int64(maxFrameSize),
+		rtmp:                   make([]byte, 4),
+		wtmp:                   make([]byte, 14),
+		rbuf:                   bytes.NewReader(nil),
+		readHeaders:            make(map[string]string),
+		persistentReadHeaders:  make(map[string]string),
+		wbuf:                   &bytes.Buffer{},
+		writeProtocolID:        protocolID,
+		writeHeaders:           make(map[string]string),
+		persistentWriteHeaders: make(map[string]string),
+	}
+	t.binaryReader = NewBinaryProtocolReader(t.rbuf, false)
+	t.compactReader = NewCompactProtocolReader(t.rbuf)
+	t.binaryWriter = NewBinaryProtocolWriter(t.wbuf, true)
+	t.compactWriter = NewCompactProtocolWriter(t.wbuf)
+	t.ProtocolReader = t.binaryReader
+	t.ProtocolWriter = t.binaryWriter
+	return t
+}
+
+// ReadHeaders returns the headers (including persistent headers) received
+// with the last message.
+func (t *HeaderTransport) ReadHeaders() map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.readHeaders
+}
+
+// SetWriteHeader sets a header to send with the next message. Headers are
+// cleared after every Flush.
+func (t *HeaderTransport) SetWriteHeader(key, value string) {
+	t.mu.Lock()
+	t.writeHeaders[key] = value
+	t.mu.Unlock()
+}
+
+// SetPersistentWriteHeader sets a header to send with every message.
+func (t *HeaderTransport) SetPersistentWriteHeader(key, value string) {
+	t.mu.Lock()
+	t.persistentWriteHeaders[key] = value
+	t.mu.Unlock()
+}
+
+// SetMetrics records the size of every frame read and written in m.
+func (t *HeaderTransport) SetMetrics(m Metrics) {
+	t.metrics = m
+}
+
+// ProtocolID returns the protocol ID of the last message read.
+func (t *HeaderTransport) ProtocolID() int {
+	return t.readProtocolID
+}
+
+// SetProtocolID sets the protocol to use when writing messages.
+func (t *HeaderTransport) SetProtocolID(protocolID int) {
+	t.mu.Lock()
+	t.writeProtocolID = protocolID
+	t.mu.Unlock()
+}
+
+// SetWriteTransforms sets the transforms to apply to written payloads.
+func (t *HeaderTransport) SetWriteTransforms(transforms ...int) {
+	t.mu.Lock()
+	t.writeTransforms = append(t.writeTransforms[:0], transforms...)
+	t.mu.Unlock()
+}
+
+func (t *HeaderTransport) ReadMessageBegin() (name string, messageType byte, seqid int32, err error) {
+	if err = t.readFrame(); err != nil {
+		return
+	}
+	name, messageType, seqid, err = t.ProtocolReader.ReadMessageBegin()
+	if t.metrics != nil {
+		t.metrics.ObserveFrame(name, true, t.readFrameSize)
+	}
+	return
+}
+
+func (t *HeaderTransport) WriteMessageBegin(name string, messageType byte, seqid int32) error {
+	t.mu.Lock()
+	protocolID := t.writeProtocolID
+	t.mu.Unlock()
+	switch protocolID {
+	case HeaderProtocolBinary:
+		t.ProtocolWriter = t.binaryWriter
+	case HeaderProtocolCompact:
+		t.ProtocolWriter = t.compactWriter
+	default:
+		return ProtocolError{"HeaderTransport", fmt.Sprintf("unsupported protocol ID %d", protocolID)}
+	}
+	t.writeSeqID = seqid
+	t.writeMethod = name
+	return t.ProtocolWriter.WriteMessageBegin(name, messageType, seqid)
+}
+
+func (t *HeaderTransport) readFrame() error {
+	if _, err := io.ReadFull(t.rwc, t.rtmp); err != nil {
+		return err
+	}
+	frameSize := int64(binary.BigEndian.Uint32(t.rtmp))
+	if frameSize > t.maxFrameSize {
+		return ErrFrameTooBig{frameSize, t.maxFrameSize}
+	}
+	if int64(cap(t.rframe)) < frameSize {
+		t.rframe = make([]byte, frameSize)
+	}
+	frame := t.rframe[:frameSize]
+	if _, err := io.ReadFull(t.rwc, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	t.readFrameSize = int(frameSize)
+	framed := len(frame) < 10 || binary.BigEndian.Uint32(frame)&headerMagicMask != headerMagic<<16
+	if framed {
+		protocolID, ok := framedProtocolID(frame)
+		if !ok {
+			return ProtocolError{"HeaderTransport", "missing header magic"}
+		}
+		t.readProtocolID = protocolID
+		t.readTransforms = t.readTransforms[:0]
+		t.mu.Lock()
+		t.readHeaders = make(map[string]string)
+		t.mu.Unlock()
+		t.rbuf.Reset(frame)
+	} else {
+		headerSize := int(binary.BigEndian.Uint16(frame[8:])) * 4
+		if 10+headerSize > len(frame) {
+			return ProtocolError{"HeaderTransport", "header size larger than frame"}
+		}
+		if err := t.readHeader(frame[10 : 10+headerSize]); err != nil {
+			return err
+		}
+		payload := frame[10+headerSize:]
+		for i := len(t.readTransforms) - 1; i >= 0; i-- {
+			var err error
+			if payload, err = t.untransform(t.readTransforms[i], payload); err != nil {
+				return err
+			}
+		}
+		t.rbuf.Reset(payload)
+	}
+
+	switch t.readProtocolID {
+	case HeaderProtocolBinary:
+		t.ProtocolReader = t.binaryReader
+	case HeaderProtocolCompact:
+		t.ProtocolReader = t.compactReader
+	default:
+		return ProtocolError{"HeaderTransport", fmt.Sprintf("unsupported protocol ID %d", t.readProtocolID)}
+	}
+	// Reply in kind
+	t.mu.Lock()
+	t.writeProtocolID = t.readProtocolID
+	t.writeTransforms = append(t.writeTransforms[:0], t.readTransforms...)
+	t.writeFramed = framed
+	t.mu.Unlock()
+	return nil
+}
+
+// framedProtocolID returns the protocol of a framed message sent without a
+// header. Only strict binary and compact messages can be told apart from a
+// header.
+func framedProtocolID(frame []byte) (int, bool) {
+	switch {
+	case len(frame) >= 2 && frame[0] == 0x80 && frame[1] == 0x01:
+		return HeaderProtocolBinary, true
+	case len(frame) >= 1 && frame[0] == compactProtocolID:
+		return HeaderProtocolCompact, true
+	}
+	return 0, false
+}
+
+func (t *HeaderTransport) readHeader(header []byte) error {
+	r := bytes.NewReader(header)
+	readVarint := func() (int, error) {
+		v, err := binary.ReadUvarint(r)
+		if err != nil || v > math.MaxInt32 {
+			return 0, ProtocolError{"HeaderTransport", "bad varint in header"}
+		}
+		return int(v), nil
+	}
+	readString := func() (string, error) {
+		n, err := readVarint()
+		if err != nil {
+			return "", err
+		}
+		if n > r.Len() {
+			return "", ProtocolError{"HeaderTransport", "string length larger than header"}
+		}
+		b := make([]byte, n)
+		r.Read(b)
+		return string(b), nil
+	}
+
+	var err error
+	if t.readProtocolID, err = readVarint(); err != nil {
+		return err
+	}
+	n, err := readVarint()
+	if err != nil {
+		return err
+	}
+	t.readTransforms = t.readTransforms[:0]
+	for i := 0; i < n; i++ {
+		id, err := readVarint()
+		if err != nil {
+			return err
+		}
+		t.readTransforms = append(t.readTransforms, id)
+	}
+
+	headers := make(map[string]string)
+	for r.Len() > 0 {
+		infoID, err := readVarint()
+		if err != nil {
+			return err
+		}
+		if infoID == headerInfoPadding {
+			break
+		}
+		if infoID != headerInfoKeyValue && infoID != headerInfoPersistentKey {
+			// Unknown info blocks can't be skipped since their size isn't known
+			break
+		}
+		count, err := readVarint()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			key, err := readString()
+			if err != nil {
+				return err
+			}
+			value, err := readString()
+			if err != nil {
+				return err
+			}
+			if infoID == headerInfoPersistentKey {
+				t.persistentReadHeaders[key] = value
+			} else {
+				headers[key] = value
+			}
+		}
+	}
+	for k, v := range t.persistentReadHeaders {
+		if _, ok := headers[k]; !ok {
+			headers[k] = v
+		}
+	}
+	t.mu.Lock()
+	t.readHeaders = headers
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *HeaderTransport) untransform(id int, payload []byte) ([]byte, error) {
+	switch id {
+	case HeaderTransformZlib:
+		zr, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		b, err := ioutil.ReadAll(io.LimitReader(zr, t.maxFrameSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(b)) > t.maxFrameSize {
+			return nil, ErrFrameTooBig{int64(len(b)), t.maxFrameSize}
+		}
+		return b, nil
+	}
+	return nil, ProtocolError{"HeaderTransport", fmt.Sprintf("unsupported transform %d", id)}
+}
+
+func transform(id int, payload []byte) ([]byte, error) {
+	switch id {
+	case HeaderTransformZlib:
+		buf := &bytes.Buffer{}
+		zw := zlib.NewWriter(buf)
+		if _, err := zw.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, ProtocolError{"HeaderTransport", fmt.Sprintf("unsupported transform %d", id)}
+}
+
+func appendHeaderString(b []byte, s string) []byte {
+	b = appendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(b, tmp[:n]...)
+}
+
+func appendInfoKeyValues(b []byte, infoID int, headers map[string]string) []byte {
+	if len(headers) == 0 {
+		return b
+	}
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b = appendUvarint(b, uint64(infoID))
+	b = appendUvarint(b, uint64(len(headers)))
+	for _, k := range keys {
+		b = appendHeaderString(b, k)
+		b = appendHeaderString(b, headers[k])
+	}
+	return b
+}
+
+// Flush writes the buffered message as a single frame.
+func (t *HeaderTransport) Flush() error {
+	if t.wbuf.Len() == 0 {
+		return nil
+	}
+	defer t.wbuf.Reset()
+
+	t.mu.Lock()
+	protocolID := t.writeProtocolID
+	transforms := append([]int(nil), t.writeTransforms...)
+	framed := t.writeFramed
+	header := make([]byte, 0, 64)
+	header = appendUvarint(header, uint64(protocolID))
+	header = appendUvarint(header, uint64(len(transforms)))
+	for _, id := range transforms {
+		header = appendUvarint(header, uint64(id))
+	}
+	header = appendInfoKeyValues(header, headerInfoKeyValue, t.writeHeaders)
+	header = appendInfoKeyValues(header, headerInfoPersistentKey, t.persistentWriteHeaders)
+	for k := range t.writeHeaders {
+		delete(t.writeHeaders, k)
+	}
+	t.mu.Unlock()
+
+	var frame [][]byte
+	var frameSize int64
+	if framed {
+		// Reply to a client that doesn't speak THeader with a plain frame
+		frameSize = int64(t.wbuf.Len())
+		if frameSize > t.maxFrameSize {
+			return ErrFrameTooBig{frameSize, t.maxFrameSize}
+		}
+		binary.BigEndian.PutUint32(t.wtmp, uint32(frameSize))
+		frame = [][]byte{t.wtmp[:4], t.wbuf.Bytes()}
+	} else {
+		for len(header)%4 != 0 {
+			header = append(header, headerInfoPadding)
+		}
+		if len(header) > maxHeaderSize {
+			return ProtocolError{"HeaderTransport", "header too large"}
+		}
+
+		payload := t.wbuf.Bytes()
+		for _, id := range transforms {
+			var err error
+			if payload, err = transform(id, payload); err != nil {
+				return err
+			}
+		}
+
+		frameSize = int64(10 + len(header) + len(payload))
+		if frameSize > t.maxFrameSize {
+			return ErrFrameTooBig{frameSize, t.maxFrameSize}
+		}
+		b := t.wtmp
+		binary.BigEndian.PutUint32(b, uint32(frameSize))
+		binary.BigEndian.PutUint16(b[4:], headerMagic)
+		binary.BigEndian.PutUint16(b[6:], 0) // flags
+		binary.BigEndian.PutUint32(b[8:], uint32(t.writeSeqID))
+		binary.BigEndian.PutUint16(b[12:], uint16(len(header)/4))
+		frame = [][]byte{b, header, payload}
+	}
+	for _, p := range frame {
+		if _, err := t.rwc.Write(p); err != nil {
+			return err
+		}
+	}
+	if t.metrics != nil {
+		t.metrics.ObserveFrame(t.writeMethod, false, int(frameSize))
+	}
+	if f, ok := t.rwc.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (t *HeaderTransport) Close() error {
+	return t.rwc.Close()
+}
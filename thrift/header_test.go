@@ -0,0 +1,193 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"net"
+	"net/rpc"
+	"testing"
+)
+
+func TestHeaderTransportWireFormat(t *testing.T) {
+	buf := &ClosingBuffer{&bytes.Buffer{}}
+	ht := NewHeaderTransport(buf, HeaderProtocolBinary, 0)
+	ht.SetWriteHeader("k", "v")
+	if err := ht.WriteMessageBegin("m", MessageTypeCall, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeStruct(ht, &struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ht.WriteMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ht.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		0, 0, 0, 32, // length
+		0x0f, 0xff, 0, 0, // magic, flags
+		0, 0, 0, 5, // seqid
+		0, 2, // header size / 4
+		0, 0, 1, 1, 1, 'k', 1, 'v', // protocol, transforms, key/value info
+		0x80, 0x01, 0, 1, 0, 0, 0, 1, 'm', 0, 0, 0, 5, 0, // binary message
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatalf("HeaderTransport wrote\n%+v\nexpected\n%+v", buf.Bytes(), expected)
+	}
+
+	if len(ht.writeHeaders) != 0 {
+		t.Fatal("HeaderTransport didn't clear write headers after flush")
+	}
+}
+
+func TestHeaderTransportRoundTrip(t *testing.T) {
+	buf := &ClosingBuffer{&bytes.Buffer{}}
+	client := NewHeaderTransport(buf, HeaderProtocolCompact, 0)
+	client.SetWriteTransforms(HeaderTransformZlib)
+	client.SetWriteHeader("trace", "abc")
+	client.SetPersistentWriteHeader("client", "test")
+	if err := client.WriteMessageBegin("method", MessageTypeCall, 9); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeStruct(client, &TestStruct2{Str: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewHeaderTransport(buf, HeaderProtocolBinary, 0)
+	name, mtype, seqid, err := server.ReadMessageBegin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "method" || mtype != MessageTypeCall || seqid != 9 {
+		t.Fatalf("ReadMessageBegin returned (%s, %d, %d)", name, mtype, seqid)
+	}
+	s := &TestStruct2{}
+	if err := DecodeStruct(server, s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Str != "foo" {
+		t.Fatalf("Expected 'foo' got '%s'", s.Str)
+	}
+	if server.ProtocolID() != HeaderProtocolCompact {
+		t.Fatalf("Expected compact protocol ID instead of %d", server.ProtocolID())
+	}
+	hdrs := server.ReadHeaders()
+	if hdrs["trace"] != "abc" || hdrs["client"] != "test" || len(hdrs) != 2 {
+		t.Fatalf("Unexpected headers %+v", hdrs)
+	}
+
+	// Reply should use the same protocol and transforms
+	if err := server.WriteMessageBegin("method", MessageTypeReply, 9); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeStruct(server, &TestStruct2{Str: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.WriteMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := client.ReadMessageBegin(); err != nil {
+		t.Fatal(err)
+	}
+	if client.ProtocolID() != HeaderProtocolCompact || len(client.readTransforms) != 1 {
+		t.Fatalf("Reply didn't use the request protocol and transforms")
+	}
+	if err := DecodeStruct(client, s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Str != "bar" {
+		t.Fatalf("Expected 'bar' got '%s'", s.Str)
+	}
+}
+
+func TestHeaderTransportBadMagic(t *testing.T) {
+	buf := &ClosingBuffer{bytes.NewBuffer([]byte{0, 0, 0, 10, 0x12, 1, 0, 1, 0, 0, 0, 0, 0, 0})}
+	if _, _, _, err := NewHeaderTransport(buf, HeaderProtocolBinary, 0).ReadMessageBegin(); err == nil {
+		t.Fatal("Expected an error for a frame without the header magic")
+	}
+}
+
+func TestHeaderTransportCodecHeaders(t *testing.T) {
+	cconn, sconn := tcpPipe(t)
+	client := NewClientCodec(NewHeaderTransport(cconn, HeaderProtocolBinary, 0), false)
+	defer client.Close()
+	server := NewServerCodec(NewHeaderTransport(sconn, HeaderProtocolBinary, 0))
+	defer server.Close()
+
+	client.(HeaderReadWriter).SetWriteHeader("request", "1")
+	if err := client.WriteRequest(&rpc.Request{ServiceMethod: "Success", Seq: 1}, &TestRequest{1}); err != nil {
+		t.Fatal(err)
+	}
+	req := &rpc.Request{}
+	if err := server.ReadRequestHeader(req); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReadRequestBody(&TestRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if h := server.(HeaderReadWriter).ReadHeaders(); h["request"] != "1" {
+		t.Fatalf("Server codec read headers %+v", h)
+	}
+	server.(HeaderReadWriter).SetWriteHeader("response", "2")
+	if err := server.WriteResponse(&rpc.Response{ServiceMethod: req.ServiceMethod, Seq: req.Seq}, &TestResponse{1}); err != nil {
+		t.Fatal(err)
+	}
+	res := &rpc.Response{}
+	if err := client.ReadResponseHeader(res); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ReadResponseBody(&TestResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if h := client.(HeaderReadWriter).ReadHeaders(); h["response"] != "2" || h["request"] != "" {
+		t.Fatalf("Client codec read headers %+v", h)
+	}
+}
+
+func TestHeaderTransportFramedFallback(t *testing.T) {
+	once.Do(startServer)
+
+	for _, p := range []ProtocolBuilder{BinaryProtocol, CompactProtocol} {
+		cli, srv := net.Pipe()
+		go ServeConn(NewHeaderTransport(srv, HeaderProtocolBinary, 0))
+		c := NewClient(NewTransport(NewFramedReadWriteCloser(cli, 0), p), false)
+		res := &TestResponse{}
+		if err := c.Call("Success", &TestRequest{123}, res); err != nil {
+			t.Fatalf("Client.Call returned error: %+v", err)
+		}
+		if res.Value != 123 {
+			t.Fatalf("Response value wrong: %d", res.Value)
+		}
+		c.Close()
+	}
+}
+
+func TestHeaderTransportRPC(t *testing.T) {
+	once.Do(startServer)
+
+	cli, srv := net.Pipe()
+	go ServeConn(NewHeaderTransport(srv, HeaderProtocolBinary, 0))
+	c := NewClient(NewHeaderTransport(cli, HeaderProtocolCompact, 0), false)
+	defer c.Close()
+	req := &TestRequest{123}
+	res := &TestResponse{789}
+	if err := c.Call("Success", req, res); err != nil {
+		t.Fatalf("Client.Call returned error: %+v", err)
+	}
+	if res.Value != req.Value {
+		t.Fatalf("Response value wrong: %d != %d", res.Value, req.Value)
+	}
+}
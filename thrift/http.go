@@ -0,0 +1,209 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// HTTPContentType is the content type of requests and replies sent over
+// HTTP (as used by THttpClient).
+const HTTPContentType = "application/x-thrift"
+
+// HTTPStatusError is returned by HTTPClient when the server replies with a
+// status other than 200 OK.
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return "thrift: HTTP request failed: " + e.Status
+}
+
+// httpConn reads a request from an HTTP body and buffers the reply. Close
+// doesn't close the body since the http.Server does that once the handler
+// returns.
+type httpConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (c httpConn) Close() error {
+	return nil
+}
+
+// HTTPHandler returns an http.Handler that reads one message per POST body
+// using protocol and dispatches it like a request read from a connection,
+// including interceptors. The reply is the response body. Oneway requests
+// get an empty response. Bodies larger than DefaultMaxFrameSize are
+// rejected.
+func (s *Server) HTTPHandler(protocol ProtocolBuilder) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body := http.MaxBytesReader(w, r.Body, DefaultMaxFrameSize)
+		buf := &bytes.Buffer{}
+		t := NewTransport(httpConn{body, buf}, protocol)
+		defer t.Close()
+		name, mtype, seq, err := t.ReadMessageBegin()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req, err := s.readRequest(t, name, mtype, seq)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx := r.Context()
+		if r.TLS != nil {
+			ctx = withTLSState(ctx, *r.TLS)
+		}
+		if err := s.serveRequest(ctx, &serverConn{t: t}, req); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", HTTPContentType)
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.Write(buf.Bytes())
+	})
+}
+
+// HTTPClient is an RPCClient that POSTs every call to a URL (compatible
+// with THttpServer and Server.HTTPHandler). It's safe to use concurrently.
+type HTTPClient struct {
+	url      string
+	protocol ProtocolBuilder
+	client   *http.Client
+	header   http.Header
+	seq      int32
+}
+
+// NewHTTPClient returns an HTTPClient posting calls to url encoded with
+// protocol. If client is nil then http.DefaultClient is used.
+func NewHTTPClient(url string, protocol ProtocolBuilder, client *http.Client) *HTTPClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPClient{
+		url:      url,
+		protocol: protocol,
+		client:   client,
+		header:   make(http.Header),
+	}
+}
+
+// SetHeader sets a header sent with every request. It must not be called
+// concurrently with calls.
+func (c *HTTPClient) SetHeader(key, value string) {
+	c.header.Set(key, value)
+}
+
+// Call makes a request for method and decodes the reply into res. If req
+// is a oneway request then res is ignored.
+func (c *HTTPClient) Call(method string, req, res interface{}) error {
+	return c.CallContext(context.Background(), method, req, res)
+}
+
+type httpContextClient struct {
+	*HTTPClient
+}
+
+func (c httpContextClient) Call(ctx context.Context, method string, req, res interface{}) error {
+	return c.CallContext(ctx, method, req, res)
+}
+
+// ContextRPCClient returns c as a ContextRPCClient for use by clients
+// generated with -go.context.
+func (c *HTTPClient) ContextRPCClient() ContextRPCClient {
+	return httpContextClient{c}
+}
+
+// CallContext is like Call but the HTTP request uses ctx.
+func (c *HTTPClient) CallContext(ctx context.Context, method string, req, res interface{}) error {
+	ow := false
+	if o, ok := req.(oneway); ok {
+		ow = o.Oneway()
+	}
+	mtype := byte(MessageTypeCall)
+	if ow {
+		mtype = MessageTypeOneway
+	}
+	seq := atomic.AddInt32(&c.seq, 1)
+
+	buf := &bytes.Buffer{}
+	w := c.protocol.NewProtocolWriter(buf)
+	if err := w.WriteMessageBegin(method, mtype, seq); err != nil {
+		return err
+	}
+	if err := EncodeStruct(w, req); err != nil {
+		return err
+	}
+	if err := w.WriteMessageEnd(); err != nil {
+		return err
+	}
+
+	hreq, err := http.NewRequestWithContext(ctx, "POST", c.url, buf)
+	if err != nil {
+		return err
+	}
+	for k, v := range c.header {
+		hreq.Header[k] = v
+	}
+	hreq.Header.Set("Content-Type", HTTPContentType)
+	hreq.Header.Set("Accept", HTTPContentType)
+	hres, err := c.client.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer hres.Body.Close()
+	if hres.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, hres.Body)
+		return &HTTPStatusError{hres.StatusCode, hres.Status}
+	}
+	if ow {
+		io.Copy(ioutil.Discard, hres.Body)
+		return nil
+	}
+
+	r := c.protocol.NewProtocolReader(bufio.NewReader(hres.Body))
+	_, rtype, rseq, err := r.ReadMessageBegin()
+	if err != nil {
+		return err
+	}
+	if rseq != seq {
+		return &ApplicationException{"unexpected sequence ID in reply", ExceptionBadSequenceID}
+	}
+	var callErr error
+	switch rtype {
+	case MessageTypeException:
+		exc := &ApplicationException{}
+		err = DecodeStruct(r, exc)
+		callErr = exc
+	case MessageTypeReply:
+		err = DecodeStruct(r, res)
+	default:
+		return &ApplicationException{fmt.Sprintf("unexpected message type %d in reply", rtype), ExceptionInvalidMessageType}
+	}
+	if err != nil {
+		return err
+	}
+	if err := r.ReadMessageEnd(); err != nil {
+		return err
+	}
+	return callErr
+}
@@ -0,0 +1,130 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gentest "github.com/samuel/go-thrift/testfiles/generator/withFlags/go.context"
+)
+
+func TestHTTP(t *testing.T) {
+	store := newTestStore()
+	s := NewServer(gentest.NewStoreProcessor(store), nil)
+	log := &callLog{}
+	s.Use(func(ctx context.Context, method string, seq int32, req interface{}, next ServerHandler) (interface{}, error) {
+		log.add("%s", method)
+		return next(ctx, method, seq, req)
+	})
+	var contentType string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		s.HTTPHandler(CompactProtocol).ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	c := NewHTTPClient(ts.URL, CompactProtocol, nil)
+	client := &gentest.StoreClient{Client: c.ContextRPCClient()}
+	ctx := context.Background()
+
+	if err := client.Ping(ctx); err != nil {
+		t.Fatalf("Ping returned error: %+v", err)
+	}
+	if contentType != HTTPContentType {
+		t.Fatalf("Expected content type %s instead of %s", HTTPContentType, contentType)
+	}
+	if err := client.Put(ctx, "key", "value"); err != nil {
+		t.Fatalf("Put returned error: %+v", err)
+	}
+	<-store.put
+	if v, err := client.Get(ctx, "key"); err != nil {
+		t.Fatalf("Get returned error: %+v", err)
+	} else if v != "value" {
+		t.Fatalf("Expected 'value' instead of '%s'", v)
+	}
+	if _, err := client.Get(ctx, "other"); err == nil {
+		t.Fatal("Expected NotFound error")
+	} else if _, ok := err.(*gentest.NotFound); !ok {
+		t.Fatalf("Expected NotFound error instead of %+v", err)
+	}
+	if _, err := client.Get(ctx, "panic"); err == nil {
+		t.Fatal("Expected an error")
+	} else if e, ok := err.(*ApplicationException); !ok || e.Type != ExceptionInternalError {
+		t.Fatalf("Expected an internal error instead of %+v", err)
+	}
+	// Plain RPCClient calls
+	if err := c.Call("unknown", &gentest.StorePingRequest{}, &gentest.StorePingResponse{}); err == nil {
+		t.Fatal("Expected an error")
+	} else if e, ok := err.(*ApplicationException); !ok || e.Type != ExceptionUnknownMethod {
+		t.Fatalf("Expected an unknown method exception instead of %+v", err)
+	}
+	if calls := log.get(); len(calls) != 5 {
+		t.Fatalf("Expected 5 intercepted calls instead of %+v", calls)
+	}
+
+	// Only POST is accepted
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected status 405 instead of %d", res.StatusCode)
+	}
+
+	// Non-200 replies are returned as an HTTPStatusError
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer ts2.Close()
+	err = NewHTTPClient(ts2.URL, CompactProtocol, nil).Call("ping", &gentest.StorePingRequest{}, &gentest.StorePingResponse{})
+	if e, ok := err.(*HTTPStatusError); !ok || e.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected an HTTPStatusError instead of %+v", err)
+	}
+}
+
+// countingBody counts how often a request body is closed.
+type countingBody struct {
+	io.Reader
+	closes int
+}
+
+func (b *countingBody) Close() error {
+	b.closes++
+	return nil
+}
+
+func TestHTTPHandlerClosesTransport(t *testing.T) {
+	cb := &countingProtocolBuilder{ProtocolBuilder: BinaryProtocol}
+	h := NewServer(gentest.NewStoreProcessor(newTestStore()), nil).HTTPHandler(NewPooledProtocolBuilder(cb))
+	const n = 10
+	for i := 0; i < n; i++ {
+		b := &ClosingBuffer{&bytes.Buffer{}}
+		if err := writeMessage(NewTransport(b, BinaryProtocol), "ping", MessageTypeCall, 1, &gentest.StorePingRequest{}); err != nil {
+			t.Fatal(err)
+		}
+		body := &countingBody{Reader: b}
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Body = body
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 instead of %d: %s", w.Code, w.Body)
+		}
+		// The http.Server closes the body
+		if body.closes != 0 {
+			t.Fatalf("The handler closed the body %d times", body.closes)
+		}
+	}
+	// sync.Pool may drop some of them
+	if cb.readers >= n || cb.writers >= n {
+		t.Fatalf("Expected protocols to be reused but %d readers and %d writers were built", cb.readers, cb.writers)
+	}
+}
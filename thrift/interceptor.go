@@ -0,0 +1,376 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	errNextCalledTwice = errors.New("thrift: an interceptor of a net/rpc codec called next more than once")
+	errNotSent         = errors.New("thrift: an interceptor didn't call next")
+)
+
+// ClientInvoker makes a call with the request and decodes the reply into
+// the response.
+type ClientInvoker func(ctx context.Context, method string, seq int32, request, response interface{}) error
+
+// ClientInterceptor is called for every call made by a client. It may
+// inspect or modify the call and must call next to continue it.
+type ClientInterceptor func(ctx context.Context, method string, seq int32, request, response interface{}, next ClientInvoker) error
+
+// ServerHandler handles a decoded request and returns the response.
+type ServerHandler func(ctx context.Context, method string, seq int32, request interface{}) (response interface{}, err error)
+
+// ServerInterceptor is called for every request handled by a Server. It
+// may inspect or modify the request and response, or return an error
+// without calling next to reject the request. Errors that aren't an
+// *ApplicationException are sent to the client as an internal error.
+type ServerInterceptor func(ctx context.Context, method string, seq int32, request interface{}, next ServerHandler) (response interface{}, err error)
+
+// chainClient returns an invoker that calls the interceptors in order
+// followed by invoker.
+func chainClient(interceptors []ClientInterceptor, invoker ClientInvoker) ClientInvoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		ic, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, method string, seq int32, req, res interface{}) error {
+			return ic(ctx, method, seq, req, res, next)
+		}
+	}
+	return invoker
+}
+
+// chainServer returns a handler that calls the interceptors in order
+// followed by handler.
+func chainServer(interceptors []ServerInterceptor, handler ServerHandler) ServerHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		ic, next := interceptors[i], handler
+		handler = func(ctx context.Context, method string, seq int32, req interface{}) (interface{}, error) {
+			return ic(ctx, method, seq, req, next)
+		}
+	}
+	return handler
+}
+
+type interceptedClient struct {
+	invoker ClientInvoker
+}
+
+// NewInterceptedClient returns an RPCClient that calls the interceptors
+// for every call made with client (e.g. a Pool or Balancer).
+//
+// Interceptors get the response but RPCClient calls have no context and
+// the connection a call is made on isn't known at this level, so the
+// context is always context.Background() and the sequence ID is always 0.
+// Use ContextClient.Use for interceptors that need both, or
+// NewInterceptedClientCodec on the codec of every connection for the
+// sequence ID.
+func NewInterceptedClient(client RPCClient, interceptors ...ClientInterceptor) RPCClient {
+	return &interceptedClient{
+		invoker: chainClient(interceptors, func(ctx context.Context, method string, seq int32, req, res interface{}) error {
+			return client.Call(method, req, res)
+		}),
+	}
+}
+
+func (c *interceptedClient) Call(method string, request interface{}, response interface{}) error {
+	return c.invoker(context.Background(), method, 0, request, response)
+}
+
+type interceptedClientCodec struct {
+	rpc.ClientCodec
+	invoker ClientInvoker
+
+	mu    sync.Mutex
+	calls map[uint64]*codecCall // requests waiting for their reply
+	seq   uint64                // sequence ID of the response being read
+	err   string                // error of the response being read
+}
+
+// codecCall is a request passed through the interceptors of a codec.
+type codecCall struct {
+	request *rpc.Request
+	sent    int32         // set once next has been called
+	written chan error    // result of writing the request
+	reply   chan error    // result of reading the reply
+	done    chan struct{} // closed once the interceptors return
+}
+
+type codecCallKey struct{}
+
+// NewInterceptedClientCodec wraps a net/rpc client codec to call the
+// interceptors for every request it writes. They receive the service
+// method and sequence ID of the request and next returns once the reply
+// has been read. The call completes once the interceptors return so they
+// mustn't wait for other calls made with the codec. The request may be
+// modified but not replaced, and next may only be called once. Metadata
+// set on the context passed to next with WithOutgoingMetadata is sent with
+// the request if the transport carries headers.
+//
+// net/rpc doesn't pass the response struct to the codec when the request
+// is written so interceptors always get a nil response. The error they
+// return is only used if the request isn't written (e.g. to reject it
+// without calling next). Use ContextClient.Use or NewInterceptedClient
+// for interceptors that need the response.
+func NewInterceptedClientCodec(codec rpc.ClientCodec, interceptors ...ClientInterceptor) rpc.ClientCodec {
+	c := &interceptedClientCodec{
+		ClientCodec: codec,
+		calls:       make(map[uint64]*codecCall),
+	}
+	c.invoker = chainClient(interceptors, c.send)
+	return c
+}
+
+func (c *interceptedClientCodec) WriteRequest(request *rpc.Request, thriftStruct interface{}) error {
+	call := &codecCall{
+		request: request,
+		written: make(chan error, 1),
+		reply:   make(chan error, 1),
+		done:    make(chan struct{}),
+	}
+	ctx := context.WithValue(context.Background(), codecCallKey{}, call)
+	method, seq := request.ServiceMethod, int32(request.Seq)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.invoker(ctx, method, seq, thriftStruct, nil)
+		close(call.done)
+	}()
+	// net/rpc holds its request lock until WriteRequest returns so the
+	// request is written by next while waiting here
+	select {
+	case err := <-call.written:
+		return err
+	case err := <-done:
+		if atomic.LoadInt32(&call.sent) != 0 {
+			return <-call.written
+		}
+		if err == nil {
+			err = errNotSent
+		}
+		return err
+	}
+}
+
+// send writes the request and waits for its reply.
+func (c *interceptedClientCodec) send(ctx context.Context, method string, seq int32, req, res interface{}) error {
+	call := ctx.Value(codecCallKey{}).(*codecCall)
+	if !atomic.CompareAndSwapInt32(&call.sent, 0, 1) {
+		return errNextCalledTwice
+	}
+	// Add the call before writing since the reply may be read first
+	c.mu.Lock()
+	c.calls[call.request.Seq] = call
+	c.mu.Unlock()
+	for k, v := range OutgoingMetadata(ctx) {
+		setCodecHeader(c.ClientCodec, k, v)
+	}
+	err := c.ClientCodec.WriteRequest(call.request, req)
+	call.written <- err
+	if err != nil {
+		c.mu.Lock()
+		delete(c.calls, call.request.Seq)
+		c.mu.Unlock()
+		return err
+	}
+	return <-call.reply
+}
+
+func (c *interceptedClientCodec) ReadResponseHeader(response *rpc.Response) error {
+	err := c.ClientCodec.ReadResponseHeader(response)
+	if err != nil {
+		c.failAll(err)
+		return err
+	}
+	c.seq = response.Seq
+	c.err = response.Error
+	return nil
+}
+
+func (c *interceptedClientCodec) ReadResponseBody(thriftStruct interface{}) error {
+	err := c.ClientCodec.ReadResponseBody(thriftStruct)
+	callErr := err
+	if c.err != "" {
+		callErr = rpc.ServerError(c.err)
+	}
+	c.mu.Lock()
+	call := c.calls[c.seq]
+	delete(c.calls, c.seq)
+	c.mu.Unlock()
+	if call != nil {
+		call.reply <- callErr
+		<-call.done
+	}
+	return err
+}
+
+func (c *interceptedClientCodec) ReadHeaders() map[string]string {
+	return readCodecHeaders(c.ClientCodec)
+}
+
+func (c *interceptedClientCodec) SetWriteHeader(key, value string) {
+	setCodecHeader(c.ClientCodec, key, value)
+}
+
+func (c *interceptedClientCodec) Close() error {
+	err := c.ClientCodec.Close()
+	c.failAll(rpc.ErrShutdown)
+	return err
+}
+
+// failAll completes the requests waiting for a reply with err.
+func (c *interceptedClientCodec) failAll(err error) {
+	c.mu.Lock()
+	calls := c.calls
+	c.calls = make(map[uint64]*codecCall)
+	c.mu.Unlock()
+	for _, call := range calls {
+		call.reply <- err
+	}
+}
+
+type interceptedServerCodec struct {
+	rpc.ServerCodec
+	handler ServerHandler
+	method  string            // service method of the request being read
+	seq     uint64            // sequence ID of the request being read
+	md      map[string]string // metadata of the request being read
+
+	mu    sync.Mutex
+	calls map[uint64]*handlerCall
+}
+
+// handlerCall is a request passed through the interceptors of a codec.
+type handlerCall struct {
+	dispatched int32         // set once next has been called
+	next       chan struct{} // closed once next has been called
+	reply      chan handlerResult
+	done       chan handlerResult
+}
+
+type handlerResult struct {
+	res interface{}
+	err error
+}
+
+type handlerCallKey struct{}
+
+// NewInterceptedServerCodec wraps a net/rpc server codec to call the
+// interceptors for every request it reads. They receive the service
+// method and sequence ID of the request, and next returns once net/rpc
+// has called the method. An interceptor may return without calling next
+// to reject the request, and may change the response. The request may be
+// modified but not replaced, and next may only be called once. The
+// context holds the metadata received with the request (see
+// IncomingMetadata).
+//
+// net/rpc calls the method in a goroutine of its own so a panic in it
+// can't be seen or recovered by the interceptors and still crashes the
+// program. Use Server.Use for interceptors that recover panics; Server
+// turns a panic into an internal error that the interceptors see.
+func NewInterceptedServerCodec(codec rpc.ServerCodec, interceptors ...ServerInterceptor) rpc.ServerCodec {
+	c := &interceptedServerCodec{
+		ServerCodec: codec,
+		calls:       make(map[uint64]*handlerCall),
+	}
+	c.handler = chainServer(interceptors, c.dispatch)
+	return c
+}
+
+func (c *interceptedServerCodec) ReadRequestHeader(request *rpc.Request) error {
+	err := c.ServerCodec.ReadRequestHeader(request)
+	c.method = request.ServiceMethod
+	c.seq = request.Seq
+	c.md = readCodecHeaders(c.ServerCodec)
+	return err
+}
+
+func (c *interceptedServerCodec) ReadRequestBody(thriftStruct interface{}) error {
+	if err := c.ServerCodec.ReadRequestBody(thriftStruct); err != nil || thriftStruct == nil {
+		// net/rpc doesn't call a method
+		return err
+	}
+	call := &handlerCall{
+		next:  make(chan struct{}),
+		reply: make(chan handlerResult, 1),
+		done:  make(chan handlerResult, 1),
+	}
+	c.mu.Lock()
+	c.calls[c.seq] = call
+	c.mu.Unlock()
+	ctx := withIncomingMetadata(context.Background(), c.md)
+	ctx = context.WithValue(ctx, handlerCallKey{}, call)
+	method, seq := c.method, int32(c.seq)
+	go func() {
+		res, err := c.handler(ctx, method, seq, thriftStruct)
+		call.done <- handlerResult{res, err}
+	}()
+	select {
+	case <-call.next:
+		return nil
+	case r := <-call.done:
+		call.done <- r
+		if atomic.LoadInt32(&call.dispatched) != 0 {
+			return nil
+		}
+		// Rejected by an interceptor. net/rpc writes a response for the
+		// error without calling the method and WriteResponse replaces it
+		// with the result.
+		if r.err == nil {
+			return errNotSent
+		}
+		return r.err
+	}
+}
+
+// dispatch lets net/rpc call the method and waits for its response.
+func (c *interceptedServerCodec) dispatch(ctx context.Context, method string, seq int32, req interface{}) (interface{}, error) {
+	call := ctx.Value(handlerCallKey{}).(*handlerCall)
+	if !atomic.CompareAndSwapInt32(&call.dispatched, 0, 1) {
+		return nil, errNextCalledTwice
+	}
+	close(call.next)
+	r := <-call.reply
+	return r.res, r.err
+}
+
+func (c *interceptedServerCodec) ReadHeaders() map[string]string {
+	return readCodecHeaders(c.ServerCodec)
+}
+
+func (c *interceptedServerCodec) SetWriteHeader(key, value string) {
+	setCodecHeader(c.ServerCodec, key, value)
+}
+
+func (c *interceptedServerCodec) WriteResponse(response *rpc.Response, thriftStruct interface{}) error {
+	c.mu.Lock()
+	call := c.calls[response.Seq]
+	delete(c.calls, response.Seq)
+	c.mu.Unlock()
+	if call == nil {
+		return c.ServerCodec.WriteResponse(response, thriftStruct)
+	}
+	if atomic.LoadInt32(&call.dispatched) != 0 {
+		var err error
+		if response.Error != "" {
+			err = serverException(response.Error)
+		}
+		call.reply <- handlerResult{thriftStruct, err}
+	}
+	r := <-call.done
+	response.Error = ""
+	thriftStruct = r.res
+	if r.err != nil {
+		response.Error = r.err.Error()
+		thriftStruct = nil
+		if e, ok := r.err.(*ApplicationException); ok {
+			thriftStruct = e
+		}
+	}
+	return c.ServerCodec.WriteResponse(response, thriftStruct)
+}
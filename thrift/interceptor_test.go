@@ -0,0 +1,269 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"testing"
+
+	gentest "github.com/samuel/go-thrift/testfiles/generator/withFlags/go.context"
+)
+
+type callLog struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (l *callLog) add(format string, args ...interface{}) {
+	l.mu.Lock()
+	l.calls = append(l.calls, fmt.Sprintf(format, args...))
+	l.mu.Unlock()
+}
+
+func (l *callLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	calls := l.calls
+	l.calls = nil
+	return calls
+}
+
+func TestServerInterceptors(t *testing.T) {
+	log := &callLog{}
+	store := newTestStore()
+	ln, addr := listenTCP()
+	s := NewServer(gentest.NewStoreProcessor(store), nil)
+	s.Use(
+		func(ctx context.Context, method string, seq int32, req interface{}, next ServerHandler) (interface{}, error) {
+			log.add("log %s %d", method, seq)
+			res, err := next(ctx, method, seq, req)
+			log.add("done %s %v", method, err)
+			return res, err
+		},
+		func(ctx context.Context, method string, seq int32, req interface{}, next ServerHandler) (interface{}, error) {
+			if r, ok := req.(*gentest.StoreGetRequest); ok && r.Key == "secret" {
+				return nil, &ApplicationException{"permission denied", ExceptionUnknown}
+			}
+			return next(ctx, method, seq, req)
+		},
+	)
+	go s.Serve(ln)
+	defer s.Close()
+
+	ctx := context.Background()
+	c, err := DialContext(ctx, "tcp", addr, true, BinaryProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client := &gentest.StoreClient{Client: c}
+
+	if err := client.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"log ping 1", "done ping <nil>"}; !reflect.DeepEqual(log.get(), exp) {
+		t.Fatalf("Expected interceptor calls %+v", exp)
+	}
+
+	if _, err := client.Get(ctx, "secret"); err == nil {
+		t.Fatal("Expected an error")
+	} else if e, ok := err.(*ApplicationException); !ok || e.Message != "permission denied" {
+		t.Fatalf("Expected permission denied instead of %+v", err)
+	}
+	if exp := []string{"log get 2", "done get Unknown Exception: permission denied"}; !reflect.DeepEqual(log.get(), exp) {
+		t.Fatalf("Expected interceptor calls %+v", exp)
+	}
+
+	// Panics are still recovered
+	if _, err := client.Get(ctx, "panic"); err == nil {
+		t.Fatal("Expected an error")
+	} else if e, ok := err.(*ApplicationException); !ok || e.Type != ExceptionInternalError {
+		t.Fatalf("Expected an internal error instead of %+v", err)
+	}
+}
+
+func TestContextClientInterceptors(t *testing.T) {
+	store := newTestStore()
+	s, addr := startNativeServer(t, store)
+	defer s.Close()
+
+	ctx := context.Background()
+	c, err := DialContext(ctx, "tcp", addr, true, BinaryProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	log := &callLog{}
+	c.Use(
+		func(ctx context.Context, method string, seq int32, req, res interface{}, next ClientInvoker) error {
+			log.add("first %s %d", method, seq)
+			return next(ctx, method, seq, req, res)
+		},
+		func(ctx context.Context, method string, seq int32, req, res interface{}, next ClientInvoker) error {
+			log.add("second %s %d", method, seq)
+			err := next(ctx, method, seq, req, res)
+			if r, ok := res.(*gentest.StoreGetResponse); ok && err == nil && r.Nf != nil {
+				log.add("exception %s", r.Nf.Key)
+			}
+			return err
+		},
+	)
+	client := &gentest.StoreClient{Client: c}
+
+	if err := client.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(ctx, "missing"); err == nil {
+		t.Fatal("Expected NotFound error")
+	}
+	exp := []string{"first ping 1", "second ping 1", "first get 2", "second get 2", "exception missing"}
+	if calls := log.get(); !reflect.DeepEqual(calls, exp) {
+		t.Fatalf("Expected interceptor calls %+v instead of %+v", exp, calls)
+	}
+}
+
+func TestInterceptedClient(t *testing.T) {
+	log := &callLog{}
+	fc := &flakyClient{}
+	c := NewInterceptedClient(fc, func(ctx context.Context, method string, seq int32, req, res interface{}, next ClientInvoker) error {
+		log.add("%s %d", method, req.(*TestRequest).Value)
+		return next(ctx, method, seq, req, res)
+	})
+	res := &TestResponse{}
+	if err := c.Call("Success", &TestRequest{123}, res); err != nil {
+		t.Fatal(err)
+	} else if res.Value != 123 {
+		t.Fatalf("Expected 123 instead of %d", res.Value)
+	}
+	if exp := []string{"Success 123"}; !reflect.DeepEqual(log.get(), exp) {
+		t.Fatalf("Expected interceptor calls %+v", exp)
+	}
+}
+
+func TestInterceptedCodecs(t *testing.T) {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Thrift", new(TestService)); err != nil {
+		t.Fatal(err)
+	}
+	slog := &callLog{}
+	cconn, sconn := tcpPipe(t)
+	go srv.ServeCodec(NewInterceptedServerCodec(NewServerCodec(NewTransport(sconn, BinaryProtocol)),
+		func(ctx context.Context, method string, seq int32, req interface{}, next ServerHandler) (interface{}, error) {
+			slog.add("%s %d", method, seq)
+			res, err := next(ctx, method, seq, req)
+			slog.add("done %s %v", method, err)
+			return res, err
+		},
+		func(ctx context.Context, method string, seq int32, req interface{}, next ServerHandler) (interface{}, error) {
+			switch req.(*TestRequest).Value {
+			case 0:
+				return nil, &ApplicationException{"permission denied", ExceptionUnknown}
+			case 7:
+				res, err := next(ctx, method, seq, req)
+				res.(*TestResponse).Value++
+				return res, err
+			}
+			return next(ctx, method, seq, req)
+		},
+	))
+	clog := &callLog{}
+	client := rpc.NewClientWithCodec(NewInterceptedClientCodec(NewClientCodec(NewTransport(cconn, BinaryProtocol), false),
+		func(ctx context.Context, method string, seq int32, req, res interface{}, next ClientInvoker) error {
+			if method == "Skip" {
+				return errors.New("skipped")
+			}
+			clog.add("%s %d", method, seq)
+			err := next(ctx, method, seq, req, res)
+			clog.add("done %s %v", method, err)
+			return err
+		},
+	))
+	defer client.Close()
+
+	res := &TestResponse{}
+	if err := client.Call("Success", &TestRequest{1}, res); err != nil {
+		t.Fatal(err)
+	} else if res.Value != 1 {
+		t.Fatalf("Expected 1 instead of %d", res.Value)
+	}
+	if err := client.Call("Success", &TestRequest{0}, res); err == nil || err.Error() != "Unknown Exception: permission denied" {
+		t.Fatalf("Expected permission denied instead of %+v", err)
+	}
+	if err := client.Call("Fail", &TestRequest{5}, res); err == nil || err.Error() != "Internal Error: fail" {
+		t.Fatalf("Expected fail instead of %+v", err)
+	}
+	if err := client.Call("Success", &TestRequest{7}, res); err != nil {
+		t.Fatal(err)
+	} else if res.Value != 8 {
+		t.Fatalf("Expected 8 instead of %d", res.Value)
+	}
+	if err := client.Call("Skip", &TestRequest{1}, res); err == nil || err.Error() != "skipped" {
+		t.Fatalf("Expected skipped instead of %+v", err)
+	}
+	if err := client.Call("Success", &TestRequest{2}, res); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"Thrift.Success 0", "done Thrift.Success <nil>",
+		"Thrift.Success 1", "done Thrift.Success Unknown Exception: permission denied",
+		"Thrift.Fail 2", "done Thrift.Fail Internal Error: fail",
+		"Thrift.Success 3", "done Thrift.Success <nil>",
+		"Thrift.Success 5", "done Thrift.Success <nil>",
+	}
+	if calls := slog.get(); !reflect.DeepEqual(calls, exp) {
+		t.Fatalf("Expected server interceptor calls %+v instead of %+v", exp, calls)
+	}
+	exp = []string{
+		"Success 0", "done Success <nil>",
+		"Success 1", "done Success Unknown Exception: permission denied",
+		"Fail 2", "done Fail Internal Error: fail",
+		"Success 3", "done Success <nil>",
+		"Success 5", "done Success <nil>",
+	}
+	if calls := clog.get(); !reflect.DeepEqual(calls, exp) {
+		t.Fatalf("Expected client interceptor calls %+v instead of %+v", exp, calls)
+	}
+}
+
+func TestContextClientInterceptorRetry(t *testing.T) {
+	log := &callLog{}
+	ln, addr := listenTCP()
+	s := NewServer(gentest.NewStoreProcessor(newTestStore()), nil)
+	s.Use(func(ctx context.Context, method string, seq int32, req interface{}, next ServerHandler) (interface{}, error) {
+		log.add("%s %d", method, seq)
+		return next(ctx, method, seq, req)
+	})
+	go s.Serve(ln)
+	defer s.Close()
+
+	ctx := context.Background()
+	c, err := DialContext(ctx, "tcp", addr, true, BinaryProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Use(func(ctx context.Context, method string, seq int32, req, res interface{}, next ClientInvoker) error {
+		if err := next(ctx, method, seq, req, res); err != nil {
+			return err
+		}
+		return next(ctx, method, seq, req, res)
+	})
+	client := &gentest.StoreClient{Client: c}
+	if err := client.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if exp, calls := []string{"ping 1", "ping 2", "ping 3", "ping 4"}, log.get(); !reflect.DeepEqual(calls, exp) {
+		t.Fatalf("Expected server interceptor calls %+v instead of %+v", exp, calls)
+	}
+}
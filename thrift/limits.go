@@ -0,0 +1,345 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"fmt"
+	"io"
+)
+
+// ReaderLimits bounds the resources used while reading values from
+// untrusted peers. A zero value for any limit means unlimited.
+type ReaderLimits struct {
+	// MaxStringLength is the maximum length of a string or binary value.
+	MaxStringLength int
+	// MaxContainerSize is the maximum number of elements in a list, set,
+	// or map.
+	MaxContainerSize int
+	// MaxDepth is the maximum nesting of structs and containers.
+	MaxDepth int
+	// MaxTotalBytes is the maximum number of bytes read for a single
+	// message (or in total when not reading messages). Declared string
+	// lengths and container sizes are checked against what's left of it
+	// before anything is allocated.
+	MaxTotalBytes int64
+}
+
+type ErrStringTooLong struct {
+	Size, MaxSize int64
+}
+
+func (e ErrStringTooLong) Error() string {
+	return fmt.Sprintf("thrift: string length while reading over allowed size (%d > %d)", e.Size, e.MaxSize)
+}
+
+type ErrContainerTooBig struct {
+	Size, MaxSize int64
+}
+
+func (e ErrContainerTooBig) Error() string {
+	return fmt.Sprintf("thrift: container size while reading over allowed size (%d > %d)", e.Size, e.MaxSize)
+}
+
+type ErrDepthExceeded struct {
+	MaxDepth int
+}
+
+func (e ErrDepthExceeded) Error() string {
+	return fmt.Sprintf("thrift: nesting depth while reading over allowed depth (%d)", e.MaxDepth)
+}
+
+type ErrMessageTooBig struct {
+	MaxSize int64
+}
+
+func (e ErrMessageTooBig) Error() string {
+	return fmt.Sprintf("thrift: message size while reading over allowed size (%d)", e.MaxSize)
+}
+
+// stringLimiter is implemented by readers that allocate based on the
+// declared length of strings so they can check it before allocating.
+type stringLimiter interface {
+	setMaxStringLength(n int)
+}
+
+// bufferingReader is implemented by readers that read ahead of what they've
+// consumed (e.g. the text protocol) so that the bytes they hold aren't
+// counted against the message budget.
+type bufferingReader interface {
+	buffered() int
+}
+
+type limitedProtocolBuilder struct {
+	builder ProtocolBuilder
+	limits  ReaderLimits
+}
+
+// NewLimitedProtocolBuilder returns a ProtocolBuilder that creates readers
+// which enforce the given limits, returning one of ErrStringTooLong,
+// ErrContainerTooBig, ErrDepthExceeded, or ErrMessageTooBig when a value
+// goes over. Since the decoder, ReadValue, and SkipValue all read through
+// the ProtocolReader they are bounded as well. Writers are unchanged.
+func NewLimitedProtocolBuilder(builder ProtocolBuilder, limits ReaderLimits) ProtocolBuilder {
+	return &limitedProtocolBuilder{
+		builder: builder,
+		limits:  limits,
+	}
+}
+
+func (p *limitedProtocolBuilder) NewProtocolReader(r io.Reader) ProtocolReader {
+	lr := &limitedProtocolReader{builder: p.builder, limits: p.limits}
+	if p.limits.MaxTotalBytes > 0 {
+		lr.cr = &countingReader{max: p.limits.MaxTotalBytes}
+	}
+	lr.setReader(r)
+	return lr
+}
+
+func (p *limitedProtocolBuilder) NewProtocolWriter(w io.Writer) ProtocolWriter {
+	return p.builder.NewProtocolWriter(w)
+}
+
+// countingReader returns ErrMessageTooBig once more than max bytes have
+// been read since the last reset.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	remaining := c.max - c.n
+	if remaining <= 0 {
+		return 0, ErrMessageTooBig{c.max}
+	}
+	if int64(len(b)) > remaining {
+		b = b[:remaining]
+	}
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	if c.n >= c.max {
+		return 0, ErrMessageTooBig{c.max}
+	}
+	if br, ok := c.r.(io.ByteReader); ok {
+		b, err := br.ReadByte()
+		if err == nil {
+			c.n++
+		}
+		return b, err
+	}
+	var b [1]byte
+	_, err := io.ReadFull(c, b[:])
+	return b[0], err
+}
+
+type limitedProtocolReader struct {
+	ProtocolReader
+	builder ProtocolBuilder
+	limits  ReaderLimits
+	cr      *countingReader
+	sl      stringLimiter   // set if the wrapped reader implements it
+	br      bufferingReader // set if the wrapped reader implements it
+	depth   int
+}
+
+// setReader switches the wrapped reader to read from r, creating it if
+// it can't be reset.
+func (r *limitedProtocolReader) setReader(rd io.Reader) {
+	if r.cr != nil {
+		r.cr.r = rd
+		r.cr.n = 0
+		rd = r.cr
+	}
+	if pr, ok := r.ProtocolReader.(ResettableProtocolReader); ok {
+		pr.Reset(rd)
+	} else {
+		r.ProtocolReader = r.builder.NewProtocolReader(rd)
+		r.sl, _ = r.ProtocolReader.(stringLimiter)
+		r.br, _ = r.ProtocolReader.(bufferingReader)
+	}
+	if r.sl != nil {
+		r.sl.setMaxStringLength(r.limits.MaxStringLength)
+	}
+	r.depth = 0
+}
+
+// Reset discards any state and switches the reader to read from rd.
+func (r *limitedProtocolReader) Reset(rd io.Reader) {
+	r.setReader(rd)
+}
+
+func (r *limitedProtocolReader) setMaxStringLength(n int) {
+	r.limits.MaxStringLength = n
+	if r.sl != nil {
+		r.sl.setMaxStringLength(n)
+	}
+}
+
+// buffered returns the number of bytes read ahead by the wrapped reader.
+func (r *limitedProtocolReader) buffered() int64 {
+	if r.br == nil {
+		return 0
+	}
+	return int64(r.br.buffered())
+}
+
+// remaining returns the number of bytes left in the message budget or -1
+// if there isn't one. Bytes read ahead by the wrapped reader are only
+// counted once they're used.
+func (r *limitedProtocolReader) remaining() int64 {
+	if r.cr == nil {
+		return -1
+	}
+	return r.cr.max - (r.cr.n - r.buffered())
+}
+
+func (r *limitedProtocolReader) push() error {
+	if r.limits.MaxDepth > 0 && r.depth >= r.limits.MaxDepth {
+		return ErrDepthExceeded{r.limits.MaxDepth}
+	}
+	r.depth++
+	return nil
+}
+
+// pop undoes a push when reading the beginning of a struct or container
+// fails since its end won't be read.
+func (r *limitedProtocolReader) pop(err error) error {
+	if err != nil {
+		r.depth--
+	}
+	return err
+}
+
+func (r *limitedProtocolReader) checkSize(n int) error {
+	if r.limits.MaxContainerSize > 0 && n > r.limits.MaxContainerSize {
+		return ErrContainerTooBig{int64(n), int64(r.limits.MaxContainerSize)}
+	}
+	// Every element takes at least a byte so a container declaring more
+	// than what's left of the budget can't fit. Decoders may allocate
+	// based on the declared size.
+	if rem := r.remaining(); rem >= 0 && int64(n) > rem {
+		return ErrMessageTooBig{r.cr.max}
+	}
+	return nil
+}
+
+func (r *limitedProtocolReader) ReadMessageBegin() (string, byte, int32, error) {
+	r.depth = 0
+	if r.cr != nil {
+		// What's been read ahead is the start of this message
+		r.cr.n = r.buffered()
+	}
+	return r.ProtocolReader.ReadMessageBegin()
+}
+
+func (r *limitedProtocolReader) ReadStructBegin() error {
+	if err := r.push(); err != nil {
+		return err
+	}
+	return r.pop(r.ProtocolReader.ReadStructBegin())
+}
+
+func (r *limitedProtocolReader) ReadStructEnd() error {
+	r.depth--
+	return r.ProtocolReader.ReadStructEnd()
+}
+
+func (r *limitedProtocolReader) ReadMapBegin() (byte, byte, int, error) {
+	if err := r.push(); err != nil {
+		return 0, 0, 0, err
+	}
+	kt, vt, n, err := r.ProtocolReader.ReadMapBegin()
+	if err == nil {
+		err = r.checkSize(n)
+	}
+	return kt, vt, n, r.pop(err)
+}
+
+func (r *limitedProtocolReader) ReadMapEnd() error {
+	r.depth--
+	return r.ProtocolReader.ReadMapEnd()
+}
+
+func (r *limitedProtocolReader) ReadListBegin() (byte, int, error) {
+	if err := r.push(); err != nil {
+		return 0, 0, err
+	}
+	et, n, err := r.ProtocolReader.ReadListBegin()
+	if err == nil {
+		err = r.checkSize(n)
+	}
+	return et, n, r.pop(err)
+}
+
+func (r *limitedProtocolReader) ReadListEnd() error {
+	r.depth--
+	return r.ProtocolReader.ReadListEnd()
+}
+
+func (r *limitedProtocolReader) ReadSetBegin() (byte, int, error) {
+	if err := r.push(); err != nil {
+		return 0, 0, err
+	}
+	et, n, err := r.ProtocolReader.ReadSetBegin()
+	if err == nil {
+		err = r.checkSize(n)
+	}
+	return et, n, r.pop(err)
+}
+
+func (r *limitedProtocolReader) ReadSetEnd() error {
+	r.depth--
+	return r.ProtocolReader.ReadSetEnd()
+}
+
+// limitString lowers the maximum string length of the wrapped reader to
+// what's left of the message budget so that a declared length is checked
+// before allocating. It returns whether the budget is the lower limit.
+func (r *limitedProtocolReader) limitString() bool {
+	rem := r.remaining()
+	if r.sl == nil || rem < 0 {
+		return false
+	}
+	if max := int64(r.limits.MaxStringLength); max > 0 && max <= rem {
+		r.sl.setMaxStringLength(int(max))
+		return false
+	}
+	// The length prefix isn't counted yet so this is a few bytes loose but
+	// still bounds the allocation. Zero would mean unlimited.
+	if rem < 1 {
+		rem = 1
+	}
+	r.sl.setMaxStringLength(int(rem))
+	return true
+}
+
+// checkString converts an error from a string that's over the message
+// budget to ErrMessageTooBig, and checks the length after reading for
+// protocols that don't allocate based on a declared length (e.g. JSON).
+func (r *limitedProtocolReader) checkString(n int, err error, budget bool) error {
+	if _, ok := err.(ErrStringTooLong); ok && budget {
+		return ErrMessageTooBig{r.cr.max}
+	}
+	if err == nil && r.limits.MaxStringLength > 0 && n > r.limits.MaxStringLength {
+		return ErrStringTooLong{int64(n), int64(r.limits.MaxStringLength)}
+	}
+	return err
+}
+
+func (r *limitedProtocolReader) ReadString() (string, error) {
+	budget := r.limitString()
+	s, err := r.ProtocolReader.ReadString()
+	return s, r.checkString(len(s), err, budget)
+}
+
+func (r *limitedProtocolReader) ReadBytes() ([]byte, error) {
+	budget := r.limitString()
+	b, err := r.ProtocolReader.ReadBytes()
+	return b, r.checkString(len(b), err, budget)
+}
@@ -0,0 +1,208 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestLimitedProtocolStringLength(t *testing.T) {
+	limits := ReaderLimits{MaxStringLength: 8}
+	for _, p := range []ProtocolBuilder{BinaryProtocol, CompactProtocol, JSONProtocol} {
+		b := &bytes.Buffer{}
+		w := p.NewProtocolWriter(b)
+		w.WriteString("short")
+		w.WriteString("much too long")
+		r := NewLimitedProtocolBuilder(p, limits).NewProtocolReader(b)
+		if s, err := r.ReadString(); err != nil {
+			t.Fatal(err)
+		} else if s != "short" {
+			t.Fatalf("Expected 'short' instead of '%s'", s)
+		}
+		if _, err := r.ReadString(); err != (ErrStringTooLong{13, 8}) {
+			t.Fatalf("Expected ErrStringTooLong instead of %+v", err)
+		}
+	}
+
+	// The declared length should be checked before allocating
+	b := &bytes.Buffer{}
+	NewBinaryProtocolWriter(b, true).WriteI32(1 << 30)
+	r := NewLimitedProtocolBuilder(BinaryProtocol, limits).NewProtocolReader(b)
+	if _, err := r.ReadBytes(); err != (ErrStringTooLong{1 << 30, 8}) {
+		t.Fatalf("Expected ErrStringTooLong instead of %+v", err)
+	}
+}
+
+func TestLimitedProtocolContainerSize(t *testing.T) {
+	b := &bytes.Buffer{}
+	w := NewBinaryProtocolWriter(b, true)
+	w.WriteListBegin(TypeI32, 1<<30)
+	r := NewLimitedProtocolBuilder(BinaryProtocol, ReaderLimits{MaxContainerSize: 100}).NewProtocolReader(b)
+	if _, err := ReadValue(r, TypeList); err != (ErrContainerTooBig{1 << 30, 100}) {
+		t.Fatalf("Expected ErrContainerTooBig instead of %+v", err)
+	}
+}
+
+func TestLimitedProtocolDepth(t *testing.T) {
+	b := &bytes.Buffer{}
+	w := NewCompactProtocolWriter(b)
+	for i := 0; i < 10; i++ {
+		w.WriteListBegin(TypeList, 1)
+	}
+	r := NewLimitedProtocolBuilder(CompactProtocol, ReaderLimits{MaxDepth: 5}).NewProtocolReader(b)
+	if err := SkipValue(r, TypeList); err != (ErrDepthExceeded{5}) {
+		t.Fatalf("Expected ErrDepthExceeded instead of %+v", err)
+	}
+}
+
+func TestLimitedProtocolDepthAfterError(t *testing.T) {
+	b := &bytes.Buffer{}
+	w := NewBinaryProtocolWriter(b, true)
+	w.WriteListBegin(TypeI32, 1000)
+	w.WriteListBegin(TypeI32, 0)
+	w.WriteListEnd()
+	r := NewLimitedProtocolBuilder(BinaryProtocol, ReaderLimits{MaxDepth: 1, MaxContainerSize: 100}).NewProtocolReader(b)
+	if _, _, err := r.ReadListBegin(); err != (ErrContainerTooBig{1000, 100}) {
+		t.Fatalf("Expected ErrContainerTooBig instead of %+v", err)
+	}
+	// The rejected list doesn't count towards the depth
+	if _, _, err := r.ReadListBegin(); err != nil {
+		t.Fatalf("Expected the next list to be read instead of %+v", err)
+	}
+}
+
+func TestLimitedProtocolTotalBytesText(t *testing.T) {
+	b := &bytes.Buffer{}
+	w := NewTextProtocolWriter(b)
+	list := []interface{}{int32(1), int32(2), int32(3)}
+	for i := 0; i < 3; i++ {
+		w.WriteMessageBegin("test", MessageTypeCall, int32(i))
+		w.WriteListBegin(TypeI32, len(list))
+		for _, v := range list {
+			w.WriteI32(v.(int32))
+		}
+		w.WriteListEnd()
+		w.WriteMessageEnd()
+	}
+	size := int64(b.Len() / 3)
+	// Read ahead by the text reader doesn't count against the budget
+	r := NewLimitedProtocolBuilder(TextProtocol, ReaderLimits{MaxTotalBytes: size}).NewProtocolReader(b)
+	for i := 0; i < 3; i++ {
+		if _, _, _, err := r.ReadMessageBegin(); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := ReadValue(r, TypeList); err != nil {
+			t.Fatalf("Message %d: %+v", i, err)
+		} else if !reflect.DeepEqual(v, list) {
+			t.Fatalf("Message %d: read %+v", i, v)
+		}
+		if err := r.ReadMessageEnd(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLimitedProtocolTotalBytes(t *testing.T) {
+	s := &TestStruct2{Str: "foo", Binary: []byte("bar")}
+	b := &bytes.Buffer{}
+	w := NewBinaryProtocolWriter(b, true)
+	for i := 0; i < 2; i++ {
+		w.WriteMessageBegin("test", MessageTypeCall, int32(i))
+		if err := EncodeStruct(w, s); err != nil {
+			t.Fatal(err)
+		}
+		w.WriteMessageEnd()
+	}
+	size := int64(b.Len() / 2)
+	data := b.Bytes()
+
+	// The count is reset for every message
+	r := NewLimitedProtocolBuilder(BinaryProtocol, ReaderLimits{MaxTotalBytes: size}).NewProtocolReader(bytes.NewReader(data))
+	for i := 0; i < 2; i++ {
+		if _, _, _, err := r.ReadMessageBegin(); err != nil {
+			t.Fatal(err)
+		}
+		s2 := &TestStruct2{}
+		if err := DecodeStruct(r, s2); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.ReadMessageEnd(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r = NewLimitedProtocolBuilder(BinaryProtocol, ReaderLimits{MaxTotalBytes: size - 1}).NewProtocolReader(bytes.NewReader(data))
+	if _, _, _, err := r.ReadMessageBegin(); err != nil {
+		t.Fatal(err)
+	}
+	if err := DecodeStruct(r, &TestStruct2{}); err != (ErrMessageTooBig{size - 1}) {
+		t.Fatalf("Expected ErrMessageTooBig instead of %+v", err)
+	}
+}
+
+func TestLimitedProtocolTotalBytesDeclaredLength(t *testing.T) {
+	limits := ReaderLimits{MaxTotalBytes: 64}
+	// A length of 2 GiB - 1
+	lengths := map[ProtocolBuilder][]byte{
+		BinaryProtocol:  {0x7f, 0xff, 0xff, 0xff},
+		CompactProtocol: {0xff, 0xff, 0xff, 0xff, 0x07},
+	}
+	for p, data := range lengths {
+		// A huge declared length must fail before allocating
+		r := NewLimitedProtocolBuilder(p, limits).NewProtocolReader(bytes.NewReader(data))
+		allocs := testing.AllocsPerRun(1, func() {
+			if _, err := r.ReadBytes(); err != (ErrMessageTooBig{64}) {
+				t.Fatalf("Expected ErrMessageTooBig instead of %+v", err)
+			}
+			r.(ResettableProtocolReader).Reset(bytes.NewReader(data))
+		})
+		if allocs > 10 {
+			t.Fatalf("Expected few allocations instead of %v", allocs)
+		}
+		if _, err := r.ReadString(); err != (ErrMessageTooBig{64}) {
+			t.Fatalf("Expected ErrMessageTooBig instead of %+v", err)
+		}
+
+		// As must a container declaring more elements than bytes left
+		b := &bytes.Buffer{}
+		p.NewProtocolWriter(b).WriteListBegin(TypeI32, 1<<20)
+		r = NewLimitedProtocolBuilder(p, limits).NewProtocolReader(b)
+		if _, err := ReadValue(r, TypeList); err != (ErrMessageTooBig{64}) {
+			t.Fatalf("Expected ErrMessageTooBig instead of %+v", err)
+		}
+	}
+}
+
+func TestLimitedProtocolReset(t *testing.T) {
+	limits := ReaderLimits{MaxStringLength: 4, MaxTotalBytes: 64}
+	r := NewLimitedProtocolBuilder(BinaryProtocol, limits).NewProtocolReader(&bytes.Buffer{})
+	rr, ok := r.(ResettableProtocolReader)
+	if !ok {
+		t.Fatal("Expected the limited reader to be resettable")
+	}
+	b := &bytes.Buffer{}
+	w := NewBinaryProtocolWriter(b, true)
+	w.WriteString("abc")
+	w.WriteString("too long")
+	rr.Reset(b)
+	if s, err := rr.ReadString(); err != nil || s != "abc" {
+		t.Fatalf("Expected 'abc' instead of '%s' (%+v)", s, err)
+	}
+	if _, err := rr.ReadString(); err != (ErrStringTooLong{8, 4}) {
+		t.Fatalf("Expected ErrStringTooLong instead of %+v", err)
+	}
+
+	// Pooled limited readers are reset rather than recreated
+	pb := NewPooledProtocolBuilder(NewLimitedProtocolBuilder(BinaryProtocol, limits))
+	pr := pb.NewProtocolReader(&bytes.Buffer{})
+	pb.PutProtocolReader(pr)
+	b.Reset()
+	w.WriteString("xyz")
+	if s, err := pb.NewProtocolReader(b).ReadString(); err != nil || s != "xyz" {
+		t.Fatalf("Expected 'xyz' instead of '%s' (%+v)", s, err)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import "context"
+
+type outgoingMetadataKey struct{}
+type incomingMetadataKey struct{}
+
+// WithOutgoingMetadata returns a copy of ctx with key/value metadata (e.g.
+// a caller name or auth token) that ContextClient sends with calls made
+// using it, as does a codec from NewInterceptedClientCodec when it's passed
+// to next by an interceptor. The values are added to any already in ctx. Metadata is only
+// sent over transports that implement HeaderReadWriter such as
+// HeaderTransport and TTwitterTransport.
+func WithOutgoingMetadata(ctx context.Context, md map[string]string) context.Context {
+	out := make(map[string]string)
+	for k, v := range OutgoingMetadata(ctx) {
+		out[k] = v
+	}
+	for k, v := range md {
+		out[k] = v
+	}
+	return context.WithValue(ctx, outgoingMetadataKey{}, out)
+}
+
+// OutgoingMetadata returns the metadata set on ctx by WithOutgoingMetadata.
+// The returned map must not be modified.
+func OutgoingMetadata(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(outgoingMetadataKey{}).(map[string]string)
+	return md
+}
+
+// IncomingMetadata returns the metadata received with the request being
+// handled by a Server or by the interceptors of a codec from
+// NewInterceptedServerCodec. The returned map must not be modified. To forward
+// it with calls made while handling the request use
+// WithOutgoingMetadata(ctx, IncomingMetadata(ctx)).
+func IncomingMetadata(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(incomingMetadataKey{}).(map[string]string)
+	return md
+}
+
+func withIncomingMetadata(ctx context.Context, md map[string]string) context.Context {
+	return context.WithValue(ctx, incomingMetadataKey{}, md)
+}
@@ -0,0 +1,259 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/rpc"
+	"testing"
+
+	gentest "github.com/samuel/go-thrift/testfiles/generator/withFlags/go.context"
+)
+
+// startMetadataServer starts a server that sends the incoming "caller"
+// metadata of every request on the returned channel.
+func startMetadataServer(t *testing.T) (*Server, string, chan string) {
+	callers := make(chan string, 10)
+	s := NewServer(gentest.NewStoreProcessor(newTestStore()), nil)
+	s.Use(func(ctx context.Context, method string, seq int32, req interface{}, next ServerHandler) (interface{}, error) {
+		callers <- IncomingMetadata(ctx)["caller"]
+		return next(ctx, method, seq, req)
+	})
+	ln, addr := listenTCP()
+	go s.Serve(ln)
+	return s, addr, callers
+}
+
+func testMetadata(t *testing.T, name string, c *ContextClient, callers chan string) {
+	client := &gentest.StoreClient{Client: c}
+	ctx := WithOutgoingMetadata(context.Background(), map[string]string{"caller": "svc-a"})
+	if err := client.Ping(ctx); err != nil {
+		t.Fatalf("%s: Ping returned error: %+v", name, err)
+	}
+	if caller := <-callers; caller != "svc-a" {
+		t.Fatalf("%s: expected caller svc-a instead of '%s'", name, caller)
+	}
+	// Metadata isn't sent with later calls
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("%s: Ping returned error: %+v", name, err)
+	}
+	if caller := <-callers; caller != "" {
+		t.Fatalf("%s: expected no caller instead of '%s'", name, caller)
+	}
+}
+
+func TestMetadataHeaderTransport(t *testing.T) {
+	s, addr, callers := startMetadataServer(t)
+	defer s.Close()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewContextClient(NewHeaderTransport(conn, HeaderProtocolCompact, 0), conn)
+	defer c.Close()
+	testMetadata(t, "header", c, callers)
+}
+
+func TestMetadataTTwitter(t *testing.T) {
+	s, addr, callers := startMetadataServer(t)
+	defer s.Close()
+	for _, framed := range []bool{false, true} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rwc io.ReadWriteCloser = conn
+		if framed {
+			rwc = NewFramedReadWriteCloser(conn, 0)
+		}
+		tt, err := UpgradeTTwitter(NewTransport(rwc, BinaryProtocol))
+		if err != nil {
+			t.Fatalf("UpgradeTTwitter returned error: %+v", err)
+		}
+		c := NewContextClient(tt, conn)
+		testMetadata(t, "ttwitter", c, callers)
+		c.Close()
+	}
+}
+
+// metadataStore returns the incoming metadata for key from Get.
+type metadataStore struct {
+	*testStore
+}
+
+func (s *metadataStore) Get(ctx context.Context, key string) (string, error) {
+	v, ok := IncomingMetadata(ctx)[key]
+	if !ok {
+		return "", &gentest.NotFound{Key: key}
+	}
+	return v, nil
+}
+
+func TestMetadataHandler(t *testing.T) {
+	s, addr := startNativeServer(t, &metadataStore{newTestStore()})
+	defer s.Close()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewContextClient(NewHeaderTransport(conn, HeaderProtocolBinary, 0), conn)
+	defer c.Close()
+	client := &gentest.StoreClient{Client: c}
+
+	ctx := WithOutgoingMetadata(context.Background(), map[string]string{"caller": "svc-a"})
+	if v, err := client.Get(ctx, "caller"); err != nil {
+		t.Fatalf("Get returned error: %+v", err)
+	} else if v != "svc-a" {
+		t.Fatalf("Expected svc-a instead of '%s'", v)
+	}
+	if _, err := client.Get(context.Background(), "caller"); err == nil {
+		t.Fatal("Expected NotFound without metadata")
+	}
+}
+
+func TestUpgradeTTwitterUnsupported(t *testing.T) {
+	cconn, sconn := tcpPipe(t)
+	go func() {
+		// Reply like a server that doesn't know the upgrade method
+		st := NewTransport(NewFramedReadWriteCloser(sconn, 0), BinaryProtocol)
+		name, _, seq, err := st.ReadMessageBegin()
+		if err != nil {
+			return
+		}
+		if err := SkipValue(st, TypeStruct); err != nil {
+			return
+		}
+		if err := st.ReadMessageEnd(); err != nil {
+			return
+		}
+		if err := writeMessage(st, name, MessageTypeException, seq, &ApplicationException{"unknown method", ExceptionUnknownMethod}); err != nil {
+			return
+		}
+		srv := rpc.NewServer()
+		srv.RegisterName("Thrift", new(TestService))
+		srv.ServeCodec(NewServerCodec(st))
+	}()
+
+	tr := NewTransport(NewFramedReadWriteCloser(cconn, 0), BinaryProtocol)
+	if _, err := UpgradeTTwitter(tr); err == nil {
+		t.Fatal("Expected an error")
+	} else if e, ok := err.(*ApplicationException); !ok || e.Type != ExceptionUnknownMethod {
+		t.Fatalf("Expected an unknown method exception instead of %+v", err)
+	}
+
+	// The connection can still be used without upgrading
+	c := NewClient(tr, false)
+	defer c.Close()
+	res := &TestResponse{}
+	if err := c.Call("Success", &TestRequest{123}, res); err != nil {
+		t.Fatal(err)
+	} else if res.Value != 123 {
+		t.Fatalf("Expected 123 instead of %d", res.Value)
+	}
+}
+
+func TestMetadataCodecs(t *testing.T) {
+	cases := []struct {
+		name      string
+		transport func(conn net.Conn, server bool) (Transport, error)
+	}{
+		{"header", func(conn net.Conn, server bool) (Transport, error) {
+			return NewHeaderTransport(conn, HeaderProtocolBinary, 0), nil
+		}},
+		{"ttwitter", func(conn net.Conn, server bool) (Transport, error) {
+			t := NewTransport(conn, BinaryProtocol)
+			if server {
+				// The server codec accepts the upgrade
+				return t, nil
+			}
+			return UpgradeTTwitter(t)
+		}},
+	}
+	for _, c := range cases {
+		srv := rpc.NewServer()
+		if err := srv.RegisterName("Thrift", new(TestService)); err != nil {
+			t.Fatal(err)
+		}
+		callers := make(chan string, 10)
+		cconn, sconn := tcpPipe(t)
+		st, _ := c.transport(sconn, true)
+		go srv.ServeCodec(NewInterceptedServerCodec(NewServerCodec(st),
+			func(ctx context.Context, method string, seq int32, req interface{}, next ServerHandler) (interface{}, error) {
+				callers <- IncomingMetadata(ctx)["caller"]
+				return next(ctx, method, seq, req)
+			},
+		))
+		ct, err := c.transport(cconn, false)
+		if err != nil {
+			t.Fatalf("%s: %+v", c.name, err)
+		}
+		client := rpc.NewClientWithCodec(NewInterceptedClientCodec(NewClientCodec(ct, false),
+			func(ctx context.Context, method string, seq int32, req, res interface{}, next ClientInvoker) error {
+				if req.(*TestRequest).Value == 1 {
+					ctx = WithOutgoingMetadata(ctx, map[string]string{"caller": "svc-a"})
+				}
+				return next(ctx, method, seq, req, res)
+			},
+		))
+		for _, v := range []int32{1, 2} {
+			res := &TestResponse{}
+			if err := client.Call("Success", &TestRequest{v}, res); err != nil {
+				t.Fatalf("%s: Call returned error: %+v", c.name, err)
+			} else if res.Value != v {
+				t.Fatalf("%s: expected %d instead of %d", c.name, v, res.Value)
+			}
+		}
+		if caller := <-callers; caller != "svc-a" {
+			t.Fatalf("%s: expected caller svc-a instead of '%s'", c.name, caller)
+		}
+		// Metadata isn't sent with later calls
+		if caller := <-callers; caller != "" {
+			t.Fatalf("%s: expected no caller instead of '%s'", c.name, caller)
+		}
+		client.Close()
+	}
+}
+
+func TestTTwitterTransportHeaders(t *testing.T) {
+	buf := &ClosingBuffer{&bytes.Buffer{}}
+	tr := NewTransport(buf, BinaryProtocol)
+	// A request header without a trace
+	h := &TTwitterRequestHeader{Contexts: []*TTwitterRequestContext{{"caller", "svc-a"}}}
+	if err := EncodeStruct(tr, h); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeMessage(tr, "ping", MessageTypeCall, 1, &gentest.StorePingRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	st := newTTwitterTransport(tr, true)
+	done := make(chan struct{})
+	go func() {
+		// Handlers set headers while messages are read and written
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			st.SetWriteHeader("k", "v")
+		}
+	}()
+	if _, _, _, err := st.ReadMessageBegin(); err != nil {
+		t.Fatal(err)
+	}
+	md := st.ReadHeaders()
+	if md["caller"] != "svc-a" {
+		t.Fatalf("Expected the caller context instead of %+v", md)
+	}
+	if _, ok := md[TraceIDKey]; ok {
+		t.Fatalf("Expected no span metadata without a trace ID instead of %+v", md)
+	}
+	for i := 0; i < 10; i++ {
+		if err := st.WriteMessageBegin("ping", MessageTypeReply, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
@@ -0,0 +1,376 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"fmt"
+	"net/rpc"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Metrics receives measurements of calls and frames. Implementations must
+// be safe for concurrent use.
+type Metrics interface {
+	// ObserveCall records a call to method that took d. server is true
+	// for requests handled by a server and false for calls made by a
+	// client. errClass is empty if the call succeeded and otherwise
+	// classifies the failure as returned by ErrorClass.
+	ObserveCall(server bool, method string, d time.Duration, errClass string)
+	// ObserveFrame records the size of a frame read (in is true) or
+	// written carrying a message for method. method is empty if the frame
+	// couldn't be attributed to a message.
+	ObserveFrame(method string, in bool, size int)
+}
+
+// ErrorClass classifies the outcome of a call for metrics given the error
+// and response struct it returned:
+//
+//	""                        success
+//	"exception.<Type>"        exception declared in the IDL (e.g. "exception.NotFound")
+//	"application.<Type>"      ApplicationException by type (e.g. "application.InternalError")
+//	"transport"               any other error (connection, protocol, or client)
+func ErrorClass(err error, res interface{}) string {
+	if err == nil {
+		if name := declaredException(res); name != "" {
+			return "exception." + name
+		}
+		return ""
+	}
+	if t, ok := exceptionType(err); ok {
+		return "application." + exceptionTypeName(t)
+	}
+	return "transport"
+}
+
+func exceptionTypeName(t int32) string {
+	switch t {
+	case ExceptionUnknownMethod:
+		return "UnknownMethod"
+	case ExceptionInvalidMessageType:
+		return "InvalidMessageType"
+	case ExceptionWrongMethodName:
+		return "WrongMethodName"
+	case ExceptionBadSequenceID:
+		return "BadSequenceID"
+	case ExceptionMissingResult:
+		return "MissingResult"
+	case ExceptionInternalError:
+		return "InternalError"
+	case ExceptionProtocolError:
+		return "ProtocolError"
+	}
+	return "Unknown"
+}
+
+// declaredException returns the type name of the exception set in a
+// response struct. The result is field 0 and exceptions have other IDs.
+func declaredException(res interface{}) string {
+	v := reflect.ValueOf(res)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return ""
+	}
+	v = v.Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("thrift")
+		if tag == "" {
+			continue
+		}
+		if id, _ := parseTag(tag); id == 0 {
+			continue
+		}
+		if f := v.Field(i); f.Kind() == reflect.Ptr && !f.IsNil() {
+			return f.Elem().Type().Name()
+		}
+	}
+	return ""
+}
+
+// MetricsClientInterceptor returns a client interceptor that records every
+// call in m. Use it with ContextClient.Use or NewInterceptedClient (e.g.
+// for a Pool).
+func MetricsClientInterceptor(m Metrics) ClientInterceptor {
+	return func(ctx context.Context, method string, seq int32, req, res interface{}, next ClientInvoker) error {
+		start := time.Now()
+		err := next(ctx, method, seq, req, res)
+		m.ObserveCall(false, method, time.Since(start), ErrorClass(err, res))
+		return err
+	}
+}
+
+// MetricsServerInterceptor returns a Server interceptor that records every
+// request in m.
+func MetricsServerInterceptor(m Metrics) ServerInterceptor {
+	return func(ctx context.Context, method string, seq int32, req interface{}, next ServerHandler) (interface{}, error) {
+		start := time.Now()
+		res, err := next(ctx, method, seq, req)
+		m.ObserveCall(true, method, time.Since(start), ErrorClass(err, res))
+		return res, err
+	}
+}
+
+// UnknownMethodName is the method name calls to methods that aren't
+// registered are recorded under by NewMetricsServerCodec so that requests
+// for arbitrary names don't add to the metrics.
+const UnknownMethodName = "unknown"
+
+type metricsServerCodec struct {
+	rpc.ServerCodec
+	metrics Metrics
+	seq     uint64 // sequence ID of the request being read
+
+	mu       sync.Mutex
+	requests map[uint64]metricsRequest
+}
+
+type metricsRequest struct {
+	method string
+	start  time.Time
+}
+
+// NewMetricsServerCodec wraps a net/rpc server codec to record every
+// request in m from when its header is read until its response is written.
+// Requests for methods that aren't registered with the net/rpc server are
+// recorded under UnknownMethodName.
+func NewMetricsServerCodec(codec rpc.ServerCodec, m Metrics) rpc.ServerCodec {
+	return &metricsServerCodec{
+		ServerCodec: codec,
+		metrics:     m,
+		requests:    make(map[uint64]metricsRequest),
+	}
+}
+
+func (c *metricsServerCodec) ReadRequestHeader(request *rpc.Request) error {
+	if err := c.ServerCodec.ReadRequestHeader(request); err != nil {
+		return err
+	}
+	c.seq = request.Seq
+	c.mu.Lock()
+	c.requests[request.Seq] = metricsRequest{request.ServiceMethod, time.Now()}
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *metricsServerCodec) ReadRequestBody(thriftStruct interface{}) error {
+	if thriftStruct == nil {
+		// net/rpc discards the body of requests for methods it can't find
+		c.mu.Lock()
+		if r, ok := c.requests[c.seq]; ok {
+			r.method = UnknownMethodName
+			c.requests[c.seq] = r
+		}
+		c.mu.Unlock()
+	}
+	return c.ServerCodec.ReadRequestBody(thriftStruct)
+}
+
+func (c *metricsServerCodec) ReadHeaders() map[string]string {
+	return readCodecHeaders(c.ServerCodec)
+}
+
+func (c *metricsServerCodec) SetWriteHeader(key, value string) {
+	setCodecHeader(c.ServerCodec, key, value)
+}
+
+func (c *metricsServerCodec) WriteResponse(response *rpc.Response, thriftStruct interface{}) error {
+	c.mu.Lock()
+	r, ok := c.requests[response.Seq]
+	delete(c.requests, response.Seq)
+	c.mu.Unlock()
+	err := c.ServerCodec.WriteResponse(response, thriftStruct)
+	if ok {
+		var class string
+		switch {
+		case err != nil:
+			// The response didn't make it to the client
+			class = "transport"
+		case response.Error == "":
+			class = ErrorClass(nil, thriftStruct)
+		default:
+			// Classify the exception written by serverCodec
+			ex, isEx := thriftStruct.(*ApplicationException)
+			if !isEx {
+				ex = serverException(response.Error)
+			}
+			class = ErrorClass(ex, nil)
+		}
+		c.metrics.ObserveCall(true, r.method, time.Since(r.start), class)
+	}
+	return err
+}
+
+// Bucket upper bounds of the histograms kept by ExpvarMetrics.
+var (
+	LatencyBuckets   = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}       // milliseconds
+	FrameSizeBuckets = []float64{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20} // bytes
+)
+
+// histogram is an expvar.Var counting observations in buckets. Each bucket
+// counts the observations greater than the previous bound and at most its
+// own. Observations above the last bound are counted in "+Inf".
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)+1),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.mu.Lock()
+	h.counts[i]++
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+func (h *histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, `{"count": %d, "sum": %s, "buckets": {`, h.count, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	for i, c := range h.counts {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		bound := "+Inf"
+		if i < len(h.bounds) {
+			bound = strconv.FormatFloat(h.bounds[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(b, `"%s": %d`, bound, c)
+	}
+	b.WriteString("}}")
+	return b.String()
+}
+
+// ExpvarMetrics is a Metrics that publishes a map with expvar. It's laid
+// out as
+//
+//	{
+//	  "client": {"<method>": {"calls": n, "errors": {"<class>": n}, "latency_ms": histogram}},
+//	  "server": {...},
+//	  "frames": {"<method>": {"in": histogram, "out": histogram}}
+//	}
+//
+// where histograms have a count, sum, and per-bucket counts (see
+// LatencyBuckets and FrameSizeBuckets). The sums of the frame histograms
+// are the bytes read and written for the method. Frames that couldn't be
+// attributed to a method are under "".
+type ExpvarMetrics struct {
+	root *expvar.Map
+
+	mu     sync.Mutex
+	client map[string]*methodMetrics
+	server map[string]*methodMetrics
+	frames map[string]*frameMetrics
+}
+
+type methodMetrics struct {
+	calls   *expvar.Int
+	errors  *expvar.Map
+	latency *histogram
+}
+
+type frameMetrics struct {
+	in  *histogram
+	out *histogram
+}
+
+// NewExpvarMetrics returns an ExpvarMetrics published as name. As with
+// expvar.NewMap, name must not already be in use.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m := &ExpvarMetrics{
+		root:   expvar.NewMap(name),
+		client: make(map[string]*methodMetrics),
+		server: make(map[string]*methodMetrics),
+		frames: make(map[string]*frameMetrics),
+	}
+	m.root.Set("client", new(expvar.Map).Init())
+	m.root.Set("server", new(expvar.Map).Init())
+	m.root.Set("frames", new(expvar.Map).Init())
+	return m
+}
+
+func (m *ExpvarMetrics) method(server bool, method string) *methodMetrics {
+	methods, name := m.client, "client"
+	if server {
+		methods, name = m.server, "server"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mm := methods[method]
+	if mm == nil {
+		mm = &methodMetrics{
+			calls:   new(expvar.Int),
+			errors:  new(expvar.Map).Init(),
+			latency: newHistogram(LatencyBuckets),
+		}
+		v := new(expvar.Map).Init()
+		v.Set("calls", mm.calls)
+		v.Set("errors", mm.errors)
+		v.Set("latency_ms", mm.latency)
+		m.root.Get(name).(*expvar.Map).Set(method, v)
+		methods[method] = mm
+	}
+	return mm
+}
+
+func (m *ExpvarMetrics) methodFrames(method string) *frameMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fm := m.frames[method]
+	if fm == nil {
+		fm = &frameMetrics{
+			in:  newHistogram(FrameSizeBuckets),
+			out: newHistogram(FrameSizeBuckets),
+		}
+		v := new(expvar.Map).Init()
+		v.Set("in", fm.in)
+		v.Set("out", fm.out)
+		m.root.Get("frames").(*expvar.Map).Set(method, v)
+		m.frames[method] = fm
+	}
+	return fm
+}
+
+func (m *ExpvarMetrics) ObserveCall(server bool, method string, d time.Duration, errClass string) {
+	mm := m.method(server, method)
+	mm.calls.Add(1)
+	mm.latency.observe(float64(d) / float64(time.Millisecond))
+	if errClass != "" {
+		mm.errors.Add(errClass, 1)
+	}
+}
+
+func (m *ExpvarMetrics) ObserveFrame(method string, in bool, size int) {
+	fm := m.methodFrames(method)
+	if in {
+		fm.in.observe(float64(size))
+	} else {
+		fm.out.observe(float64(size))
+	}
+}
+
+// Var returns the published map.
+func (m *ExpvarMetrics) Var() *expvar.Map {
+	return m.root
+}
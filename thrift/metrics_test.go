@@ -0,0 +1,242 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"sync/atomic"
+	"testing"
+
+	gentest "github.com/samuel/go-thrift/testfiles/generator/withFlags/go.context"
+)
+
+type expvarMethod struct {
+	Calls   int64            `json:"calls"`
+	Errors  map[string]int64 `json:"errors"`
+	Latency struct {
+		Count int64 `json:"count"`
+	} `json:"latency_ms"`
+}
+
+type expvarHistogram struct {
+	Count   int64            `json:"count"`
+	Sum     float64          `json:"sum"`
+	Buckets map[string]int64 `json:"buckets"`
+}
+
+type expvarFrames struct {
+	In  expvarHistogram `json:"in"`
+	Out expvarHistogram `json:"out"`
+}
+
+type expvarMetrics struct {
+	Client map[string]expvarMethod `json:"client"`
+	Server map[string]expvarMethod `json:"server"`
+	Frames map[string]expvarFrames `json:"frames"`
+}
+
+var expvarMetricsCount int32
+
+// newTestExpvarMetrics returns an ExpvarMetrics with a name that isn't in
+// use yet since tests may be run more than once.
+func newTestExpvarMetrics() *ExpvarMetrics {
+	n := atomic.AddInt32(&expvarMetricsCount, 1)
+	return NewExpvarMetrics(fmt.Sprintf("thrift_test_metrics_%d", n))
+}
+
+func readExpvarMetrics(t *testing.T, m *ExpvarMetrics) expvarMetrics {
+	var v expvarMetrics
+	if err := json.Unmarshal([]byte(m.Var().String()), &v); err != nil {
+		t.Fatalf("Invalid expvar JSON: %+v", err)
+	}
+	return v
+}
+
+func TestErrorClass(t *testing.T) {
+	cases := []struct {
+		err   error
+		res   interface{}
+		class string
+	}{
+		{nil, nil, ""},
+		{nil, &gentest.StoreGetResponse{}, ""},
+		{nil, &gentest.StoreGetResponse{Nf: &gentest.NotFound{Key: "k"}}, "exception.NotFound"},
+		{&ApplicationException{"oops", ExceptionInternalError}, nil, "application.InternalError"},
+		{&ApplicationException{"oops", 100}, nil, "application.Unknown"},
+		{rpc.ServerError("Unknown Method: get"), nil, "application.UnknownMethod"},
+		{rpc.ServerError("Protocol Error: Internal Error: x"), nil, "application.ProtocolError"},
+		{rpc.ServerError("oops"), nil, "application.Unknown"},
+		{io.EOF, nil, "transport"},
+	}
+	for i, c := range cases {
+		if class := ErrorClass(c.err, c.res); class != c.class {
+			t.Errorf("%d. expected '%s' instead of '%s'", i, c.class, class)
+		}
+	}
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	m := newTestExpvarMetrics()
+	s := NewServer(gentest.NewStoreProcessor(newTestStore()), nil)
+	s.Use(MetricsServerInterceptor(m))
+	ln, addr := listenTCP()
+	go s.Serve(ln)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	framed := NewFramedReadWriteCloser(conn, 0)
+	framed.SetMetrics(m)
+	c := NewContextClient(NewTransport(framed, BinaryProtocol), conn)
+	defer c.Close()
+	c.Use(MetricsClientInterceptor(m))
+	client := &gentest.StoreClient{Client: c}
+
+	ctx := context.Background()
+	if err := client.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	client.Get(ctx, "missing")
+	client.Get(ctx, "panic")
+	c.Call(ctx, "unknown", &gentest.StorePingRequest{}, &gentest.StorePingResponse{})
+
+	v := readExpvarMetrics(t, m)
+	if ping := v.Client["ping"]; ping.Calls != 1 || ping.Latency.Count != 1 || len(ping.Errors) != 0 {
+		t.Fatalf("Unexpected client ping metrics %+v", ping)
+	}
+	for _, get := range []expvarMethod{v.Client["get"], v.Server["get"]} {
+		if get.Calls != 2 || get.Errors["exception.NotFound"] != 1 || get.Errors["application.InternalError"] != 1 {
+			t.Fatalf("Unexpected get metrics %+v", get)
+		}
+	}
+	if unknown := v.Client["unknown"]; unknown.Errors["application.UnknownMethod"] != 1 {
+		t.Fatalf("Unexpected unknown method metrics %+v", unknown)
+	}
+	if ping := v.Frames["ping"]; ping.Out.Count != 1 || ping.In.Count != 1 || ping.Out.Sum == 0 || ping.Out.Buckets["64"] != 1 {
+		t.Fatalf("Unexpected ping frame metrics %+v", ping)
+	}
+	if get := v.Frames["get"]; get.Out.Count != 2 || get.In.Count != 2 {
+		t.Fatalf("Unexpected get frame metrics %+v", get)
+	}
+	if len(v.Frames) != 3 {
+		t.Fatalf("Expected frames for 3 methods instead of %+v", v.Frames)
+	}
+}
+
+func TestHeaderTransportFrameMetrics(t *testing.T) {
+	m := newTestExpvarMetrics()
+	buf := &ClosingBuffer{&bytes.Buffer{}}
+	ht := NewHeaderTransport(buf, HeaderProtocolBinary, 0)
+	ht.SetMetrics(m)
+	if err := ht.WriteMessageBegin("ping", MessageTypeCall, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ht.WriteMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ht.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	size := buf.Len() - 4
+	if name, _, _, err := ht.ReadMessageBegin(); err != nil || name != "ping" {
+		t.Fatalf("ReadMessageBegin returned %q, %+v", name, err)
+	}
+	v := readExpvarMetrics(t, m)
+	if ping := v.Frames["ping"]; ping.Out.Count != 1 || ping.In.Count != 1 || ping.Out.Sum != float64(size) || ping.In.Sum != float64(size) {
+		t.Fatalf("Unexpected ping frame metrics %+v", ping)
+	}
+}
+
+type metricsTestService struct {
+	TestService
+}
+
+func (s *metricsTestService) Protocol(req *TestRequest, res *TestResponse) error {
+	return &ApplicationException{"bad request", ExceptionProtocolError}
+}
+
+func TestMetricsServerCodec(t *testing.T) {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Thrift", new(metricsTestService)); err != nil {
+		t.Fatal(err)
+	}
+	m := newTestExpvarMetrics()
+	cconn, sconn := tcpPipe(t)
+	go srv.ServeCodec(NewMetricsServerCodec(NewServerCodec(NewTransport(sconn, BinaryProtocol)), m))
+	rc := NewClient(NewTransport(cconn, BinaryProtocol), false)
+	defer rc.Close()
+	client := NewInterceptedClient(rc, MetricsClientInterceptor(m))
+
+	res := &TestResponse{}
+	if err := client.Call("Success", &TestRequest{1}, res); err != nil {
+		t.Fatal(err)
+	}
+	client.Call("Fail", &TestRequest{1}, res)
+	client.Call("Missing", &TestRequest{1}, res)
+	client.Call("Missing2", &TestRequest{1}, res)
+	if err := client.Call("Protocol", &TestRequest{1}, res); err == nil || err.Error() != "Protocol Error: bad request" {
+		t.Fatalf("Expected the protocol error to be passed along instead of %+v", err)
+	}
+
+	v := readExpvarMetrics(t, m)
+	if s := v.Server["Thrift.Success"]; s.Calls != 1 || len(s.Errors) != 0 {
+		t.Fatalf("Unexpected server Success metrics %+v", s)
+	}
+	if f := v.Server["Thrift.Fail"]; f.Errors["application.InternalError"] != 1 {
+		t.Fatalf("Unexpected server Fail metrics %+v", f)
+	}
+	if f := v.Client["Fail"]; f.Errors["application.InternalError"] != 1 {
+		t.Fatalf("Unexpected client Fail metrics %+v", f)
+	}
+	// Methods that aren't registered share one entry
+	if u := v.Server[UnknownMethodName]; u.Calls != 2 || u.Errors["application.UnknownMethod"] != 2 {
+		t.Fatalf("Unexpected server unknown method metrics %+v", u)
+	}
+	if _, ok := v.Server["Thrift.Missing"]; ok {
+		t.Fatalf("Expected no entry for an unregistered method in %+v", v.Server)
+	}
+	for _, p := range []expvarMethod{v.Server["Thrift.Protocol"], v.Client["Protocol"]} {
+		if p.Errors["application.ProtocolError"] != 1 {
+			t.Fatalf("Unexpected Protocol metrics %+v", p)
+		}
+	}
+}
+
+type failingServerCodec struct {
+	rpc.ServerCodec
+}
+
+func (c failingServerCodec) ReadRequestHeader(request *rpc.Request) error {
+	request.ServiceMethod = "Thrift.Success"
+	request.Seq = 1
+	return nil
+}
+
+func (c failingServerCodec) WriteResponse(response *rpc.Response, thriftStruct interface{}) error {
+	return io.ErrClosedPipe
+}
+
+func TestMetricsServerCodecWriteError(t *testing.T) {
+	m := newTestExpvarMetrics()
+	c := NewMetricsServerCodec(failingServerCodec{}, m)
+	req := &rpc.Request{}
+	if err := c.ReadRequestHeader(req); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteResponse(&rpc.Response{ServiceMethod: req.ServiceMethod, Seq: req.Seq}, &TestResponse{}); err != io.ErrClosedPipe {
+		t.Fatalf("Expected the write error instead of %+v", err)
+	}
+	if s := readExpvarMetrics(t, m).Server["Thrift.Success"]; s.Calls != 1 || s.Errors["transport"] != 1 {
+		t.Fatalf("Unexpected metrics %+v", s)
+	}
+}
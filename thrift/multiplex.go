@@ -0,0 +1,118 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MultiplexedSeparator separates the service name from the method name in
+// requests sent to a multiplexed server (e.g. "Calculator:add"). It's
+// compatible with TMultiplexedProtocol in other Thrift libraries.
+const MultiplexedSeparator = ":"
+
+type multiplexedTransport struct {
+	Transport
+	serviceName string
+}
+
+// NewMultiplexedTransport returns a Transport that prefixes the method name
+// of requests with serviceName and MultiplexedSeparator so they're routed
+// to that service by a multiplexed server.
+func NewMultiplexedTransport(t Transport, serviceName string) Transport {
+	return &multiplexedTransport{
+		Transport:   t,
+		serviceName: serviceName,
+	}
+}
+
+func (t *multiplexedTransport) WriteMessageBegin(name string, messageType byte, seqid int32) error {
+	if messageType == MessageTypeCall || messageType == MessageTypeOneway {
+		name = t.serviceName + MultiplexedSeparator + name
+	}
+	return t.Transport.WriteMessageBegin(name, messageType, seqid)
+}
+
+// splitMultiplexedName splits a method name sent by a multiplexing client
+// into the service and method. service is empty if name isn't prefixed.
+func splitMultiplexedName(name string) (service, method string) {
+	if i := strings.Index(name, MultiplexedSeparator); i >= 0 {
+		return name[:i], name[i+len(MultiplexedSeparator):]
+	}
+	return "", name
+}
+
+// replyNamer is implemented by processors that reply with a different
+// method name than was in the request.
+type replyNamer interface {
+	replyName(method string) string
+}
+
+// MultiplexedProcessor is a Processor that routes requests to the processor
+// registered for the service named in the method's prefix. It's compatible
+// with TMultiplexedProcessor in other Thrift libraries.
+type MultiplexedProcessor struct {
+	mu         sync.RWMutex
+	processors map[string]Processor
+	def        Processor
+}
+
+// NewMultiplexedProcessor returns an empty MultiplexedProcessor.
+func NewMultiplexedProcessor() *MultiplexedProcessor {
+	return &MultiplexedProcessor{
+		processors: make(map[string]Processor),
+	}
+}
+
+// RegisterProcessor routes requests for serviceName to p.
+func (m *MultiplexedProcessor) RegisterProcessor(serviceName string, p Processor) {
+	m.mu.Lock()
+	m.processors[serviceName] = p
+	m.mu.Unlock()
+}
+
+// RegisterDefault routes requests without a service prefix to p. This
+// allows clients that don't multiplex to keep working.
+func (m *MultiplexedProcessor) RegisterDefault(p Processor) {
+	m.mu.Lock()
+	m.def = p
+	m.mu.Unlock()
+}
+
+func (m *MultiplexedProcessor) processor(name string) (Processor, string) {
+	service, method := splitMultiplexedName(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if service == "" {
+		return m.def, method
+	}
+	return m.processors[service], method
+}
+
+func (m *MultiplexedProcessor) NewRequest(method string) interface{} {
+	p, method := m.processor(method)
+	if p == nil {
+		return nil
+	}
+	return p.NewRequest(method)
+}
+
+func (m *MultiplexedProcessor) Process(ctx context.Context, method string, req interface{}) (interface{}, error) {
+	p, name := m.processor(method)
+	if p == nil {
+		return nil, fmt.Errorf("unknown method %s", method)
+	}
+	return p.Process(ctx, name, req)
+}
+
+// replyName strips the service prefix as replies from other Thrift
+// libraries don't include it.
+func (m *MultiplexedProcessor) replyName(method string) string {
+	_, method = splitMultiplexedName(method)
+	return method
+}
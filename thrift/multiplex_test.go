@@ -0,0 +1,117 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"testing"
+
+	gentest "github.com/samuel/go-thrift/testfiles/generator/withFlags/go.context"
+)
+
+// replyNameTransport records the name of the last message read.
+type replyNameTransport struct {
+	Transport
+	name string
+}
+
+func (t *replyNameTransport) ReadMessageBegin() (string, byte, int32, error) {
+	name, mtype, seq, err := t.Transport.ReadMessageBegin()
+	t.name = name
+	return name, mtype, seq, err
+}
+
+func TestMultiplexedServerCodec(t *testing.T) {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Calc", new(TestService)); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterName("Default", new(TestService)); err != nil {
+		t.Fatal(err)
+	}
+	dial := func() Transport {
+		cconn, sconn := net.Pipe()
+		go srv.ServeCodec(NewMultiplexedServerCodec(NewTransport(sconn, BinaryProtocol), "Default"))
+		return NewTransport(cconn, BinaryProtocol)
+	}
+	tr := &replyNameTransport{Transport: dial()}
+	client := NewClient(NewMultiplexedTransport(tr, "Calc"), false)
+	defer client.Close()
+
+	res := &TestResponse{}
+	if err := client.Call("success", &TestRequest{123}, res); err != nil {
+		t.Fatalf("Call returned error: %+v", err)
+	} else if res.Value != 123 {
+		t.Fatalf("Expected 123 instead of %d", res.Value)
+	}
+	if tr.name != "success" {
+		t.Fatalf("Expected reply name 'success' instead of '%s'", tr.name)
+	}
+
+	// Unprefixed requests go to the default service
+	client2 := NewClient(dial(), false)
+	defer client2.Close()
+	if err := client2.Call("success", &TestRequest{456}, res); err != nil {
+		t.Fatalf("Call returned error: %+v", err)
+	} else if res.Value != 456 {
+		t.Fatalf("Expected 456 instead of %d", res.Value)
+	}
+}
+
+func TestMultiplexedProcessor(t *testing.T) {
+	store := newTestStore()
+	mp := NewMultiplexedProcessor()
+	mp.RegisterProcessor("Store", gentest.NewStoreProcessor(store))
+	ln, addr := listenTCP()
+	s := NewServer(mp, nil)
+	go s.Serve(ln)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &replyNameTransport{Transport: NewTransport(NewFramedReadWriteCloser(conn, 0), BinaryProtocol)}
+	c := NewContextClient(NewMultiplexedTransport(tr, "Store"), conn)
+	defer c.Close()
+	client := &gentest.StoreClient{Client: c}
+	ctx := context.Background()
+
+	if err := client.Put(ctx, "key", "value"); err != nil {
+		t.Fatalf("Put returned error: %+v", err)
+	}
+	<-store.put
+	if v, err := client.Get(ctx, "key"); err != nil {
+		t.Fatalf("Get returned error: %+v", err)
+	} else if v != "value" {
+		t.Fatalf("Expected 'value' instead of '%s'", v)
+	}
+	if tr.name != "get" {
+		t.Fatalf("Expected reply name 'get' instead of '%s'", tr.name)
+	}
+
+	// Unregistered service
+	c2, err := DialContext(ctx, "tcp", addr, true, BinaryProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	if err := c2.Call(ctx, "Other:ping", &gentest.StorePingRequest{}, &gentest.StorePingResponse{}); err == nil {
+		t.Fatal("Expected an error")
+	} else if e, ok := err.(*ApplicationException); !ok || e.Type != ExceptionUnknownMethod {
+		t.Fatalf("Expected an unknown method ApplicationException instead of %+v", err)
+	}
+
+	// Requests without a prefix use the default processor
+	if err := c2.Call(ctx, "ping", &gentest.StorePingRequest{}, &gentest.StorePingResponse{}); err == nil {
+		t.Fatal("Expected an error without a default processor")
+	}
+	mp.RegisterDefault(gentest.NewStoreProcessor(store))
+	if err := c2.Call(ctx, "ping", &gentest.StorePingRequest{}, &gentest.StorePingResponse{}); err != nil {
+		t.Fatalf("ping returned error: %+v", err)
+	}
+}
@@ -0,0 +1,320 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"errors"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is the error returned by calls made on a pool after it has
+// been closed.
+var ErrPoolClosed = errors.New("thrift.pool: pool closed")
+
+// DefaultPoolCheckInterval is how often a pool closes expired idle
+// connections, runs health checks, and opens connections to reach MinIdle
+// when PoolConfig.CheckInterval is not set.
+const DefaultPoolCheckInterval = 30 * time.Second
+
+// PoolConfig configures a Pool. The zero value is a pool with no limit on
+// the number of connections that keeps all idle connections forever.
+type PoolConfig struct {
+	// MaxOpen is the maximum number of open connections. Calls wait for a
+	// connection to be returned once it's reached. 0 means unlimited.
+	MaxOpen int
+	// MinIdle is the number of idle connections the pool tries to keep
+	// open in the background. It's limited to MaxIdle.
+	MinIdle int
+	// MaxIdle is the maximum number of idle connections to keep.
+	// 0 means unlimited.
+	MaxIdle int
+	// MaxLifetime is the maximum amount of time a connection is used
+	// after it's opened. 0 means unlimited.
+	MaxLifetime time.Duration
+	// IdleTimeout is the amount of time after which an idle connection is
+	// closed. 0 means unlimited.
+	IdleTimeout time.Duration
+	// HealthCheck if not nil is called for idle connections every
+	// CheckInterval. Connections for which it returns an error are closed.
+	HealthCheck func(*rpc.Client) error
+	// CheckInterval is how often idle connections are checked. It
+	// defaults to DefaultPoolCheckInterval.
+	CheckInterval time.Duration
+}
+
+// PoolStats are the counts of connections in a pool.
+type PoolStats struct {
+	Open  int // idle and in use
+	Idle  int
+	InUse int
+}
+
+// Pool is a pool of connections to a Thrift RPC server that implements the
+// RPCClient interface of generated clients. Each call uses its own
+// connection so a slow call doesn't block others. Connections for which a
+// call returns an error other than an exception sent by the server (e.g. a
+// transport error or ErrFrameTooBig) are closed rather than reused.
+type Pool struct {
+	dial func() (*rpc.Client, error)
+	cfg  PoolConfig
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	idle   []*poolConn // most recently used last
+	open   int
+	closed bool
+	done   chan struct{}
+}
+
+type poolConn struct {
+	client   *rpc.Client
+	created  time.Time
+	returned time.Time
+	used     bool // a call has been made on the connection
+}
+
+// NewPool returns a Pool that opens connections using dial
+// (e.g. a closure calling Dial).
+func NewPool(dial func() (*rpc.Client, error), cfg PoolConfig) *Pool {
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = DefaultPoolCheckInterval
+	}
+	if cfg.MaxIdle > 0 && cfg.MinIdle > cfg.MaxIdle {
+		cfg.MinIdle = cfg.MaxIdle
+	}
+	p := &Pool{
+		dial: dial,
+		cfg:  cfg,
+		done: make(chan struct{}),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	if cfg.MinIdle > 0 || cfg.MaxLifetime > 0 || cfg.IdleTimeout > 0 || cfg.HealthCheck != nil {
+		go p.maintain()
+	}
+	return p
+}
+
+// Call makes a request on a connection from the pool.
+func (p *Pool) Call(method string, request interface{}, response interface{}) error {
+	_, err := p.call(method, request, response)
+	return err
+}
+
+// call is Call that also returns true if the call was the first one made
+// on its connection.
+func (p *Pool) call(method string, request interface{}, response interface{}) (bool, error) {
+	c, err := p.get()
+	if err != nil {
+		return false, err
+	}
+	first := !c.used
+	c.used = true
+	err = c.client.Call(method, request, response)
+	p.put(c, err)
+	return first, err
+}
+
+// Stats returns the current counts of connections.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PoolStats{
+		Open:  p.open,
+		Idle:  len(p.idle),
+		InUse: p.open - len(p.idle),
+	}
+}
+
+// Close closes all idle connections. Connections in use are closed when
+// their call completes and calls waiting for a connection return
+// ErrPoolClosed.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.open -= len(idle)
+	close(p.done)
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	for _, c := range idle {
+		c.client.Close()
+	}
+	return nil
+}
+
+func (p *Pool) get() (*poolConn, error) {
+	p.mu.Lock()
+	for {
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if n := len(p.idle); n > 0 {
+			c := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			if p.expired(c, time.Now()) {
+				p.mu.Unlock()
+				p.discard(c)
+				p.mu.Lock()
+				continue
+			}
+			p.mu.Unlock()
+			return c, nil
+		}
+		if p.cfg.MaxOpen <= 0 || p.open < p.cfg.MaxOpen {
+			break
+		}
+		p.cond.Wait()
+	}
+	p.open++
+	p.mu.Unlock()
+
+	client, err := p.dial()
+	if err != nil {
+		p.mu.Lock()
+		p.open--
+		p.cond.Signal()
+		p.mu.Unlock()
+		return nil, err
+	}
+	return &poolConn{client: client, created: time.Now()}, nil
+}
+
+// put returns a connection to the pool after a call returned err.
+func (p *Pool) put(c *poolConn, err error) {
+	if isConnError(err) {
+		p.discard(c)
+		return
+	}
+	c.returned = time.Now()
+	p.release(c)
+}
+
+// release makes c idle unless it's expired or there are already MaxIdle
+// idle connections. It returns false if c was closed instead.
+func (p *Pool) release(c *poolConn) bool {
+	p.mu.Lock()
+	if p.closed || p.expired(c, time.Now()) || (p.cfg.MaxIdle > 0 && len(p.idle) >= p.cfg.MaxIdle) {
+		p.mu.Unlock()
+		p.discard(c)
+		return false
+	}
+	p.idle = append(p.idle, c)
+	p.cond.Signal()
+	p.mu.Unlock()
+	return true
+}
+
+// discard closes a connection that's not idle.
+func (p *Pool) discard(c *poolConn) {
+	p.mu.Lock()
+	p.open--
+	p.cond.Signal()
+	p.mu.Unlock()
+	c.client.Close()
+}
+
+// expired returns true if c has reached its lifetime or idle timeout.
+// p.mu must be held.
+func (p *Pool) expired(c *poolConn, now time.Time) bool {
+	if p.cfg.MaxLifetime > 0 && now.Sub(c.created) >= p.cfg.MaxLifetime {
+		return true
+	}
+	return p.cfg.IdleTimeout > 0 && !c.returned.IsZero() && now.Sub(c.returned) >= p.cfg.IdleTimeout
+}
+
+// isConnError returns true if an error returned by a call means the
+// connection shouldn't be reused. Only exceptions sent by the server leave
+// the connection in a known state.
+func isConnError(err error) bool {
+	switch err.(type) {
+	case nil, rpc.ServerError:
+		return false
+	}
+	return true
+}
+
+func (p *Pool) maintain() {
+	ticker := time.NewTicker(p.cfg.CheckInterval)
+	defer ticker.Stop()
+	for {
+		p.check()
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check closes expired and unhealthy idle connections and opens
+// connections to reach MinIdle.
+func (p *Pool) check() {
+	now := time.Now()
+	p.mu.Lock()
+	var remove, keep []*poolConn
+	for _, c := range p.idle {
+		if p.expired(c, now) {
+			remove = append(remove, c)
+		} else {
+			keep = append(keep, c)
+		}
+	}
+	p.idle = keep
+	p.open -= len(remove)
+	if len(remove) > 0 {
+		p.cond.Broadcast()
+	}
+	var check []*poolConn
+	if p.cfg.HealthCheck != nil {
+		// Take the connections out of the pool while they're being checked
+		check = p.idle
+		p.idle = nil
+	}
+	p.mu.Unlock()
+
+	for _, c := range remove {
+		c.client.Close()
+	}
+	for _, c := range check {
+		if err := p.cfg.HealthCheck(c.client); err != nil {
+			p.discard(c)
+		} else {
+			p.release(c)
+		}
+	}
+
+	for {
+		p.mu.Lock()
+		if p.closed || len(p.idle) >= p.cfg.MinIdle || (p.cfg.MaxOpen > 0 && p.open >= p.cfg.MaxOpen) {
+			p.mu.Unlock()
+			return
+		}
+		p.open++
+		p.mu.Unlock()
+
+		client, err := p.dial()
+		if err != nil {
+			p.mu.Lock()
+			p.open--
+			p.cond.Signal()
+			p.mu.Unlock()
+			return
+		}
+		now := time.Now()
+		if !p.release(&poolConn{client: client, created: now, returned: now}) {
+			// Opening more connections would only close them again
+			return
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testPoolDialer(dials *int32, maxFrameSize int) func() (*rpc.Client, error) {
+	return func() (*rpc.Client, error) {
+		atomic.AddInt32(dials, 1)
+		conn, err := net.Dial("tcp", serverAddr)
+		if err != nil {
+			return nil, err
+		}
+		return NewClient(NewTransport(NewFramedReadWriteCloser(conn, maxFrameSize), BinaryProtocol), false), nil
+	}
+}
+
+func waitForPool(t *testing.T, p *Pool, fn func(PoolStats) bool) {
+	for i := 0; i < 100; i++ {
+		if fn(p.Stats()) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Pool didn't reach the expected state: %+v", p.Stats())
+}
+
+func TestPool(t *testing.T) {
+	once.Do(startServer)
+
+	var dials int32
+	p := NewPool(testPoolDialer(&dials, 0), PoolConfig{MaxOpen: 2})
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int32) {
+			defer wg.Done()
+			res := &TestResponse{}
+			if err := p.Call("Success", &TestRequest{i}, res); err != nil {
+				t.Errorf("Call returned error: %+v", err)
+			} else if res.Value != i {
+				t.Errorf("Expected %d instead of %d", i, res.Value)
+			}
+		}(int32(i))
+	}
+	wg.Wait()
+	if st := p.Stats(); st.Open > 2 || st.InUse != 0 {
+		t.Fatalf("Unexpected stats %+v", st)
+	}
+
+	// Exceptions from the server don't close the connection
+	open := p.Stats().Open
+	if err := p.Call("Fail", &TestRequest{1}, &TestResponse{}); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if st := p.Stats(); st.Open != open {
+		t.Fatalf("Expected %d open connections after an exception instead of %d", open, st.Open)
+	}
+
+	p.Close()
+	if err := p.Call("Success", &TestRequest{1}, &TestResponse{}); err != ErrPoolClosed {
+		t.Fatalf("Expected ErrPoolClosed instead of %+v", err)
+	}
+	if st := p.Stats(); st.Open != 0 {
+		t.Fatalf("Expected no open connections after Close instead of %d", st.Open)
+	}
+}
+
+func TestPoolEvictsBrokenConnections(t *testing.T) {
+	once.Do(startServer)
+
+	var dials int32
+	p := NewPool(testPoolDialer(&dials, 4), PoolConfig{})
+	defer p.Close()
+
+	err := p.Call("Success", &TestRequest{1}, &TestResponse{})
+	if _, ok := err.(*ErrFrameTooBig); !ok {
+		t.Fatalf("Expected ErrFrameTooBig instead of %+v", err)
+	}
+	if st := p.Stats(); st.Open != 0 {
+		t.Fatalf("Expected the connection to be closed instead of %+v", st)
+	}
+}
+
+func TestPoolLifetime(t *testing.T) {
+	once.Do(startServer)
+
+	var dials int32
+	p := NewPool(testPoolDialer(&dials, 0), PoolConfig{MaxLifetime: 20 * time.Millisecond, CheckInterval: 10 * time.Millisecond})
+	defer p.Close()
+
+	if err := p.Call("Success", &TestRequest{1}, &TestResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Call("Success", &TestRequest{1}, &TestResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatalf("Expected the connection to be reused instead of %d dials", n)
+	}
+	waitForPool(t, p, func(st PoolStats) bool { return st.Open == 0 })
+	if err := p.Call("Success", &TestRequest{1}, &TestResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&dials); n != 2 {
+		t.Fatalf("Expected 2 dials instead of %d", n)
+	}
+}
+
+func TestPoolMinIdleAndHealthCheck(t *testing.T) {
+	once.Do(startServer)
+
+	var dials int32
+	var healthy int32 = 1
+	p := NewPool(testPoolDialer(&dials, 0), PoolConfig{
+		MinIdle:       2,
+		CheckInterval: 10 * time.Millisecond,
+		HealthCheck: func(c *rpc.Client) error {
+			if atomic.LoadInt32(&healthy) == 0 {
+				return errors.New("unhealthy")
+			}
+			return c.Call("Success", &TestRequest{1}, &TestResponse{})
+		},
+	})
+	defer p.Close()
+
+	waitForPool(t, p, func(st PoolStats) bool { return st.Idle == 2 })
+	atomic.StoreInt32(&healthy, 0)
+	// Unhealthy connections are replaced
+	waitForPool(t, p, func(st PoolStats) bool { return atomic.LoadInt32(&dials) >= 4 })
+}
+
+func TestPoolMinIdleAboveMaxIdle(t *testing.T) {
+	once.Do(startServer)
+
+	var dials int32
+	p := NewPool(testPoolDialer(&dials, 0), PoolConfig{
+		MinIdle:       2,
+		MaxIdle:       1,
+		CheckInterval: 10 * time.Millisecond,
+	})
+	defer p.Close()
+
+	waitForPool(t, p, func(st PoolStats) bool { return st.Idle == 1 })
+	// Let a few more checks run
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatalf("Expected 1 dial to keep MaxIdle connections instead of %d", n)
+	}
+	if st := p.Stats(); st.Open != 1 || st.Idle != 1 {
+		t.Fatalf("Unexpected pool stats %+v", st)
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import "context"
+
+// Processor dispatches requests to a service implementation. The generator
+// creates a Processor for each service (e.g. NewFooProcessor(impl)) which
+// only uses standard types so generated code doesn't import this package.
+type Processor interface {
+	// NewRequest returns a new request struct to decode the arguments of
+	// method into, or nil if the method is unknown.
+	NewRequest(method string) interface{}
+	// Process calls the implementation of method with a request returned
+	// by NewRequest. It returns the response struct to encode, or nil for
+	// oneway methods. Exceptions declared in the IDL are returned as part
+	// of the response rather than as an error.
+	Process(ctx context.Context, method string, request interface{}) (response interface{}, err error)
+}
@@ -43,6 +43,20 @@ func (p *protocolBuilder) NewProtocolWriter(w io.Writer) ProtocolWriter {
 	return p.writer(w)
 }
 
+// ResettableProtocolReader is implemented by protocol readers that can be
+// reused for a new stream (all of the readers in this package).
+type ResettableProtocolReader interface {
+	ProtocolReader
+	Reset(r io.Reader)
+}
+
+// ResettableProtocolWriter is implemented by protocol writers that can be
+// reused for a new stream (all of the writers in this package).
+type ResettableProtocolWriter interface {
+	ProtocolWriter
+	Reset(w io.Writer)
+}
+
 type ProtocolReadWriter interface {
 	ProtocolReader
 	ProtocolWriter
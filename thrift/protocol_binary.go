@@ -28,8 +28,11 @@ type binaryProtocolWriter struct {
 
 type binaryProtocolReader struct {
 	r      io.Reader
+	zr     ZeroCopyReader // set in zero-copy mode
+	zcopy  bool
 	strict bool
 	buf    []byte
+	maxStr int // maximum string length (0 is unlimited)
 }
 
 var BinaryProtocol = NewProtocolBuilder(
@@ -37,6 +40,14 @@ var BinaryProtocol = NewProtocolBuilder(
 	func(w io.Writer) ProtocolWriter { return NewBinaryProtocolWriter(w, true) },
 )
 
+// BinaryProtocolZeroCopy is the same as BinaryProtocol except that binary
+// values are returned as slices of the underlying buffer when reading from a
+// ZeroCopyReader (see NewBinaryProtocolReaderZeroCopy).
+var BinaryProtocolZeroCopy = NewProtocolBuilder(
+	func(r io.Reader) ProtocolReader { return NewBinaryProtocolReaderZeroCopy(r, false) },
+	func(w io.Writer) ProtocolWriter { return NewBinaryProtocolWriter(w, true) },
+)
+
 func NewBinaryProtocolWriter(w io.Writer, strict bool) ProtocolWriter {
 	p := &binaryProtocolWriter{
 		w:      w,
@@ -55,6 +66,38 @@ func NewBinaryProtocolReader(r io.Reader, strict bool) ProtocolReader {
 	return p
 }
 
+// NewBinaryProtocolReaderZeroCopy returns a binary protocol reader that,
+// if r is a ZeroCopyReader, returns binary values from ReadBytes as slices
+// of r's buffer instead of allocating. The slices are only valid as long as
+// the buffer is (for a FramedReadWriteCloser until Release is called), so
+// callers must copy any values they want to keep longer. Strings are still
+// copied. If r isn't a ZeroCopyReader this is the same as
+// NewBinaryProtocolReader.
+func NewBinaryProtocolReaderZeroCopy(r io.Reader, strict bool) ProtocolReader {
+	p := &binaryProtocolReader{
+		r:      r,
+		strict: strict,
+		zcopy:  true,
+		buf:    make([]byte, 32),
+	}
+	p.zr, _ = r.(ZeroCopyReader)
+	return p
+}
+
+// Reset discards any state and switches the writer to write to w.
+func (p *binaryProtocolWriter) Reset(w io.Writer) {
+	p.w = w
+}
+
+// Reset discards any state and switches the reader to read from r.
+func (p *binaryProtocolReader) Reset(r io.Reader) {
+	p.r = r
+	p.zr = nil
+	if p.zcopy {
+		p.zr, _ = r.(ZeroCopyReader)
+	}
+}
+
 func (p *binaryProtocolWriter) WriteMessageBegin(name string, messageType byte, seqid int32) error {
 	if p.strict {
 		if err := p.WriteI32(int32(version1 | uint32(messageType))); err != nil {
@@ -369,6 +412,9 @@ func (p *binaryProtocolReader) ReadString() (string, error) {
 	if ln < 0 {
 		return "", ProtocolError{"BinaryProtocol", "negative length while reading string"}
 	}
+	if p.maxStr > 0 && int(ln) > p.maxStr {
+		return "", ErrStringTooLong{int64(ln), int64(p.maxStr)}
+	}
 	b := p.buf
 	if int(ln) > len(b) {
 		b = make([]byte, ln)
@@ -389,9 +435,24 @@ func (p *binaryProtocolReader) ReadBytes() ([]byte, error) {
 	if ln < 0 {
 		return nil, ProtocolError{"BinaryProtocol", "negative length while reading bytes"}
 	}
+	if p.maxStr > 0 && int(ln) > p.maxStr {
+		return nil, ErrStringTooLong{int64(ln), int64(p.maxStr)}
+	}
+	if p.zr != nil {
+		b := p.zr.Next(int(ln))
+		if len(b) < int(ln) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		// Limit the capacity so appends can't overwrite the following data
+		return b[:ln:ln], nil
+	}
 	b := make([]byte, ln)
 	if _, err := io.ReadFull(p.r, b); err != nil {
 		return nil, err
 	}
 	return b, nil
 }
+
+func (p *binaryProtocolReader) setMaxStringLength(n int) {
+	p.maxStr = n
+}
@@ -6,6 +6,7 @@ package thrift
 
 import (
 	"bytes"
+	"io"
 	"testing"
 )
 
@@ -298,3 +299,88 @@ func BenchmarkBinaryProtocolWriteFullMessage(b *testing.B) {
 		w.WriteMessageEnd()
 	}
 }
+
+func TestBinaryProtocolZeroCopy(t *testing.T) {
+	b := &bytes.Buffer{}
+	testProtocol(t, NewBinaryProtocolReaderZeroCopy(b, false), NewBinaryProtocolWriter(b, true))
+
+	b.Reset()
+	w := NewBinaryProtocolWriter(b, true)
+	if err := w.WriteBytes([]byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteByte(4); err != nil {
+		t.Fatal(err)
+	}
+	start := &b.Bytes()[4]
+	r := NewBinaryProtocolReaderZeroCopy(b, false)
+	v, err := r.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &v[0] != start {
+		t.Fatal("BinaryProtocol.ReadBytes didn't return a slice of the buffer in zero-copy mode")
+	}
+	if cap(v) != 3 {
+		t.Fatalf("Expected capacity of 3 instead of %d", cap(v))
+	}
+	if c, err := r.ReadByte(); err != nil {
+		t.Fatal(err)
+	} else if c != 4 {
+		t.Fatalf("Expected 4 after bytes instead of %d", c)
+	}
+
+	// Length beyond the end of the buffer
+	b.Reset()
+	w.WriteI32(10)
+	w.WriteByte(1)
+	if _, err := r.ReadBytes(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected io.ErrUnexpectedEOF instead of %+v", err)
+	}
+}
+
+func TestBinaryProtocolZeroCopyFramed(t *testing.T) {
+	buf := &ClosingBuffer{&bytes.Buffer{}}
+	framed := NewFramedReadWriteCloser(buf, 0)
+	w := NewBinaryProtocolWriter(framed, true)
+	for _, v := range []string{"first", "other", "third"} {
+		w.WriteBytes([]byte(v))
+		framed.Flush()
+	}
+
+	r := NewBinaryProtocolReaderZeroCopy(framed, false)
+	first, err := r.ReadBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without Release the buffer must not be reused for the next frame
+	if second, err := r.ReadBytes(); err != nil {
+		t.Fatal(err)
+	} else if string(second) != "other" {
+		t.Fatalf("Expected 'other' instead of '%s'", second)
+	}
+	if string(first) != "first" {
+		t.Fatalf("Frame buffer was reused before Release: '%s'", first)
+	}
+
+	framed.Release()
+	if third, err := r.ReadBytes(); err != nil {
+		t.Fatal(err)
+	} else if string(third) != "third" {
+		t.Fatalf("Expected 'third' instead of '%s'", third)
+	}
+}
+
+func BenchmarkBinaryProtocolReadBytesZeroCopy(b *testing.B) {
+	buf := &bytes.Buffer{}
+	w := NewBinaryProtocolWriter(buf, true)
+	data := make([]byte, 1024)
+	for i := 0; i < b.N; i++ {
+		w.WriteBytes(data)
+	}
+	r := NewBinaryProtocolReaderZeroCopy(buf, false)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.ReadBytes()
+	}
+}
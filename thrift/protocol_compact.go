@@ -85,6 +85,7 @@ type compactProtocolReader struct {
 	structs     []int16
 	container   []int
 	buf         []byte
+	maxStr      int // maximum string length (0 is unlimited)
 }
 
 var CompactProtocol = NewProtocolBuilder(NewCompactProtocolReader, NewCompactProtocolWriter)
@@ -113,6 +114,26 @@ func NewCompactProtocolReader(r io.Reader) ProtocolReader {
 	}
 }
 
+// Reset discards any state and switches the writer to write to w.
+func (p *compactProtocolWriter) Reset(w io.Writer) {
+	p.w = w
+	p.lastFieldID = 0
+	p.boolFid = -1
+	p.boolValue = false
+	p.structs = p.structs[:0]
+	p.container = p.container[:0]
+}
+
+// Reset discards any state and switches the reader to read from r.
+func (p *compactProtocolReader) Reset(r io.Reader) {
+	p.r = r
+	p.lastFieldID = 0
+	p.boolFid = -1
+	p.boolValue = false
+	p.structs = p.structs[:0]
+	p.container = p.container[:0]
+}
+
 func (p *compactProtocolWriter) writeVarint(value int64) (err error) {
 	n := binary.PutVarint(p.buf, value)
 	_, err = p.w.Write(p.buf[:n])
@@ -386,7 +407,7 @@ func (p *compactProtocolReader) ReadMessageBegin() (string, byte, int32, error)
 	if version != compactVersion {
 		return "", 0, -1, ProtocolError{"CompactProtocol", "invalid compact protocol version"}
 	}
-	msgType := (versionAndType >> compactTypeShiftAmount) & 0x03
+	msgType := (versionAndType & compactTypeMask) >> compactTypeShiftAmount
 	seqID, err := p.readUvarint()
 	if err != nil {
 		return "", 0, -1, err
@@ -551,6 +572,9 @@ func (p *compactProtocolReader) ReadString() (string, error) {
 	} else if ln < 0 {
 		return "", ProtocolError{"CompactProtocol", "negative length in CompactProtocol.ReadString"}
 	}
+	if p.maxStr > 0 && int(ln) > p.maxStr {
+		return "", ErrStringTooLong{int64(ln), int64(p.maxStr)}
+	}
 	b := p.buf
 	if int(ln) > len(b) {
 		b = make([]byte, ln)
@@ -570,6 +594,9 @@ func (p *compactProtocolReader) ReadBytes() ([]byte, error) {
 	} else if ln < 0 {
 		return nil, ProtocolError{"CompactProtocol", "negative length in CompactProtocol.ReadBytes"}
 	}
+	if p.maxStr > 0 && int(ln) > p.maxStr {
+		return nil, ErrStringTooLong{int64(ln), int64(p.maxStr)}
+	}
 	b := make([]byte, ln)
 	if _, err := io.ReadFull(p.r, b); err != nil {
 		return nil, err
@@ -596,3 +623,7 @@ func (p *compactProtocolReader) ReadListEnd() error {
 func (p *compactProtocolReader) ReadSetEnd() error {
 	return nil
 }
+
+func (p *compactProtocolReader) setMaxStringLength(n int) {
+	p.maxStr = n
+}
@@ -164,6 +164,22 @@ func TestCompactI32(t *testing.T) {
 	}
 }
 
+func TestCompactMessageType(t *testing.T) {
+	b := &bytes.Buffer{}
+	w := NewCompactProtocolWriter(b)
+	r := NewCompactProtocolReader(b)
+	for _, exp := range []byte{MessageTypeCall, MessageTypeReply, MessageTypeException, MessageTypeOneway} {
+		if err := w.WriteMessageBegin("msg", exp, 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, mtype, _, err := r.ReadMessageBegin(); err != nil {
+			t.Fatal(err)
+		} else if mtype != exp {
+			t.Fatalf("ReadMessageBegin returned message type %d instead of %d", mtype, exp)
+		}
+	}
+}
+
 func BenchmarkCompactProtocolReadByte(b *testing.B) {
 	buf := &loopingReader{}
 	w := NewCompactProtocolWriter(buf)
@@ -0,0 +1,850 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// The JSON protocol is wire compatible with the Apache Thrift TJSONProtocol.
+// Messages are encoded as [version, name, type, seqid, body], structs as
+// objects keyed by field id whose values are single entry objects keyed by
+// a short type name, and binary values as base64 strings.
+
+const jsonProtocolVersion = 1
+
+const (
+	jsonContextBase = iota
+	jsonContextList
+	jsonContextPair
+)
+
+var jsonTypeNames = map[byte]string{
+	TypeBool:   "tf",
+	TypeByte:   "i8",
+	TypeI16:    "i16",
+	TypeI32:    "i32",
+	TypeI64:    "i64",
+	TypeDouble: "dbl",
+	TypeStruct: "rec",
+	TypeString: "str",
+	TypeMap:    "map",
+	TypeSet:    "set",
+	TypeList:   "lst",
+}
+
+var jsonNameTypes = map[string]byte{}
+
+func init() {
+	for t, n := range jsonTypeNames {
+		jsonNameTypes[n] = t
+	}
+}
+
+// jsonContext tracks the separators that are required between values. In a
+// list every value but the first is preceeded by a comma. In a pair context
+// (object) keys and values alternate being preceeded by commas and colons.
+type jsonContext struct {
+	kind  int
+	first bool
+	colon bool
+}
+
+// next advances the context and returns the separator that must preceed the
+// next value (0 for none), and whether numbers must be quoted because the
+// value is an object key.
+func (c *jsonContext) next() (sep byte, escapeNum bool) {
+	switch c.kind {
+	case jsonContextList:
+		if c.first {
+			c.first = false
+		} else {
+			sep = ','
+		}
+	case jsonContextPair:
+		if c.first {
+			c.first = false
+			c.colon = true
+		} else {
+			if c.colon {
+				sep = ':'
+			} else {
+				sep = ','
+			}
+			c.colon = !c.colon
+		}
+		escapeNum = c.colon
+	}
+	return
+}
+
+type jsonProtocolWriter struct {
+	w     io.Writer
+	ctx   jsonContext
+	stack []jsonContext
+	buf   []byte
+}
+
+type jsonProtocolReader struct {
+	r      io.ByteReader
+	br     *bufio.Reader // used when the reader isn't an io.ByteReader
+	peeked bool
+	peek   byte
+	ctx    jsonContext
+	stack  []jsonContext
+	buf    []byte
+}
+
+var JSONProtocol = NewProtocolBuilder(NewJSONProtocolReader, NewJSONProtocolWriter)
+
+func NewJSONProtocolWriter(w io.Writer) ProtocolWriter {
+	return &jsonProtocolWriter{
+		w:     w,
+		stack: make([]jsonContext, 0, 8),
+		buf:   make([]byte, 0, 64),
+	}
+}
+
+func NewJSONProtocolReader(r io.Reader) ProtocolReader {
+	p := &jsonProtocolReader{
+		stack: make([]jsonContext, 0, 8),
+		buf:   make([]byte, 0, 64),
+	}
+	p.Reset(r)
+	return p
+}
+
+// Reset discards any state and switches the writer to write to w.
+func (p *jsonProtocolWriter) Reset(w io.Writer) {
+	p.w = w
+	p.ctx = jsonContext{}
+	p.stack = p.stack[:0]
+}
+
+// Reset discards any state and switches the reader to read from r.
+func (p *jsonProtocolReader) Reset(r io.Reader) {
+	if br, ok := r.(io.ByteReader); ok {
+		p.r = br
+	} else {
+		if p.br == nil {
+			p.br = bufio.NewReader(r)
+		} else {
+			p.br.Reset(r)
+		}
+		p.r = p.br
+	}
+	p.peeked = false
+	p.ctx = jsonContext{}
+	p.stack = p.stack[:0]
+}
+
+func (p *jsonProtocolWriter) push(kind int) {
+	p.stack = append(p.stack, p.ctx)
+	p.ctx = jsonContext{kind: kind, first: true}
+}
+
+func (p *jsonProtocolWriter) pop() error {
+	if len(p.stack) == 0 {
+		return ProtocolError{"JSONProtocol", "unbalanced end of container"}
+	}
+	p.ctx = p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+	return nil
+}
+
+// begin starts a new token in the write buffer by appending any separator
+// required by the current context.
+func (p *jsonProtocolWriter) begin() (escapeNum bool) {
+	sep, escapeNum := p.ctx.next()
+	p.buf = p.buf[:0]
+	if sep != 0 {
+		p.buf = append(p.buf, sep)
+	}
+	return escapeNum
+}
+
+func (p *jsonProtocolWriter) flush() error {
+	_, err := p.w.Write(p.buf)
+	return err
+}
+
+func (p *jsonProtocolWriter) writeArrayBegin() error {
+	p.begin()
+	p.buf = append(p.buf, '[')
+	p.push(jsonContextList)
+	return p.flush()
+}
+
+func (p *jsonProtocolWriter) writeArrayEnd() error {
+	if err := p.pop(); err != nil {
+		return err
+	}
+	_, err := p.w.Write([]byte{']'})
+	return err
+}
+
+func (p *jsonProtocolWriter) writeObjectBegin() error {
+	p.begin()
+	p.buf = append(p.buf, '{')
+	p.push(jsonContextPair)
+	return p.flush()
+}
+
+func (p *jsonProtocolWriter) writeObjectEnd() error {
+	if err := p.pop(); err != nil {
+		return err
+	}
+	_, err := p.w.Write([]byte{'}'})
+	return err
+}
+
+func (p *jsonProtocolWriter) writeInteger(value int64) error {
+	escapeNum := p.begin()
+	if escapeNum {
+		p.buf = append(p.buf, '"')
+	}
+	p.buf = strconv.AppendInt(p.buf, value, 10)
+	if escapeNum {
+		p.buf = append(p.buf, '"')
+	}
+	return p.flush()
+}
+
+func (p *jsonProtocolWriter) writeTypeName(typ byte) error {
+	name, ok := jsonTypeNames[typ]
+	if !ok {
+		return ProtocolError{"JSONProtocol", fmt.Sprintf("unsupported type %d", typ)}
+	}
+	return p.WriteString(name)
+}
+
+func (p *jsonProtocolWriter) WriteMessageBegin(name string, messageType byte, seqid int32) error {
+	if err := p.writeArrayBegin(); err != nil {
+		return err
+	}
+	if err := p.writeInteger(jsonProtocolVersion); err != nil {
+		return err
+	}
+	if err := p.WriteString(name); err != nil {
+		return err
+	}
+	if err := p.writeInteger(int64(messageType)); err != nil {
+		return err
+	}
+	return p.writeInteger(int64(seqid))
+}
+
+func (p *jsonProtocolWriter) WriteMessageEnd() error {
+	return p.writeArrayEnd()
+}
+
+func (p *jsonProtocolWriter) WriteStructBegin(name string) error {
+	return p.writeObjectBegin()
+}
+
+func (p *jsonProtocolWriter) WriteStructEnd() error {
+	return p.writeObjectEnd()
+}
+
+func (p *jsonProtocolWriter) WriteFieldBegin(name string, fieldType byte, id int16) error {
+	if err := p.writeInteger(int64(id)); err != nil {
+		return err
+	}
+	if err := p.writeObjectBegin(); err != nil {
+		return err
+	}
+	return p.writeTypeName(fieldType)
+}
+
+func (p *jsonProtocolWriter) WriteFieldEnd() error {
+	return p.writeObjectEnd()
+}
+
+func (p *jsonProtocolWriter) WriteFieldStop() error {
+	return nil
+}
+
+func (p *jsonProtocolWriter) WriteMapBegin(keyType byte, valueType byte, size int) error {
+	if err := p.writeArrayBegin(); err != nil {
+		return err
+	}
+	if err := p.writeTypeName(keyType); err != nil {
+		return err
+	}
+	if err := p.writeTypeName(valueType); err != nil {
+		return err
+	}
+	if err := p.writeInteger(int64(size)); err != nil {
+		return err
+	}
+	return p.writeObjectBegin()
+}
+
+func (p *jsonProtocolWriter) WriteMapEnd() error {
+	if err := p.writeObjectEnd(); err != nil {
+		return err
+	}
+	return p.writeArrayEnd()
+}
+
+func (p *jsonProtocolWriter) WriteListBegin(elementType byte, size int) error {
+	if err := p.writeArrayBegin(); err != nil {
+		return err
+	}
+	if err := p.writeTypeName(elementType); err != nil {
+		return err
+	}
+	return p.writeInteger(int64(size))
+}
+
+func (p *jsonProtocolWriter) WriteListEnd() error {
+	return p.writeArrayEnd()
+}
+
+func (p *jsonProtocolWriter) WriteSetBegin(elementType byte, size int) error {
+	return p.WriteListBegin(elementType, size)
+}
+
+func (p *jsonProtocolWriter) WriteSetEnd() error {
+	return p.writeArrayEnd()
+}
+
+func (p *jsonProtocolWriter) WriteBool(value bool) error {
+	if value {
+		return p.writeInteger(1)
+	}
+	return p.writeInteger(0)
+}
+
+func (p *jsonProtocolWriter) WriteByte(value byte) error {
+	return p.writeInteger(int64(int8(value)))
+}
+
+func (p *jsonProtocolWriter) WriteI16(value int16) error {
+	return p.writeInteger(int64(value))
+}
+
+func (p *jsonProtocolWriter) WriteI32(value int32) error {
+	return p.writeInteger(int64(value))
+}
+
+func (p *jsonProtocolWriter) WriteI64(value int64) error {
+	return p.writeInteger(value)
+}
+
+// WriteDouble writes a number, or one of the quoted strings "NaN", "Infinity"
+// and "-Infinity" for values that can't be represented as a JSON number.
+func (p *jsonProtocolWriter) WriteDouble(value float64) error {
+	escapeNum := p.begin()
+	special := ""
+	switch {
+	case math.IsNaN(value):
+		special = "NaN"
+	case math.IsInf(value, 1):
+		special = "Infinity"
+	case math.IsInf(value, -1):
+		special = "-Infinity"
+	}
+	if special != "" {
+		p.buf = append(p.buf, '"')
+		p.buf = append(p.buf, special...)
+		p.buf = append(p.buf, '"')
+		return p.flush()
+	}
+	if escapeNum {
+		p.buf = append(p.buf, '"')
+	}
+	p.buf = strconv.AppendFloat(p.buf, value, 'g', -1, 64)
+	if escapeNum {
+		p.buf = append(p.buf, '"')
+	}
+	return p.flush()
+}
+
+func (p *jsonProtocolWriter) WriteString(value string) error {
+	p.begin()
+	p.buf = append(p.buf, '"')
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"' || c == '\\':
+			p.buf = append(p.buf, '\\', c)
+		case c == '\b':
+			p.buf = append(p.buf, '\\', 'b')
+		case c == '\f':
+			p.buf = append(p.buf, '\\', 'f')
+		case c == '\n':
+			p.buf = append(p.buf, '\\', 'n')
+		case c == '\r':
+			p.buf = append(p.buf, '\\', 'r')
+		case c == '\t':
+			p.buf = append(p.buf, '\\', 't')
+		case c < 0x20:
+			p.buf = append(p.buf, fmt.Sprintf(`\u%04x`, c)...)
+		default:
+			p.buf = append(p.buf, c)
+		}
+	}
+	p.buf = append(p.buf, '"')
+	return p.flush()
+}
+
+// WriteBytes writes binary data as a base64 encoded string without padding.
+func (p *jsonProtocolWriter) WriteBytes(value []byte) error {
+	p.begin()
+	p.buf = append(p.buf, '"')
+	n := len(p.buf)
+	ln := base64.RawStdEncoding.EncodedLen(len(value))
+	if cap(p.buf)-n < ln+1 {
+		b := make([]byte, n, n+ln+1)
+		copy(b, p.buf)
+		p.buf = b
+	}
+	p.buf = p.buf[:n+ln]
+	base64.RawStdEncoding.Encode(p.buf[n:], value)
+	p.buf = append(p.buf, '"')
+	return p.flush()
+}
+
+func (p *jsonProtocolReader) push(kind int) {
+	p.stack = append(p.stack, p.ctx)
+	p.ctx = jsonContext{kind: kind, first: true}
+}
+
+func (p *jsonProtocolReader) pop() error {
+	if len(p.stack) == 0 {
+		return ProtocolError{"JSONProtocol", "unbalanced end of container"}
+	}
+	p.ctx = p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+	return nil
+}
+
+func (p *jsonProtocolReader) readByte() (byte, error) {
+	if p.peeked {
+		p.peeked = false
+		return p.peek, nil
+	}
+	return p.r.ReadByte()
+}
+
+func (p *jsonProtocolReader) unreadByte(b byte) {
+	p.peek = b
+	p.peeked = true
+}
+
+// readNonSpace returns the next byte that isn't insignificant whitespace.
+func (p *jsonProtocolReader) readNonSpace() (byte, error) {
+	for {
+		b, err := p.readByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return b, nil
+		}
+	}
+}
+
+func (p *jsonProtocolReader) peekNonSpace() (byte, error) {
+	b, err := p.readNonSpace()
+	if err != nil {
+		return 0, err
+	}
+	p.unreadByte(b)
+	return b, nil
+}
+
+func (p *jsonProtocolReader) expect(c byte) error {
+	b, err := p.readNonSpace()
+	if err != nil {
+		return err
+	}
+	if b != c {
+		return ProtocolError{"JSONProtocol", fmt.Sprintf("expected '%c' but found '%c'", c, b)}
+	}
+	return nil
+}
+
+// begin consumes any separator required by the current context before the
+// next value and returns whether numbers are expected to be quoted.
+func (p *jsonProtocolReader) begin() (escapeNum bool, err error) {
+	sep, escapeNum := p.ctx.next()
+	if sep != 0 {
+		err = p.expect(sep)
+	}
+	return escapeNum, err
+}
+
+func (p *jsonProtocolReader) readArrayBegin() error {
+	if _, err := p.begin(); err != nil {
+		return err
+	}
+	if err := p.expect('['); err != nil {
+		return err
+	}
+	p.push(jsonContextList)
+	return nil
+}
+
+func (p *jsonProtocolReader) readArrayEnd() error {
+	if err := p.expect(']'); err != nil {
+		return err
+	}
+	return p.pop()
+}
+
+func (p *jsonProtocolReader) readObjectBegin() error {
+	if _, err := p.begin(); err != nil {
+		return err
+	}
+	if err := p.expect('{'); err != nil {
+		return err
+	}
+	p.push(jsonContextPair)
+	return nil
+}
+
+func (p *jsonProtocolReader) readObjectEnd() error {
+	if err := p.expect('}'); err != nil {
+		return err
+	}
+	return p.pop()
+}
+
+func isJSONNumeric(b byte) bool {
+	switch b {
+	case '+', '-', '.', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', 'e', 'E':
+		return true
+	}
+	return false
+}
+
+// readNumeric reads the characters of a number into the scratch buffer. The
+// end of the stream is a valid terminator since values at the top level
+// aren't followed by anything.
+func (p *jsonProtocolReader) readNumeric() ([]byte, error) {
+	b, err := p.readNonSpace()
+	if err != nil {
+		return nil, err
+	}
+	p.buf = p.buf[:0]
+	for isJSONNumeric(b) {
+		p.buf = append(p.buf, b)
+		if b, err = p.readByte(); err == io.EOF {
+			return p.buf, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	p.unreadByte(b)
+	if len(p.buf) == 0 {
+		return nil, ProtocolError{"JSONProtocol", fmt.Sprintf("expected numeric value but found '%c'", b)}
+	}
+	return p.buf, nil
+}
+
+func (p *jsonProtocolReader) readInteger() (int64, error) {
+	escapeNum, err := p.begin()
+	if err != nil {
+		return 0, err
+	}
+	if escapeNum {
+		if err := p.expect('"'); err != nil {
+			return 0, err
+		}
+	}
+	b, err := p.readNumeric()
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, ProtocolError{"JSONProtocol", fmt.Sprintf("bad integer value %q", b)}
+	}
+	if escapeNum {
+		if err := p.expect('"'); err != nil {
+			return 0, err
+		}
+	}
+	return v, nil
+}
+
+// readRawString reads a quoted string into the scratch buffer without
+// processing escape sequences. The returned bool reports whether any escapes
+// were present.
+func (p *jsonProtocolReader) readRawString() ([]byte, bool, error) {
+	if err := p.expect('"'); err != nil {
+		return nil, false, err
+	}
+	p.buf = p.buf[:0]
+	escaped := false
+	for {
+		b, err := p.readByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, false, err
+		}
+		switch b {
+		case '"':
+			return p.buf, escaped, nil
+		case '\\':
+			escaped = true
+			p.buf = append(p.buf, b)
+			if b, err = p.readByte(); err != nil {
+				return nil, false, err
+			}
+		}
+		p.buf = append(p.buf, b)
+	}
+}
+
+func (p *jsonProtocolReader) readString() (string, error) {
+	raw, escaped, err := p.readRawString()
+	if err != nil {
+		return "", err
+	}
+	if !escaped {
+		return string(raw), nil
+	}
+	quoted := make([]byte, 0, len(raw)+2)
+	quoted = append(quoted, '"')
+	quoted = append(quoted, raw...)
+	quoted = append(quoted, '"')
+	var s string
+	if err := json.Unmarshal(quoted, &s); err != nil {
+		return "", ProtocolError{"JSONProtocol", "bad string: " + err.Error()}
+	}
+	return s, nil
+}
+
+func (p *jsonProtocolReader) readTypeName() (byte, error) {
+	name, err := p.ReadString()
+	if err != nil {
+		return 0, err
+	}
+	typ, ok := jsonNameTypes[name]
+	if !ok {
+		return 0, ProtocolError{"JSONProtocol", fmt.Sprintf("unknown type name %q", name)}
+	}
+	return typ, nil
+}
+
+func (p *jsonProtocolReader) readSize() (int, error) {
+	size, err := p.readInteger()
+	if err != nil {
+		return 0, err
+	}
+	if size < 0 || size > math.MaxInt32 {
+		return 0, ProtocolError{"JSONProtocol", "invalid container size"}
+	}
+	return int(size), nil
+}
+
+func (p *jsonProtocolReader) ReadMessageBegin() (name string, messageType byte, seqid int32, err error) {
+	if err = p.readArrayBegin(); err != nil {
+		return
+	}
+	version, e := p.readInteger()
+	if e != nil {
+		err = e
+		return
+	}
+	if version != jsonProtocolVersion {
+		err = ProtocolError{"JSONProtocol", "bad version in ReadMessageBegin"}
+		return
+	}
+	if name, err = p.ReadString(); err != nil {
+		return
+	}
+	mtype, e := p.readInteger()
+	if e != nil {
+		err = e
+		return
+	}
+	messageType = byte(mtype)
+	seq, e := p.readInteger()
+	seqid = int32(seq)
+	err = e
+	return
+}
+
+func (p *jsonProtocolReader) ReadMessageEnd() error {
+	return p.readArrayEnd()
+}
+
+func (p *jsonProtocolReader) ReadStructBegin() error {
+	return p.readObjectBegin()
+}
+
+func (p *jsonProtocolReader) ReadStructEnd() error {
+	return p.readObjectEnd()
+}
+
+// ReadFieldBegin returns TypeStop when the end of the enclosing object is
+// reached since there is no explicit stop marker on the wire.
+func (p *jsonProtocolReader) ReadFieldBegin() (fieldType byte, id int16, err error) {
+	b, err := p.peekNonSpace()
+	if err != nil {
+		return 0, 0, err
+	}
+	if b == '}' {
+		return TypeStop, 0, nil
+	}
+	fid, err := p.readInteger()
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := p.readObjectBegin(); err != nil {
+		return 0, 0, err
+	}
+	fieldType, err = p.readTypeName()
+	return fieldType, int16(fid), err
+}
+
+func (p *jsonProtocolReader) ReadFieldEnd() error {
+	return p.readObjectEnd()
+}
+
+func (p *jsonProtocolReader) ReadMapBegin() (keyType byte, valueType byte, size int, err error) {
+	if err = p.readArrayBegin(); err != nil {
+		return
+	}
+	if keyType, err = p.readTypeName(); err != nil {
+		return
+	}
+	if valueType, err = p.readTypeName(); err != nil {
+		return
+	}
+	if size, err = p.readSize(); err != nil {
+		return
+	}
+	err = p.readObjectBegin()
+	return
+}
+
+func (p *jsonProtocolReader) ReadMapEnd() error {
+	if err := p.readObjectEnd(); err != nil {
+		return err
+	}
+	return p.readArrayEnd()
+}
+
+func (p *jsonProtocolReader) ReadListBegin() (elementType byte, size int, err error) {
+	if err = p.readArrayBegin(); err != nil {
+		return
+	}
+	if elementType, err = p.readTypeName(); err != nil {
+		return
+	}
+	size, err = p.readSize()
+	return
+}
+
+func (p *jsonProtocolReader) ReadListEnd() error {
+	return p.readArrayEnd()
+}
+
+func (p *jsonProtocolReader) ReadSetBegin() (elementType byte, size int, err error) {
+	return p.ReadListBegin()
+}
+
+func (p *jsonProtocolReader) ReadSetEnd() error {
+	return p.readArrayEnd()
+}
+
+func (p *jsonProtocolReader) ReadBool() (bool, error) {
+	v, err := p.readInteger()
+	return v != 0, err
+}
+
+func (p *jsonProtocolReader) ReadByte() (byte, error) {
+	v, err := p.readInteger()
+	return byte(v), err
+}
+
+func (p *jsonProtocolReader) ReadI16() (int16, error) {
+	v, err := p.readInteger()
+	return int16(v), err
+}
+
+func (p *jsonProtocolReader) ReadI32() (int32, error) {
+	v, err := p.readInteger()
+	return int32(v), err
+}
+
+func (p *jsonProtocolReader) ReadI64() (int64, error) {
+	return p.readInteger()
+}
+
+func (p *jsonProtocolReader) ReadDouble() (float64, error) {
+	escapeNum, err := p.begin()
+	if err != nil {
+		return 0, err
+	}
+	b, err := p.peekNonSpace()
+	if err != nil {
+		return 0, err
+	}
+	var s string
+	if b == '"' {
+		if s, err = p.readString(); err != nil {
+			return 0, err
+		}
+	} else if escapeNum {
+		return 0, ProtocolError{"JSONProtocol", "expected quoted double"}
+	} else {
+		raw, err := p.readNumeric()
+		if err != nil {
+			return 0, err
+		}
+		s = string(raw)
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, ProtocolError{"JSONProtocol", fmt.Sprintf("bad double value %q", s)}
+	}
+	return v, nil
+}
+
+func (p *jsonProtocolReader) ReadString() (string, error) {
+	if _, err := p.begin(); err != nil {
+		return "", err
+	}
+	return p.readString()
+}
+
+// ReadBytes reads a base64 encoded string. Padding is optional.
+func (p *jsonProtocolReader) ReadBytes() ([]byte, error) {
+	if _, err := p.begin(); err != nil {
+		return nil, err
+	}
+	raw, _, err := p.readRawString()
+	if err != nil {
+		return nil, err
+	}
+	for len(raw) > 0 && raw[len(raw)-1] == '=' {
+		raw = raw[:len(raw)-1]
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	b := make([]byte, base64.RawStdEncoding.DecodedLen(len(raw)))
+	n, err := base64.RawStdEncoding.Decode(b, raw)
+	if err != nil {
+		return nil, ProtocolError{"JSONProtocol", "bad base64 value: " + err.Error()}
+	}
+	return b[:n], nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type jsonTestStruct struct {
+	Bool   bool               `thrift:"1"`
+	Byte   int8               `thrift:"2"`
+	I16    int16              `thrift:"3"`
+	I32    int32              `thrift:"4"`
+	I64    int64              `thrift:"5"`
+	Double float64            `thrift:"6"`
+	Str    string             `thrift:"7"`
+	Binary []byte             `thrift:"8"`
+	List   []int32            `thrift:"9"`
+	Set    map[string]bool    `thrift:"10,set"`
+	Map    map[int32]string   `thrift:"11"`
+	Struct *TestStruct2       `thrift:"12"`
+	Nested map[string][]int64 `thrift:"13"`
+}
+
+func TestJSONProtocol(t *testing.T) {
+	b := &bytes.Buffer{}
+	testProtocol(t, NewJSONProtocolReader(b), NewJSONProtocolWriter(b))
+}
+
+func TestJSONProtocolWireFormat(t *testing.T) {
+	b := &bytes.Buffer{}
+	w := NewJSONProtocolWriter(b)
+	if err := w.WriteMessageBegin("test", MessageTypeCall, 7); err != nil {
+		t.Fatal(err)
+	}
+	s := &jsonTestStruct{
+		Bool:   true,
+		I32:    -5,
+		Str:    "a\"b\n",
+		Binary: []byte{1, 2, 3, 4},
+		List:   []int32{1, 2},
+		Map:    map[int32]string{1: "x"},
+		Double: math.Inf(-1),
+	}
+	if err := EncodeStruct(w, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[1,"test",1,7,{"1":{"tf":1},"4":{"i32":-5},"6":{"dbl":"-Infinity"},"7":{"str":"a\"b\n"},"8":{"str":"AQIDBA"},"9":{"lst":["i32",2,1,2]},"11":{"map":["i32","str",1,{"1":"x"}]}}]`
+	if out := b.String(); out != expected {
+		t.Fatalf("JSONProtocol wrote\n%s\nexpected\n%s", out, expected)
+	}
+}
+
+func TestJSONProtocolRoundTrip(t *testing.T) {
+	s := &jsonTestStruct{
+		Bool:   true,
+		Byte:   -2,
+		I16:    1234,
+		I32:    -1 << 30,
+		I64:    1 << 60,
+		Double: -0.25,
+		Str:    "fooé☃\t\x01",
+		Binary: []byte{0, 255, 10},
+		List:   []int32{3, 2, 1},
+		Set:    map[string]bool{"a": true, "b": true},
+		Map:    map[int32]string{-1: "neg", 2: "pos"},
+		Struct: &TestStruct2{Str: "inner", Binary: []byte("bin")},
+		Nested: map[string][]int64{"x": {1, 2}, "y": {}},
+	}
+	b := &bytes.Buffer{}
+	if err := EncodeStruct(NewJSONProtocolWriter(b), s); err != nil {
+		t.Fatal(err)
+	}
+	s2 := &jsonTestStruct{}
+	if err := DecodeStruct(NewJSONProtocolReader(b), s2); err != nil {
+		t.Fatal(err)
+	}
+	// Empty lists decode as nil
+	s.Nested["y"] = nil
+	if !reflect.DeepEqual(s, s2) {
+		t.Fatalf("JSONProtocol round trip mismatch:\n%+v\n%+v", s, s2)
+	}
+}
+
+func TestJSONProtocolRead(t *testing.T) {
+	// Padded base64, whitespace, escaped unicode, and an unknown field to skip
+	in := ` [1, "m", 2, 3, {"2": {"str": "YWI="}, "1": {"str": "A😀"},
+		"3": {"rec": {"1": {"lst": ["str", 1, "x"]}}}}] `
+	r := NewJSONProtocolReader(bytes.NewBufferString(in))
+	name, mtype, seqid, err := r.ReadMessageBegin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "m" || mtype != MessageTypeReply || seqid != 3 {
+		t.Fatalf("ReadMessageBegin returned (%s, %d, %d)", name, mtype, seqid)
+	}
+	s := &TestStruct2{}
+	if err := DecodeStruct(r, s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Str != "A\U0001F600" || string(s.Binary) != "ab" {
+		t.Fatalf("Unexpected decoded struct %s", s)
+	}
+	if err := r.ReadMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJSONProtocolBadVersion(t *testing.T) {
+	r := NewJSONProtocolReader(bytes.NewBufferString(`[2,"m",1,1,{}]`))
+	if _, _, _, err := r.ReadMessageBegin(); err == nil {
+		t.Fatal("JSONProtocol.ReadMessageBegin didn't return an error for a bad version")
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"io"
+	"sync"
+)
+
+// PooledProtocolBuilder is a ProtocolBuilder that reuses readers and writers
+// returned to it with PutProtocolReader and PutProtocolWriter instead of
+// allocating new ones. It's safe for concurrent use.
+type PooledProtocolBuilder struct {
+	builder ProtocolBuilder
+	readers sync.Pool
+	writers sync.Pool
+}
+
+// NewPooledProtocolBuilder returns a PooledProtocolBuilder that creates
+// readers and writers using builder when none are available in the pool.
+func NewPooledProtocolBuilder(builder ProtocolBuilder) *PooledProtocolBuilder {
+	return &PooledProtocolBuilder{builder: builder}
+}
+
+func (p *PooledProtocolBuilder) NewProtocolReader(r io.Reader) ProtocolReader {
+	if pr, ok := p.readers.Get().(ResettableProtocolReader); ok {
+		pr.Reset(r)
+		return pr
+	}
+	return p.builder.NewProtocolReader(r)
+}
+
+func (p *PooledProtocolBuilder) NewProtocolWriter(w io.Writer) ProtocolWriter {
+	if pw, ok := p.writers.Get().(ResettableProtocolWriter); ok {
+		pw.Reset(w)
+		return pw
+	}
+	return p.builder.NewProtocolWriter(w)
+}
+
+// PutProtocolReader returns a reader created by the builder to the pool. The
+// reader must not be used after calling this. Readers that don't implement
+// ResettableProtocolReader are dropped.
+func (p *PooledProtocolBuilder) PutProtocolReader(r ProtocolReader) {
+	if pr, ok := r.(ResettableProtocolReader); ok {
+		// Don't hold on to the stream while in the pool
+		pr.Reset(nil)
+		p.readers.Put(pr)
+	}
+}
+
+// PutProtocolWriter returns a writer created by the builder to the pool. The
+// writer must not be used after calling this. Writers that don't implement
+// ResettableProtocolWriter are dropped.
+func (p *PooledProtocolBuilder) PutProtocolWriter(w ProtocolWriter) {
+	if pw, ok := w.(ResettableProtocolWriter); ok {
+		pw.Reset(nil)
+		p.writers.Put(pw)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProtocolReset(t *testing.T) {
+	builders := map[string]ProtocolBuilder{
+		"binary":  BinaryProtocol,
+		"compact": CompactProtocol,
+		"json":    JSONProtocol,
+		"text":    TextProtocol,
+	}
+	s := &TestStruct2{Str: "foo", Binary: []byte("bar")}
+	for name, p := range builders {
+		w, ok := p.NewProtocolWriter(nil).(ResettableProtocolWriter)
+		if !ok {
+			t.Fatalf("%s: writer doesn't implement ResettableProtocolWriter", name)
+		}
+		r, ok := p.NewProtocolReader(strings.NewReader("")).(ResettableProtocolReader)
+		if !ok {
+			t.Fatalf("%s: reader doesn't implement ResettableProtocolReader", name)
+		}
+		for i := 0; i < 2; i++ {
+			// Leave the writer and reader part way through a struct
+			w.Reset(&bytes.Buffer{})
+			w.WriteStructBegin("partial")
+			w.WriteFieldBegin("f", TypeList, 1)
+			w.WriteListBegin(TypeI32, 1)
+
+			b := &bytes.Buffer{}
+			w.Reset(b)
+			if err := EncodeStruct(w, s); err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			r.Reset(b)
+			s2 := &TestStruct2{}
+			if err := DecodeStruct(r, s2); err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			if !reflect.DeepEqual(s, s2) {
+				t.Fatalf("%s: expected %+v got %+v", name, s, s2)
+			}
+			r.Reset(bytes.NewReader([]byte{}))
+		}
+	}
+}
+
+func TestPooledProtocolBuilder(t *testing.T) {
+	p := NewPooledProtocolBuilder(CompactProtocol)
+	s := &TestStruct2{Str: "foo"}
+	for i := 0; i < 3; i++ {
+		b := &bytes.Buffer{}
+		w := p.NewProtocolWriter(b)
+		if err := EncodeStruct(w, s); err != nil {
+			t.Fatal(err)
+		}
+		p.PutProtocolWriter(w)
+		r := p.NewProtocolReader(b)
+		s2 := &TestStruct2{}
+		if err := DecodeStruct(r, s2); err != nil {
+			t.Fatal(err)
+		}
+		p.PutProtocolReader(r)
+		if s2.Str != "foo" {
+			t.Fatalf("Expected 'foo' instead of '%s'", s2.Str)
+		}
+	}
+}
+
+func BenchmarkPooledProtocolBuilderEncode(b *testing.B) {
+	p := NewPooledProtocolBuilder(CompactProtocol)
+	s := &TestStruct2{Str: "foo", Binary: []byte("bar")}
+	buf := &bytes.Buffer{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		w := p.NewProtocolWriter(buf)
+		EncodeStruct(w, s)
+		p.PutProtocolWriter(w)
+	}
+}
+
+// countingProtocolBuilder counts the readers and writers it builds.
+type countingProtocolBuilder struct {
+	ProtocolBuilder
+	readers, writers int
+}
+
+func (p *countingProtocolBuilder) NewProtocolReader(r io.Reader) ProtocolReader {
+	p.readers++
+	return p.ProtocolBuilder.NewProtocolReader(r)
+}
+
+func (p *countingProtocolBuilder) NewProtocolWriter(w io.Writer) ProtocolWriter {
+	p.writers++
+	return p.ProtocolBuilder.NewProtocolWriter(w)
+}
+
+func TestTransportReturnsPooledProtocols(t *testing.T) {
+	cb := &countingProtocolBuilder{ProtocolBuilder: BinaryProtocol}
+	p := NewPooledProtocolBuilder(cb)
+	s := &TestStruct2{Str: "foo", Binary: []byte("bar")}
+	const n = 10
+	for i := 0; i < n; i++ {
+		tr := NewTransport(&ClosingBuffer{&bytes.Buffer{}}, p)
+		if err := EncodeStruct(tr, s); err != nil {
+			t.Fatal(err)
+		}
+		if err := tr.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		s2 := &TestStruct2{}
+		if err := DecodeStruct(tr, s2); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(s, s2) {
+			t.Fatalf("Expected %+v got %+v", s, s2)
+		}
+		if err := tr.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// Closing twice doesn't return them to the pool twice
+		if err := tr.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// sync.Pool may drop some of them
+	if cb.readers >= n || cb.writers >= n {
+		t.Fatalf("Expected protocols to be reused but %d readers and %d writers were built", cb.readers, cb.writers)
+	}
+}
+
+func BenchmarkNewTransport(b *testing.B) {
+	b.ReportAllocs()
+	p := NewPooledProtocolBuilder(BinaryProtocol)
+	buf := &ClosingBuffer{&bytes.Buffer{}}
+	for i := 0; i < b.N; i++ {
+		tr := NewTransport(buf, p)
+		tr.WriteI32(1)
+		tr.Flush()
+		tr.ReadI32()
+		tr.Close()
+	}
+}
@@ -0,0 +1,618 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The simple JSON protocol is the equivalent of the Apache Thrift
+// TSimpleJSONProtocol. Structs are written as objects keyed by field name,
+// maps as objects, lists and sets as arrays, and messages as
+// [name, type, seqid, body]. It carries no type information so it can't be
+// read back without a schema, but the output is easy to read in logs.
+
+// UnknownFieldID is the field id returned by the simple JSON protocol reader
+// for fields that aren't part of the schema.
+const UnknownFieldID = math.MinInt16
+
+// SimpleJSONProtocol builds simple JSON protocol readers without a schema,
+// which infer the types of values and so only support ReadValue. Reading a
+// struct (e.g. with DecodeStruct) returns an error. Use
+// NewSimpleJSONProtocolReader to read structs with their schema.
+var SimpleJSONProtocol = NewProtocolBuilder(func(r io.Reader) ProtocolReader {
+	return NewSimpleJSONProtocolReader(r, nil)
+}, NewSimpleJSONProtocolWriter)
+
+type simpleJSONProtocolWriter struct {
+	jsonProtocolWriter
+}
+
+// NewSimpleJSONProtocolWriter returns a writer for the simple JSON protocol.
+func NewSimpleJSONProtocolWriter(w io.Writer) ProtocolWriter {
+	return &simpleJSONProtocolWriter{
+		jsonProtocolWriter{
+			w:     w,
+			stack: make([]jsonContext, 0, 8),
+			buf:   make([]byte, 0, 64),
+		},
+	}
+}
+
+func (p *simpleJSONProtocolWriter) WriteMessageBegin(name string, messageType byte, seqid int32) error {
+	if err := p.writeArrayBegin(); err != nil {
+		return err
+	}
+	if err := p.WriteString(name); err != nil {
+		return err
+	}
+	if err := p.writeInteger(int64(messageType)); err != nil {
+		return err
+	}
+	return p.writeInteger(int64(seqid))
+}
+
+func (p *simpleJSONProtocolWriter) WriteFieldBegin(name string, fieldType byte, id int16) error {
+	if name == "" {
+		name = strconv.Itoa(int(id))
+	}
+	return p.WriteString(name)
+}
+
+func (p *simpleJSONProtocolWriter) WriteFieldEnd() error {
+	return nil
+}
+
+func (p *simpleJSONProtocolWriter) WriteMapBegin(keyType byte, valueType byte, size int) error {
+	return p.writeObjectBegin()
+}
+
+func (p *simpleJSONProtocolWriter) WriteMapEnd() error {
+	return p.writeObjectEnd()
+}
+
+func (p *simpleJSONProtocolWriter) WriteListBegin(elementType byte, size int) error {
+	return p.writeArrayBegin()
+}
+
+func (p *simpleJSONProtocolWriter) WriteListEnd() error {
+	return p.writeArrayEnd()
+}
+
+func (p *simpleJSONProtocolWriter) WriteSetBegin(elementType byte, size int) error {
+	return p.writeArrayBegin()
+}
+
+func (p *simpleJSONProtocolWriter) WriteSetEnd() error {
+	return p.writeArrayEnd()
+}
+
+func (p *simpleJSONProtocolWriter) WriteBool(value bool) error {
+	escapeNum := p.begin()
+	if escapeNum {
+		p.buf = append(p.buf, '"')
+	}
+	p.buf = strconv.AppendBool(p.buf, value)
+	if escapeNum {
+		p.buf = append(p.buf, '"')
+	}
+	return p.flush()
+}
+
+// WriteBytes writes binary data as a padded base64 string (the same as
+// encoding/json does for []byte).
+func (p *simpleJSONProtocolWriter) WriteBytes(value []byte) error {
+	p.begin()
+	p.buf = append(p.buf, '"')
+	p.buf = append(p.buf, base64.StdEncoding.EncodeToString(value)...)
+	p.buf = append(p.buf, '"')
+	return p.flush()
+}
+
+// simpleJSONValue is a decoded JSON value along with the Thrift type and Go
+// type it's expected to be read as.
+type simpleJSONValue struct {
+	v     interface{}
+	ttype byte
+	rtype reflect.Type
+	id    int16
+}
+
+type simpleJSONFrame struct {
+	items    []simpleJSONValue
+	pos      int
+	isStruct bool
+	pending  bool // struct field header read but value not yet consumed
+}
+
+type simpleJSONProtocolReader struct {
+	dec    *json.Decoder
+	schema reflect.Type
+	stack  []*simpleJSONFrame
+}
+
+// NewSimpleJSONProtocolReader returns a reader for the simple JSON protocol.
+// Since the protocol doesn't include field ids or types the reader is guided
+// by schema, which should be a struct (or pointer to one) of the same type
+// as is passed to DecodeStruct. Each top-level value (or message body) is
+// read as that type. Fields not in the schema are returned with a field id
+// of UnknownFieldID and a type inferred from the JSON value so that they
+// can be skipped. A nil schema infers all types which is enough to use
+// ReadValue for debugging.
+func NewSimpleJSONProtocolReader(r io.Reader, schema interface{}) ProtocolReader {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var rt reflect.Type
+	if schema != nil {
+		rt = indirectType(reflect.TypeOf(schema))
+	}
+	return &simpleJSONProtocolReader{
+		dec:    dec,
+		schema: rt,
+	}
+}
+
+// Reset discards any state and switches the reader to read from r using
+// the same schema.
+func (p *simpleJSONProtocolReader) Reset(r io.Reader) {
+	p.dec = nil
+	if r != nil {
+		p.dec = json.NewDecoder(r)
+		p.dec.UseNumber()
+	}
+	p.stack = p.stack[:0]
+}
+
+func indirectType(rt reflect.Type) reflect.Type {
+	for rt != nil && rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	return rt
+}
+
+var decoderType = reflect.TypeOf((*Decoder)(nil)).Elem()
+
+// schemaType returns the Thrift type for a Go type, or false if the Go type
+// doesn't describe its own encoding.
+func schemaType(rt reflect.Type) (byte, bool) {
+	if rt == nil || rt.Implements(decoderType) || reflect.PtrTo(rt).Implements(decoderType) {
+		return 0, false
+	}
+	return fieldType(rt)
+}
+
+// inferType guesses the Thrift type of a value without a schema.
+func inferType(v interface{}) byte {
+	switch v := v.(type) {
+	case bool:
+		return TypeBool
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return TypeI64
+		}
+		return TypeDouble
+	case []interface{}:
+		return TypeList
+	case map[string]interface{}:
+		return TypeMap
+	}
+	return TypeString
+}
+
+// commonType returns the type shared by all items. Numbers that mix integers
+// and doubles are doubles, and anything else that's mixed is read as strings
+// since ReadString accepts any value.
+func commonType(items []simpleJSONValue, start, step int) byte {
+	if start >= len(items) {
+		return TypeString
+	}
+	t := items[start].ttype
+	for i := start + step; i < len(items); i += step {
+		switch it := items[i].ttype; {
+		case it == t:
+		case (it == TypeI64 && t == TypeDouble) || (it == TypeDouble && t == TypeI64):
+			t = TypeDouble
+		default:
+			return TypeString
+		}
+	}
+	return t
+}
+
+func newSimpleJSONValue(v interface{}, rt reflect.Type) simpleJSONValue {
+	rt = indirectType(rt)
+	ttype, ok := schemaType(rt)
+	if !ok {
+		rt = nil
+		ttype = inferType(v)
+	}
+	return simpleJSONValue{v: v, ttype: ttype, rtype: rt}
+}
+
+func (p *simpleJSONProtocolReader) error(msg string) error {
+	return ProtocolError{"SimpleJSONProtocol", msg}
+}
+
+func (p *simpleJSONProtocolReader) decode() (interface{}, error) {
+	var v interface{}
+	if err := p.dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// next returns the next value to be consumed, decoding a new top-level
+// value from the stream if there is no open container.
+func (p *simpleJSONProtocolReader) next() (simpleJSONValue, error) {
+	if len(p.stack) == 0 {
+		v, err := p.decode()
+		if err != nil {
+			return simpleJSONValue{}, err
+		}
+		if p.schema != nil {
+			return simpleJSONValue{v: v, ttype: TypeStruct, rtype: p.schema}, nil
+		}
+		return newSimpleJSONValue(v, nil), nil
+	}
+	f := p.stack[len(p.stack)-1]
+	if f.isStruct {
+		if !f.pending {
+			return simpleJSONValue{}, p.error("value read without a field header")
+		}
+		f.pending = false
+		return f.items[f.pos-1], nil
+	}
+	if f.pos >= len(f.items) {
+		return simpleJSONValue{}, p.error("read past end of container")
+	}
+	f.pos++
+	return f.items[f.pos-1], nil
+}
+
+func (p *simpleJSONProtocolReader) push(f *simpleJSONFrame) {
+	p.stack = append(p.stack, f)
+}
+
+func (p *simpleJSONProtocolReader) pop() error {
+	if len(p.stack) == 0 {
+		return p.error("unbalanced end of container")
+	}
+	p.stack = p.stack[:len(p.stack)-1]
+	return nil
+}
+
+func (p *simpleJSONProtocolReader) ReadMessageBegin() (name string, messageType byte, seqid int32, err error) {
+	v, err := p.decode()
+	if err != nil {
+		return
+	}
+	arr, ok := v.([]interface{})
+	if !ok || len(arr) != 4 {
+		err = p.error("expected message array of [name, type, seqid, body]")
+		return
+	}
+	if name, ok = arr[0].(string); !ok {
+		err = p.error("expected string message name")
+		return
+	}
+	mtype, err1 := toInt64(arr[1])
+	seq, err2 := toInt64(arr[2])
+	if err1 != nil || err2 != nil {
+		err = p.error("expected integer message type and seqid")
+		return
+	}
+	body := simpleJSONValue{v: arr[3], ttype: TypeStruct, rtype: p.schema}
+	if p.schema == nil {
+		body = newSimpleJSONValue(arr[3], nil)
+	}
+	p.push(&simpleJSONFrame{items: []simpleJSONValue{body}})
+	return name, byte(mtype), int32(seq), nil
+}
+
+func (p *simpleJSONProtocolReader) ReadMessageEnd() error {
+	return p.pop()
+}
+
+func (p *simpleJSONProtocolReader) ReadStructBegin() error {
+	if p.schema == nil {
+		// Every field would be unknown and the struct silently empty
+		return p.error("can't read a struct without a schema")
+	}
+	sv, err := p.next()
+	if err != nil {
+		return err
+	}
+	obj, ok := sv.v.(map[string]interface{})
+	if !ok {
+		return p.error(fmt.Sprintf("expected object for struct but found %T", sv.v))
+	}
+	f := &simpleJSONFrame{isStruct: true, items: make([]simpleJSONValue, 0, len(obj))}
+	used := make(map[string]bool, len(obj))
+	if rt := sv.rtype; rt != nil && rt.Kind() == reflect.Struct {
+		meta := encodeFields(rt)
+		for _, id := range meta.orderedIds {
+			ef := meta.fields[id]
+			key, v, ok := lookupField(obj, ef.name)
+			if !ok || v == nil {
+				continue
+			}
+			used[key] = true
+			item := newSimpleJSONValue(v, rt.Field(ef.i).Type)
+			if item.rtype != nil {
+				item.ttype = ef.fieldType
+			}
+			item.id = int16(id)
+			f.items = append(f.items, item)
+		}
+	}
+	keys := make([]string, 0, len(obj))
+	for k, v := range obj {
+		if !used[k] && v != nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		item := newSimpleJSONValue(obj[k], nil)
+		item.id = UnknownFieldID
+		f.items = append(f.items, item)
+	}
+	p.push(f)
+	return nil
+}
+
+func lookupField(obj map[string]interface{}, name string) (string, interface{}, bool) {
+	if v, ok := obj[name]; ok {
+		return name, v, true
+	}
+	for k, v := range obj {
+		if strings.EqualFold(k, name) {
+			return k, v, true
+		}
+	}
+	return "", nil, false
+}
+
+func (p *simpleJSONProtocolReader) ReadStructEnd() error {
+	return p.pop()
+}
+
+func (p *simpleJSONProtocolReader) ReadFieldBegin() (fieldType byte, id int16, err error) {
+	if len(p.stack) == 0 || !p.stack[len(p.stack)-1].isStruct {
+		return 0, 0, p.error("field read outside of struct")
+	}
+	f := p.stack[len(p.stack)-1]
+	if f.pos >= len(f.items) {
+		return TypeStop, 0, nil
+	}
+	item := f.items[f.pos]
+	f.pos++
+	f.pending = true
+	return item.ttype, item.id, nil
+}
+
+func (p *simpleJSONProtocolReader) ReadFieldEnd() error {
+	return nil
+}
+
+func (p *simpleJSONProtocolReader) ReadMapBegin() (keyType byte, valueType byte, size int, err error) {
+	sv, err := p.next()
+	if err != nil {
+		return
+	}
+	obj, ok := sv.v.(map[string]interface{})
+	if !ok {
+		err = p.error(fmt.Sprintf("expected object for map but found %T", sv.v))
+		return
+	}
+	var krt, vrt reflect.Type
+	if sv.rtype != nil && sv.rtype.Kind() == reflect.Map {
+		krt, vrt = sv.rtype.Key(), sv.rtype.Elem()
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	f := &simpleJSONFrame{items: make([]simpleJSONValue, 0, len(obj)*2)}
+	for _, k := range keys {
+		f.items = append(f.items, newSimpleJSONValue(k, krt), newSimpleJSONValue(obj[k], vrt))
+	}
+	keyType, valueType = commonType(f.items, 0, 2), commonType(f.items, 1, 2)
+	if kt, ok := schemaType(indirectType(krt)); ok {
+		keyType = kt
+	}
+	if vt, ok := schemaType(indirectType(vrt)); ok {
+		valueType = vt
+	}
+	p.push(f)
+	return keyType, valueType, len(obj), nil
+}
+
+func (p *simpleJSONProtocolReader) ReadMapEnd() error {
+	return p.pop()
+}
+
+func (p *simpleJSONProtocolReader) readCollectionBegin(set bool) (elementType byte, size int, err error) {
+	sv, err := p.next()
+	if err != nil {
+		return
+	}
+	arr, ok := sv.v.([]interface{})
+	if !ok {
+		err = p.error(fmt.Sprintf("expected array but found %T", sv.v))
+		return
+	}
+	var ert reflect.Type
+	if rt := sv.rtype; rt != nil {
+		switch rt.Kind() {
+		case reflect.Slice:
+			ert = rt.Elem()
+		case reflect.Map:
+			if set {
+				ert = rt.Key()
+			}
+		}
+	}
+	f := &simpleJSONFrame{items: make([]simpleJSONValue, len(arr))}
+	for i, v := range arr {
+		f.items[i] = newSimpleJSONValue(v, ert)
+	}
+	elementType = commonType(f.items, 0, 1)
+	if et, ok := schemaType(indirectType(ert)); ok {
+		elementType = et
+	}
+	p.push(f)
+	return elementType, len(arr), nil
+}
+
+func (p *simpleJSONProtocolReader) ReadListBegin() (elementType byte, size int, err error) {
+	return p.readCollectionBegin(false)
+}
+
+func (p *simpleJSONProtocolReader) ReadListEnd() error {
+	return p.pop()
+}
+
+func (p *simpleJSONProtocolReader) ReadSetBegin() (elementType byte, size int, err error) {
+	return p.readCollectionBegin(true)
+}
+
+func (p *simpleJSONProtocolReader) ReadSetEnd() error {
+	return p.pop()
+}
+
+// toInt64 converts numbers, and the quoted numbers used for map keys.
+func toInt64(v interface{}) (int64, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return v.Int64()
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("expected integer but found %T", v)
+}
+
+func (p *simpleJSONProtocolReader) readInteger() (int64, error) {
+	sv, err := p.next()
+	if err != nil {
+		return 0, err
+	}
+	i, err := toInt64(sv.v)
+	if err != nil {
+		return 0, p.error(err.Error())
+	}
+	return i, nil
+}
+
+func (p *simpleJSONProtocolReader) ReadBool() (bool, error) {
+	sv, err := p.next()
+	if err != nil {
+		return false, err
+	}
+	switch v := sv.v.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, p.error(err.Error())
+		}
+		return b, nil
+	case json.Number:
+		return v.String() != "0", nil
+	}
+	return false, p.error(fmt.Sprintf("expected bool but found %T", sv.v))
+}
+
+func (p *simpleJSONProtocolReader) ReadByte() (byte, error) {
+	v, err := p.readInteger()
+	return byte(v), err
+}
+
+func (p *simpleJSONProtocolReader) ReadI16() (int16, error) {
+	v, err := p.readInteger()
+	return int16(v), err
+}
+
+func (p *simpleJSONProtocolReader) ReadI32() (int32, error) {
+	v, err := p.readInteger()
+	return int32(v), err
+}
+
+func (p *simpleJSONProtocolReader) ReadI64() (int64, error) {
+	return p.readInteger()
+}
+
+func (p *simpleJSONProtocolReader) ReadDouble() (float64, error) {
+	sv, err := p.next()
+	if err != nil {
+		return 0, err
+	}
+	var s string
+	switch v := sv.v.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	default:
+		return 0, p.error(fmt.Sprintf("expected double but found %T", sv.v))
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, p.error(err.Error())
+	}
+	return f, nil
+}
+
+func (p *simpleJSONProtocolReader) ReadString() (string, error) {
+	sv, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	switch v := sv.v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	// Containers read as strings (e.g. elements of mixed type arrays)
+	// are returned as JSON.
+	b, err := json.Marshal(sv.v)
+	if err != nil {
+		return "", p.error(err.Error())
+	}
+	return string(b), nil
+}
+
+func (p *simpleJSONProtocolReader) ReadBytes() ([]byte, error) {
+	s, err := p.ReadString()
+	if err != nil || s == "" {
+		return nil, err
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		b, err = base64.RawStdEncoding.DecodeString(s)
+	}
+	if err != nil {
+		return nil, p.error("bad base64 value: " + err.Error())
+	}
+	return b, nil
+}
@@ -0,0 +1,183 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSimpleJSONProtocolWrite(t *testing.T) {
+	b := &bytes.Buffer{}
+	w := NewSimpleJSONProtocolWriter(b)
+	if err := w.WriteMessageBegin("test", MessageTypeCall, 7); err != nil {
+		t.Fatal(err)
+	}
+	s := &jsonTestStruct{
+		Bool:   true,
+		I32:    -5,
+		Str:    "a\"b",
+		Binary: []byte{1, 2, 3, 4},
+		List:   []int32{1, 2},
+		Map:    map[int32]string{1: "x"},
+		Struct: &TestStruct2{Str: "in"},
+	}
+	if err := EncodeStruct(w, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+	expected := `["test",1,7,{"Bool":true,"I32":-5,"Str":"a\"b","Binary":"AQIDBA==","List":[1,2],"Map":{"1":"x"},"Struct":{"Str":"in"}}]`
+	if out := b.String(); out != expected {
+		t.Fatalf("SimpleJSONProtocol wrote\n%s\nexpected\n%s", out, expected)
+	}
+}
+
+func TestSimpleJSONProtocolRoundTrip(t *testing.T) {
+	s := &jsonTestStruct{
+		Bool:   true,
+		Byte:   -2,
+		I16:    1234,
+		I32:    -1 << 30,
+		I64:    1 << 60,
+		Double: -0.25,
+		Str:    "foo\n",
+		Binary: []byte{0, 255, 10},
+		List:   []int32{3, 2, 1},
+		Set:    map[string]bool{"a": true, "b": true},
+		Map:    map[int32]string{-1: "neg", 2: "pos"},
+		Struct: &TestStruct2{Str: "inner", Binary: []byte("bin")},
+		Nested: map[string][]int64{"x": {1, 2}},
+	}
+	b := &bytes.Buffer{}
+	w := NewSimpleJSONProtocolWriter(b)
+	for i := 0; i < 2; i++ {
+		if err := EncodeStruct(w, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewSimpleJSONProtocolReader(b, s)
+	for i := 0; i < 2; i++ {
+		s2 := &jsonTestStruct{}
+		if err := DecodeStruct(r, s2); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(s, s2) {
+			t.Fatalf("SimpleJSONProtocol round trip mismatch:\n%+v\n%+v", s, s2)
+		}
+	}
+}
+
+func TestSimpleJSONProtocolReadMessage(t *testing.T) {
+	in := `["m", 2, 3, {"str": "x", "Unknown": {"a": [1, 2.5, "b"]}}]`
+	r := NewSimpleJSONProtocolReader(bytes.NewBufferString(in), TestStruct2{})
+	name, mtype, seqid, err := r.ReadMessageBegin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "m" || mtype != MessageTypeReply || seqid != 3 {
+		t.Fatalf("ReadMessageBegin returned (%s, %d, %d)", name, mtype, seqid)
+	}
+	s := &TestStruct2{}
+	if err := DecodeStruct(r, s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Str != "x" {
+		t.Fatalf("Expected Str of 'x' instead of '%s'", s.Str)
+	}
+	if err := r.ReadMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSimpleJSONProtocolReadValue(t *testing.T) {
+	r := NewSimpleJSONProtocolReader(bytes.NewBufferString(`{"a": [1, 2.5], "b": [3]} {"a": [1], "b": "c"}`), nil)
+	v, err := ReadValue(r, TypeMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[interface{}]interface{}{
+		"a": []interface{}{1.0, 2.5},
+		"b": []interface{}{int64(3)},
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Fatalf("ReadValue returned %+v instead of %+v", v, expected)
+	}
+
+	// Values of mixed types are read as strings
+	v, err = ReadValue(r, TypeMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = map[interface{}]interface{}{
+		"a": "[1]",
+		"b": "c",
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Fatalf("ReadValue returned %+v instead of %+v", v, expected)
+	}
+}
+
+func TestSimpleJSONProtocolBuilder(t *testing.T) {
+	b := &bytes.Buffer{}
+	w := SimpleJSONProtocol.NewProtocolWriter(b)
+	if err := w.WriteMessageBegin("test", MessageTypeCall, 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeStruct(w, &TestStruct2{Str: "in"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if out := b.String(); out != `["test",1,7,{"Str":"in"}]` {
+		t.Fatalf("SimpleJSONProtocol wrote %s", out)
+	}
+
+	r := SimpleJSONProtocol.NewProtocolReader(b)
+	name, mtype, seqid, err := r.ReadMessageBegin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "test" || mtype != MessageTypeCall || seqid != 7 {
+		t.Fatalf("ReadMessageBegin returned (%s, %d, %d)", name, mtype, seqid)
+	}
+	v, err := ReadValue(r, TypeMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[interface{}]interface{}{"Str": "in"}; !reflect.DeepEqual(v, expected) {
+		t.Fatalf("ReadValue returned %+v instead of %+v", v, expected)
+	}
+	if err := r.ReadMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a schema structs can't be read
+	r = SimpleJSONProtocol.NewProtocolReader(bytes.NewBufferString(`{"Str": "in"}`))
+	if err := DecodeStruct(r, &TestStruct2{}); err == nil {
+		t.Fatal("Expected DecodeStruct without a schema to fail")
+	}
+}
+
+func TestSimpleJSONTranscodeBinary(t *testing.T) {
+	b := &bytes.Buffer{}
+	if err := EncodeStruct(NewBinaryProtocolWriter(b, true), &TestStruct2{Str: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	s := &TestStruct2{}
+	if err := DecodeStruct(NewBinaryProtocolReader(b, false), s); err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	if err := EncodeStruct(NewSimpleJSONProtocolWriter(out), s); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != `{"Str":"foo"}` {
+		t.Fatalf("Unexpected JSON %s", out.String())
+	}
+}
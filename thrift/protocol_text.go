@@ -5,24 +5,75 @@
 package thrift
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 )
 
 var (
 	ErrUnimplemented = errors.New("thrift: unimplemented")
 )
 
+// The text protocol writes one call per line, indented by nesting level,
+// e.g. "FieldBegin(name, 8, 1)". It's meant for debugging and for golden
+// test fixtures that can be read and edited by hand. Strings are quoted
+// using Go syntax and the message sequence ID is written in hex.
+
 type textProtocolWriter struct {
 	w           io.Writer
 	indentation string
 }
 
+type textProtocolReader struct {
+	r       *bufio.Reader
+	br      *bufio.Reader // used when the reader isn't a *bufio.Reader
+	line    int
+	pending string // line that was peeked at but not consumed
+}
+
+var TextProtocol = NewProtocolBuilder(NewTextProtocolReader, NewTextProtocolWriter)
+
 func NewTextProtocolWriter(w io.Writer) ProtocolWriter {
 	return &textProtocolWriter{w: w}
 }
 
+func NewTextProtocolReader(r io.Reader) ProtocolReader {
+	p := &textProtocolReader{}
+	p.Reset(r)
+	return p
+}
+
+// Reset discards any state and switches the writer to write to w.
+func (p *textProtocolWriter) Reset(w io.Writer) {
+	p.w = w
+	p.indentation = ""
+}
+
+// Reset discards any state and switches the reader to read from r.
+func (p *textProtocolReader) Reset(r io.Reader) {
+	if br, ok := r.(*bufio.Reader); ok {
+		p.r = br
+	} else {
+		if p.br == nil {
+			p.br = bufio.NewReader(r)
+		} else {
+			p.br.Reset(r)
+		}
+		p.r = p.br
+	}
+	p.line = 0
+	p.pending = ""
+}
+
+// buffered returns the number of bytes read from the underlying reader
+// that haven't been used yet.
+func (p *textProtocolReader) buffered() int {
+	return p.r.Buffered() + len(p.pending)
+}
+
 func (p *textProtocolWriter) indent() {
 	p.indentation += "\t"
 }
@@ -134,12 +185,12 @@ func (p *textProtocolWriter) WriteI64(value int64) error {
 }
 
 func (p *textProtocolWriter) WriteDouble(value float64) error {
-	fmt.Fprintf(p.w, "%sDouble(%f)\n", p.indentation, value)
+	fmt.Fprintf(p.w, "%sDouble(%s)\n", p.indentation, strconv.FormatFloat(value, 'g', -1, 64))
 	return nil
 }
 
 func (p *textProtocolWriter) WriteString(value string) error {
-	fmt.Fprintf(p.w, "%sString(%s)\n", p.indentation, value)
+	fmt.Fprintf(p.w, "%sString(%q)\n", p.indentation, value)
 	return nil
 }
 
@@ -227,3 +278,365 @@ func (p *textProtocolWriter) ReadString() (string, error) {
 func (p *textProtocolWriter) ReadBytes() ([]byte, error) {
 	return nil, ErrUnimplemented
 }
+
+// readLine returns the next line that isn't blank or a comment (starting
+// with #) with the indentation removed.
+func (p *textProtocolReader) readLine() (string, error) {
+	if line := p.pending; line != "" {
+		p.pending = ""
+		return line, nil
+	}
+	for {
+		line, err := p.r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		p.line++
+		if line = strings.TrimSpace(line); line != "" && line[0] != '#' {
+			return line, nil
+		}
+	}
+}
+
+func (p *textProtocolReader) splitCall(line string) (string, string, error) {
+	open := strings.IndexByte(line, '(')
+	if open <= 0 || line[len(line)-1] != ')' {
+		return "", "", p.error(fmt.Sprintf("malformed line %q", line))
+	}
+	return line[:open], line[open+1 : len(line)-1], nil
+}
+
+// readCall reads the next line and returns its arguments if the call has
+// the expected name.
+func (p *textProtocolReader) readCall(name string) (string, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return "", err
+	}
+	call, args, err := p.splitCall(line)
+	if err != nil {
+		return "", err
+	}
+	if call != name {
+		return "", p.error(fmt.Sprintf("expected %s but found %s", name, call))
+	}
+	return args, nil
+}
+
+// peekCall returns the name of the next call without consuming it.
+func (p *textProtocolReader) peekCall() (string, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return "", err
+	}
+	call, _, err := p.splitCall(line)
+	if err != nil {
+		return "", err
+	}
+	p.pending = line
+	return call, nil
+}
+
+func (p *textProtocolReader) error(msg string) error {
+	return ProtocolError{"TextProtocol", fmt.Sprintf("line %d: %s", p.line, msg)}
+}
+
+// splitArgs splits off the last n comma separated arguments. Anything before
+// them is returned as the first element which allows names to contain commas.
+func splitArgs(args string, n int) []string {
+	out := make([]string, n+1)
+	for i := n; i > 0; i-- {
+		idx := strings.LastIndexByte(args, ',')
+		if idx < 0 {
+			out[i] = strings.TrimSpace(args)
+			args = ""
+			continue
+		}
+		out[i] = strings.TrimSpace(args[idx+1:])
+		args = args[:idx]
+	}
+	out[0] = strings.TrimSpace(args)
+	return out
+}
+
+func (p *textProtocolReader) parseInt(s string, base, bitSize int) (int64, error) {
+	v, err := strconv.ParseInt(s, base, bitSize)
+	if err != nil {
+		return 0, p.error(fmt.Sprintf("bad integer %q", s))
+	}
+	return v, nil
+}
+
+func (p *textProtocolReader) parseType(s string) (byte, error) {
+	v, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, p.error(fmt.Sprintf("bad type %q", s))
+	}
+	return byte(v), nil
+}
+
+// readInts reads a call whose arguments are all integers.
+func (p *textProtocolReader) readInts(name string, n, bitSize int) ([]int64, error) {
+	args, err := p.readCall(name)
+	if err != nil {
+		return nil, err
+	}
+	parts := splitArgs(args, n-1)
+	if n == 1 {
+		parts = parts[:1]
+	}
+	out := make([]int64, len(parts))
+	for i, s := range parts {
+		if out[i], err = p.parseInt(s, 10, bitSize); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (p *textProtocolReader) readEnd(name string) error {
+	args, err := p.readCall(name)
+	if err == nil && args != "" {
+		err = p.error(fmt.Sprintf("unexpected arguments to %s", name))
+	}
+	return err
+}
+
+func (p *textProtocolReader) ReadMessageBegin() (name string, messageType byte, seqid int32, err error) {
+	args, err := p.readCall("MessageBegin")
+	if err != nil {
+		return
+	}
+	parts := splitArgs(args, 2)
+	if messageType, err = p.parseType(parts[1]); err != nil {
+		return
+	}
+	seq, err := p.parseInt(parts[2], 16, 32)
+	return parts[0], messageType, int32(seq), err
+}
+
+func (p *textProtocolReader) ReadMessageEnd() error {
+	return p.readEnd("MessageEnd")
+}
+
+func (p *textProtocolReader) ReadStructBegin() error {
+	_, err := p.readCall("StructBegin")
+	return err
+}
+
+// ReadStructEnd also consumes a FieldStop if the caller didn't read it.
+func (p *textProtocolReader) ReadStructEnd() error {
+	if call, err := p.peekCall(); err != nil {
+		return err
+	} else if call == "FieldStop" {
+		if err := p.readEnd("FieldStop"); err != nil {
+			return err
+		}
+	}
+	return p.readEnd("StructEnd")
+}
+
+func (p *textProtocolReader) ReadFieldBegin() (fieldType byte, id int16, err error) {
+	if call, err := p.peekCall(); err != nil {
+		return 0, 0, err
+	} else if call == "FieldStop" {
+		return TypeStop, 0, p.readEnd("FieldStop")
+	}
+	args, err := p.readCall("FieldBegin")
+	if err != nil {
+		return
+	}
+	parts := splitArgs(args, 2)
+	if fieldType, err = p.parseType(parts[1]); err != nil {
+		return
+	}
+	v, err := p.parseInt(parts[2], 10, 16)
+	return fieldType, int16(v), err
+}
+
+func (p *textProtocolReader) ReadFieldEnd() error {
+	return p.readEnd("FieldEnd")
+}
+
+func (p *textProtocolReader) ReadMapBegin() (keyType byte, valueType byte, size int, err error) {
+	v, err := p.readInts("MapBegin", 3, 32)
+	if err != nil {
+		return
+	}
+	if v[0] < 0 || v[0] > 255 || v[1] < 0 || v[1] > 255 {
+		err = p.error("bad type in MapBegin")
+		return
+	}
+	return byte(v[0]), byte(v[1]), int(v[2]), nil
+}
+
+func (p *textProtocolReader) ReadMapEnd() error {
+	return p.readEnd("MapEnd")
+}
+
+func (p *textProtocolReader) readCollectionBegin(name string) (elementType byte, size int, err error) {
+	v, err := p.readInts(name, 2, 32)
+	if err != nil {
+		return
+	}
+	if v[0] < 0 || v[0] > 255 {
+		err = p.error("bad type in " + name)
+		return
+	}
+	return byte(v[0]), int(v[1]), nil
+}
+
+func (p *textProtocolReader) ReadListBegin() (elementType byte, size int, err error) {
+	return p.readCollectionBegin("ListBegin")
+}
+
+func (p *textProtocolReader) ReadListEnd() error {
+	return p.readEnd("ListEnd")
+}
+
+func (p *textProtocolReader) ReadSetBegin() (elementType byte, size int, err error) {
+	return p.readCollectionBegin("SetBegin")
+}
+
+func (p *textProtocolReader) ReadSetEnd() error {
+	return p.readEnd("SetEnd")
+}
+
+func (p *textProtocolReader) ReadBool() (bool, error) {
+	args, err := p.readCall("Bool")
+	if err != nil {
+		return false, err
+	}
+	v, err := strconv.ParseBool(args)
+	if err != nil {
+		return false, p.error(fmt.Sprintf("bad bool %q", args))
+	}
+	return v, nil
+}
+
+func (p *textProtocolReader) ReadByte() (byte, error) {
+	args, err := p.readCall("Byte")
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(args, 10, 8)
+	if err != nil {
+		return 0, p.error(fmt.Sprintf("bad byte %q", args))
+	}
+	return byte(v), nil
+}
+
+func (p *textProtocolReader) ReadI16() (int16, error) {
+	v, err := p.readInts("I16", 1, 16)
+	if err != nil {
+		return 0, err
+	}
+	return int16(v[0]), nil
+}
+
+func (p *textProtocolReader) ReadI32() (int32, error) {
+	v, err := p.readInts("I32", 1, 32)
+	if err != nil {
+		return 0, err
+	}
+	return int32(v[0]), nil
+}
+
+func (p *textProtocolReader) ReadI64() (int64, error) {
+	v, err := p.readInts("I64", 1, 64)
+	if err != nil {
+		return 0, err
+	}
+	return v[0], nil
+}
+
+func (p *textProtocolReader) ReadDouble() (float64, error) {
+	args, err := p.readCall("Double")
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseFloat(args, 64)
+	if err != nil {
+		return 0, p.error(fmt.Sprintf("bad double %q", args))
+	}
+	return v, nil
+}
+
+// ReadString reads a Go quoted string. Unquoted strings (as written by
+// older versions of the text protocol) are returned as is. Binary values
+// written with Bytes are also accepted since Thrift doesn't distinguish
+// them on the wire, which lets skipped fields be read either way.
+func (p *textProtocolReader) ReadString() (string, error) {
+	call, args, err := p.readStringCall()
+	if err != nil {
+		return "", err
+	}
+	if call == "Bytes" {
+		b, err := p.parseBytes(args)
+		return string(b), err
+	}
+	if !strings.HasPrefix(args, `"`) {
+		return args, nil
+	}
+	s, err := strconv.Unquote(args)
+	if err != nil {
+		return "", p.error(fmt.Sprintf("bad quoted string %s", args))
+	}
+	return s, nil
+}
+
+// ReadBytes reads a byte slice written as a list of decimal values, e.g.
+// "Bytes([1 2 3])". A quoted string is also accepted to make it easier to
+// edit fixtures by hand, as is a value written with String.
+func (p *textProtocolReader) ReadBytes() ([]byte, error) {
+	call, args, err := p.readStringCall()
+	if err != nil {
+		return nil, err
+	}
+	if call == "String" && !strings.HasPrefix(args, `"`) {
+		return []byte(args), nil
+	}
+	return p.parseBytes(args)
+}
+
+// readStringCall reads a String or Bytes call.
+func (p *textProtocolReader) readStringCall() (string, string, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return "", "", err
+	}
+	call, args, err := p.splitCall(line)
+	if err != nil {
+		return "", "", err
+	}
+	if call != "String" && call != "Bytes" {
+		return "", "", p.error(fmt.Sprintf("expected String or Bytes but found %s", call))
+	}
+	return call, args, nil
+}
+
+func (p *textProtocolReader) parseBytes(args string) ([]byte, error) {
+	if strings.HasPrefix(args, `"`) {
+		s, err := strconv.Unquote(args)
+		if err != nil {
+			return nil, p.error(fmt.Sprintf("bad quoted string %s", args))
+		}
+		return []byte(s), nil
+	}
+	if len(args) < 2 || args[0] != '[' || args[len(args)-1] != ']' {
+		return nil, p.error(fmt.Sprintf("bad bytes %q", args))
+	}
+	fields := strings.Fields(args[1 : len(args)-1])
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	b := make([]byte, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseUint(f, 10, 8)
+		if err != nil {
+			return nil, p.error(fmt.Sprintf("bad byte %q", f))
+		}
+		b[i] = byte(v)
+	}
+	return b, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type replayConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (c *replayConn) Close() error {
+	return nil
+}
+
+func TestTextProtocol(t *testing.T) {
+	b := &bytes.Buffer{}
+	testProtocol(t, NewTextProtocolReader(b), NewTextProtocolWriter(b))
+}
+
+func TestTextProtocolRoundTrip(t *testing.T) {
+	s := &jsonTestStruct{
+		Bool:   true,
+		Byte:   -2,
+		I16:    1234,
+		I32:    -1 << 30,
+		I64:    1 << 60,
+		Double: 0.1,
+		Str:    "multi\nline, (string)",
+		Binary: []byte{0, 255, 10},
+		List:   []int32{3, 2, 1},
+		Set:    map[string]bool{"a": true},
+		Map:    map[int32]string{-1: "neg", 2: "pos"},
+		Struct: &TestStruct2{Str: "inner", Binary: []byte("bin")},
+		Nested: map[string][]int64{"x": {1, 2}},
+	}
+	b := &bytes.Buffer{}
+	if err := EncodeStruct(NewTextProtocolWriter(b), s); err != nil {
+		t.Fatal(err)
+	}
+	s2 := &jsonTestStruct{}
+	if err := DecodeStruct(NewTextProtocolReader(b), s2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s, s2) {
+		t.Fatalf("TextProtocol round trip mismatch:\n%+v\n%+v", s, s2)
+	}
+}
+
+func TestTextProtocolReplay(t *testing.T) {
+	once.Do(startServer)
+
+	fixture := `
+# A hand written request
+MessageBegin(success, 1, 0000002a)
+	StructBegin(TestRequest)
+		FieldBegin(Value, 8, 1)
+			I32(123)
+		FieldEnd()
+		FieldStop()
+	StructEnd()
+MessageEnd()
+`
+	expected := `MessageBegin(success, 2, 0000002a)
+	StructBegin(TestResponse)
+		FieldBegin(Value, 8, 0)
+			I32(123)
+		FieldEnd()
+		FieldStop()
+	StructEnd()
+MessageEnd()
+`
+	out := &bytes.Buffer{}
+	ServeConn(NewTransport(&replayConn{strings.NewReader(fixture), out}, TextProtocol))
+	if out.String() != expected {
+		t.Fatalf("TextProtocol replay wrote\n%s\nexpected\n%s", out.String(), expected)
+	}
+}
+
+func TestTextProtocolBadLine(t *testing.T) {
+	r := NewTextProtocolReader(strings.NewReader("I32(123)\nI16(1)\n"))
+	if _, err := r.ReadI32(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadI32(); err == nil {
+		t.Fatal("TextProtocol.ReadI32 didn't return an error for an I16 line")
+	} else if !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("Expected error to include the line number: %s", err)
+	}
+}
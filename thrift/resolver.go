@@ -0,0 +1,48 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// Resolver provides the addresses of the servers for a Balancer. Resolve
+// is called when the balancer is created and then periodically to pick up
+// changes.
+type Resolver interface {
+	Resolve() ([]string, error)
+}
+
+// StaticResolver is a Resolver for a fixed list of addresses.
+type StaticResolver []string
+
+func (r StaticResolver) Resolve() ([]string, error) {
+	return r, nil
+}
+
+// FileResolver is a Resolver that reads addresses from the named file, one
+// per line. Blank lines and lines starting with # are ignored. The file is
+// read on every call to Resolve so it can be updated in place.
+type FileResolver string
+
+func (r FileResolver) Resolve() ([]string, error) {
+	f, err := os.Open(string(r))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var addrs []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+	return addrs, s.Err()
+}
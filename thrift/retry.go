@@ -0,0 +1,182 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"reflect"
+	"time"
+)
+
+// RPCClient is the interface used by generated clients to make calls. It's
+// implemented by *rpc.Client, Pool, Balancer, and RetryClient.
+type RPCClient interface {
+	Call(method string, request interface{}, response interface{}) error
+}
+
+// ContextRPCClient is the interface used by clients generated with
+// -go.context. It's implemented by ContextClient and RetryContextClient.
+type ContextRPCClient interface {
+	Call(ctx context.Context, method string, request interface{}, response interface{}) error
+}
+
+// Defaults for RetryPolicy.
+const (
+	DefaultRetryAttempts       = 3
+	DefaultRetryInitialBackoff = 10 * time.Millisecond
+	DefaultRetryMaxBackoff     = time.Second
+)
+
+// RetryPolicy controls which calls are retried and how often.
+type RetryPolicy struct {
+	// Idempotent is the set of methods that may be retried. The generator
+	// creates this table for each service (e.g. FooIdempotentMethods) from
+	// methods annotated with (idempotent = "true").
+	Idempotent map[string]bool
+	// MaxAttempts is the maximum number of times a call is made including
+	// the first. It defaults to DefaultRetryAttempts.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. It's doubled for
+	// every retry up to MaxBackoff with random jitter. They default to
+	// DefaultRetryInitialBackoff and DefaultRetryMaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// RetryableExceptions are the ApplicationException types that are
+	// retried. It defaults to ExceptionInternalError.
+	RetryableExceptions []int32
+}
+
+func (p *RetryPolicy) setDefaults() {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DefaultRetryInitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	if p.RetryableExceptions == nil {
+		p.RetryableExceptions = []int32{ExceptionInternalError}
+	}
+}
+
+// backoff returns the delay before the given retry (starting at 1).
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	// Jitter between half and all of the delay
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryable returns true if a call to an idempotent method that returned
+// err may be retried. Only connection and dial errors and
+// RetryableExceptions are. Exceptions declared in the IDL are returned in
+// the response rather than as an error so they're never retried.
+func (p *RetryPolicy) retryable(err error) bool {
+	if t, ok := exceptionType(err); ok {
+		return p.retryableException(t)
+	}
+	return isConnectionError(err)
+}
+
+// isConnectionError returns true if err is from connecting or from the
+// connection failing during a call. Other errors such as protocol errors,
+// ErrFrameTooBig, or rpc.ErrShutdown would fail the same way again.
+func isConnectionError(err error) bool {
+	switch err {
+	case nil, context.Canceled, context.DeadlineExceeded:
+		// DeadlineExceeded implements net.Error
+		return false
+	case io.EOF, io.ErrUnexpectedEOF, io.ErrClosedPipe:
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+func (p *RetryPolicy) retryableException(typ int32) bool {
+	for _, t := range p.RetryableExceptions {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// resetResponse zeroes a response struct so that nothing from a failed
+// attempt is left in it.
+func resetResponse(res interface{}) {
+	if v := reflect.ValueOf(res); v.Kind() == reflect.Ptr && !v.IsNil() {
+		v.Elem().Set(reflect.Zero(v.Elem().Type()))
+	}
+}
+
+// RetryClient is an RPCClient that retries failed calls to idempotent
+// methods with exponential backoff. Calls to other methods are made once.
+type RetryClient struct {
+	client RPCClient
+	policy RetryPolicy
+}
+
+// NewRetryClient returns a RetryClient making calls with client.
+func NewRetryClient(client RPCClient, policy RetryPolicy) *RetryClient {
+	policy.setDefaults()
+	return &RetryClient{client: client, policy: policy}
+}
+
+func (c *RetryClient) Call(method string, request interface{}, response interface{}) error {
+	err := c.client.Call(method, request, response)
+	if !c.policy.Idempotent[method] {
+		return err
+	}
+	for retry := 1; retry < c.policy.MaxAttempts && c.policy.retryable(err); retry++ {
+		time.Sleep(c.policy.backoff(retry))
+		resetResponse(response)
+		err = c.client.Call(method, request, response)
+	}
+	return err
+}
+
+// RetryContextClient is a ContextRPCClient that retries failed calls to
+// idempotent methods with exponential backoff until the context is done.
+// Calls to other methods are made once.
+type RetryContextClient struct {
+	client ContextRPCClient
+	policy RetryPolicy
+}
+
+// NewRetryContextClient returns a RetryContextClient making calls with client.
+func NewRetryContextClient(client ContextRPCClient, policy RetryPolicy) *RetryContextClient {
+	policy.setDefaults()
+	return &RetryContextClient{client: client, policy: policy}
+}
+
+func (c *RetryContextClient) Call(ctx context.Context, method string, request interface{}, response interface{}) error {
+	err := c.client.Call(ctx, method, request, response)
+	if !c.policy.Idempotent[method] {
+		return err
+	}
+	for retry := 1; retry < c.policy.MaxAttempts && c.policy.retryable(err); retry++ {
+		t := time.NewTimer(c.policy.backoff(retry))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		resetResponse(response)
+		err = c.client.Call(ctx, method, request, response)
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/rpc"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyClient returns the errors in order before succeeding.
+type flakyClient struct {
+	errs  []error
+	calls int
+}
+
+func (c *flakyClient) Call(method string, request interface{}, response interface{}) error {
+	c.calls++
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return err
+	}
+	response.(*TestResponse).Value = request.(*TestRequest).Value
+	return nil
+}
+
+// flakyContextClient is a ContextRPCClient returning the errors of a
+// flakyClient.
+type flakyContextClient struct {
+	flakyClient
+}
+
+func (c *flakyContextClient) Call(ctx context.Context, method string, request interface{}, response interface{}) error {
+	return c.flakyClient.Call(method, request, response)
+}
+
+func TestRetryClient(t *testing.T) {
+	policy := RetryPolicy{
+		Idempotent:     map[string]bool{"get": true},
+		InitialBackoff: time.Millisecond,
+	}
+	cases := []struct {
+		method string
+		errs   []error
+		calls  int
+		fail   bool
+	}{
+		{"get", nil, 1, false},
+		{"get", []error{io.EOF, io.ErrUnexpectedEOF}, 3, false},
+		{"get", []error{io.EOF, io.EOF, io.EOF}, 3, true},
+		{"get", []error{rpc.ServerError("Internal Error: oops")}, 2, false},
+		{"get", []error{&ApplicationException{"oops", ExceptionInternalError}}, 2, false},
+		{"get", []error{rpc.ServerError("Unknown Method: get")}, 1, true},
+		{"get", []error{rpc.ServerError("Unknown Exception: Internal Error: oops")}, 1, true},
+		{"get", []error{ErrPoolClosed}, 1, true},
+		{"get", []error{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}, 2, false},
+		{"get", []error{io.EOF, rpc.ErrShutdown}, 2, true},
+		{"get", []error{rpc.ErrShutdown}, 1, true},
+		{"get", []error{ProtocolError{"binary", "bad"}}, 1, true},
+		{"get", []error{ErrFrameTooBig{2, 1}}, 1, true},
+		{"get", []error{&MissingRequiredField{"S", "f"}}, 1, true},
+		{"get", []error{context.DeadlineExceeded}, 1, true},
+		{"put", []error{io.EOF}, 1, true},
+	}
+	for i, c := range cases {
+		fc := &flakyClient{errs: c.errs}
+		res := &TestResponse{}
+		err := NewRetryClient(fc, policy).Call(c.method, &TestRequest{123}, res)
+		if c.fail && err == nil {
+			t.Errorf("%d. expected an error", i)
+		} else if !c.fail && (err != nil || res.Value != 123) {
+			t.Errorf("%d. expected success instead of %+v (%d)", i, err, res.Value)
+		}
+		if fc.calls != c.calls {
+			t.Errorf("%d. expected %d calls instead of %d", i, c.calls, fc.calls)
+		}
+	}
+}
+
+func TestRetryContextClient(t *testing.T) {
+	policy := RetryPolicy{
+		Idempotent:     map[string]bool{"get": true},
+		InitialBackoff: time.Millisecond,
+	}
+	ctx := context.Background()
+	internal := &ApplicationException{"oops", ExceptionInternalError}
+
+	// Internal errors are retried for idempotent methods
+	fc := &flakyContextClient{flakyClient{errs: []error{internal, internal, internal}}}
+	if err := NewRetryContextClient(fc, policy).Call(ctx, "get", &TestRequest{123}, &TestResponse{}); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if fc.calls != DefaultRetryAttempts {
+		t.Fatalf("Expected %d calls instead of %d", DefaultRetryAttempts, fc.calls)
+	}
+
+	// Other exceptions aren't
+	fc = &flakyContextClient{flakyClient{errs: []error{&ApplicationException{"get", ExceptionUnknownMethod}}}}
+	if err := NewRetryContextClient(fc, policy).Call(ctx, "get", &TestRequest{123}, &TestResponse{}); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if fc.calls != 1 {
+		t.Fatalf("Expected 1 call instead of %d", fc.calls)
+	}
+
+	// A done context stops retries
+	fc = &flakyContextClient{flakyClient{errs: []error{internal, internal}}}
+	policy.InitialBackoff = time.Hour
+	ctx2, cancel := context.WithCancel(ctx)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if err := NewRetryContextClient(fc, policy).Call(ctx2, "get", &TestRequest{123}, &TestResponse{}); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if fc.calls != 1 {
+		t.Fatalf("Expected 1 call instead of %d", fc.calls)
+	}
+}
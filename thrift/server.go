@@ -13,10 +13,14 @@ import (
 )
 
 type serverCodec struct {
-	conn       Transport
-	nameCache  map[string]string // incoming name -> registered name
-	methodName map[uint64]string // sequence ID -> method name
-	mu         sync.Mutex
+	conn           Transport
+	defaultService string
+	nameCache      map[string]string // incoming name -> registered name
+	methodName     map[uint64]string // sequence ID -> method name
+	oneway         map[uint64]bool   // sequence IDs of oneway requests
+	lastSeq        uint64            // sequence ID of the request being read
+	started        bool              // a request has been read
+	mu             sync.Mutex
 }
 
 // ServeConn runs the Thrift RPC server on a single connection. ServeConn blocks,
@@ -27,36 +31,120 @@ func ServeConn(conn Transport) {
 }
 
 // NewServerCodec returns a new rpc.ServerCodec using Thrift RPC on conn using the specified protocol.
+// Requests without a service prefix are routed to the net/rpc service
+// registered with the name "Thrift".
 func NewServerCodec(conn Transport) rpc.ServerCodec {
+	return NewMultiplexedServerCodec(conn, "Thrift")
+}
+
+// NewMultiplexedServerCodec returns a new rpc.ServerCodec that routes requests
+// with a service prefix (e.g. "Calculator:add" as sent by TMultiplexedProtocol
+// or NewMultiplexedTransport) to the net/rpc service registered with that name.
+// Requests named with a net/rpc service and method (e.g. "Calculator.add") go
+// to that service and other requests are routed to defaultService.
+func NewMultiplexedServerCodec(conn Transport, defaultService string) rpc.ServerCodec {
 	return &serverCodec{
-		conn:       conn,
-		nameCache:  make(map[string]string, 8),
-		methodName: make(map[uint64]string, 8),
+		conn:           conn,
+		defaultService: defaultService,
+		nameCache:      make(map[string]string, 8),
+		methodName:     make(map[uint64]string, 8),
+		oneway:         make(map[uint64]bool),
 	}
 }
 
+type limitedServerCodec struct {
+	rpc.ServerCodec
+	sem chan struct{}
+}
+
+// NewLimitedServerCodec wraps codec so that at most maxConcurrency requests
+// from the connection are handled at once. net/rpc handles every request in
+// a new goroutine so without a limit a client can start any number of them.
+// Once the limit is reached no more requests are read from the connection
+// until a response is written.
+func NewLimitedServerCodec(codec rpc.ServerCodec, maxConcurrency int) rpc.ServerCodec {
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+	return &limitedServerCodec{
+		ServerCodec: codec,
+		sem:         make(chan struct{}, maxConcurrency),
+	}
+}
+
+func (c *limitedServerCodec) ReadRequestHeader(request *rpc.Request) error {
+	c.sem <- struct{}{}
+	err := c.ServerCodec.ReadRequestHeader(request)
+	if err != nil {
+		// net/rpc stops reading and doesn't write a response
+		<-c.sem
+	}
+	return err
+}
+
+func (c *limitedServerCodec) ReadHeaders() map[string]string {
+	return readCodecHeaders(c.ServerCodec)
+}
+
+func (c *limitedServerCodec) SetWriteHeader(key, value string) {
+	setCodecHeader(c.ServerCodec, key, value)
+}
+
+func (c *limitedServerCodec) WriteResponse(response *rpc.Response, thriftStruct interface{}) error {
+	// net/rpc writes a response (possibly an error) for every request
+	// header read, including oneway requests
+	defer func() { <-c.sem }()
+	return c.ServerCodec.WriteResponse(response, thriftStruct)
+}
+
 func (c *serverCodec) ReadRequestHeader(request *rpc.Request) error {
 	name, messageType, seq, err := c.conn.ReadMessageBegin()
 	if err != nil {
 		return err
 	}
-	if messageType != MessageTypeCall { // Currently don't support one way
-		return errors.New("thrift: expected Call message type")
+	if !c.started && name == TTwitterUpgradeMethod && messageType == MessageTypeCall {
+		if _, ok := c.conn.(HeaderReadWriter); !ok {
+			// No requests are being handled yet so conn can be replaced
+			tt, err := acceptTTwitter(c.conn, seq)
+			if err != nil {
+				return err
+			}
+			c.conn = tt
+			if name, messageType, seq, err = c.conn.ReadMessageBegin(); err != nil {
+				return err
+			}
+		}
+	}
+	c.started = true
+	if messageType != MessageTypeCall && messageType != MessageTypeOneway {
+		return errors.New("thrift: expected Call or Oneway message type")
 	}
 
 	// TODO: should use a limited size cache for the nameCache to avoid a possible
 	//       memory overflow from nefarious or broken clients
 	newName := c.nameCache[name]
 	if newName == "" {
-		newName = CamelCase(name)
-		if !strings.ContainsRune(newName, '.') {
-			newName = "Thrift." + newName
+		service, method := splitMultiplexedName(name)
+		switch {
+		case service != "":
+			newName = service + "." + CamelCase(method)
+		case strings.ContainsRune(method, '.'):
+			// Already a net/rpc service and method (e.g. "Foo.bar")
+			newName = CamelCase(method)
+		default:
+			newName = c.defaultService + "." + CamelCase(method)
 		}
 		c.nameCache[name] = newName
 	}
 
+	// Replies from multiplexed servers don't include the service prefix
+	_, replyName := splitMultiplexedName(name)
 	c.mu.Lock()
-	c.methodName[uint64(seq)] = name
+	c.methodName[uint64(seq)] = replyName
+	if messageType == MessageTypeOneway {
+		c.oneway[uint64(seq)] = true
+	}
+	c.lastSeq = uint64(seq)
 	c.mu.Unlock()
 
 	request.ServiceMethod = newName
@@ -74,6 +162,12 @@ func (c *serverCodec) ReadRequestBody(thriftStruct interface{}) error {
 		if err := DecodeStruct(c.conn, thriftStruct); err != nil {
 			return err
 		}
+		// Older clients send oneway requests with the Call message type
+		if o, ok := thriftStruct.(oneway); ok && o.Oneway() {
+			c.mu.Lock()
+			c.oneway[c.lastSeq] = true
+			c.mu.Unlock()
+		}
 	}
 	return c.conn.ReadMessageEnd()
 }
@@ -82,28 +176,49 @@ func (c *serverCodec) WriteResponse(response *rpc.Response, thriftStruct interfa
 	c.mu.Lock()
 	methodName := c.methodName[response.Seq]
 	delete(c.methodName, response.Seq)
+	ow := c.oneway[response.Seq]
+	delete(c.oneway, response.Seq)
 	c.mu.Unlock()
+	if ow {
+		// The client isn't expecting a reply
+		return nil
+	}
 	response.ServiceMethod = methodName
 
 	mtype := byte(MessageTypeReply)
 	if response.Error != "" {
 		mtype = MessageTypeException
-		etype := int32(ExceptionInternalError)
-		if strings.HasPrefix(response.Error, "rpc: can't find") {
-			etype = ExceptionUnknownMethod
+		// Exceptions returned by interceptors are written as they are
+		if _, ok := thriftStruct.(*ApplicationException); !ok {
+			thriftStruct = serverException(response.Error)
 		}
-		thriftStruct = &ApplicationException{response.Error, etype}
-	}
-	if err := c.conn.WriteMessageBegin(response.ServiceMethod, mtype, int32(response.Seq)); err != nil {
-		return err
 	}
-	if err := EncodeStruct(c.conn, thriftStruct); err != nil {
-		return err
+	return writeMessage(c.conn, response.ServiceMethod, mtype, int32(response.Seq), thriftStruct)
+}
+
+// serverException returns the exception sent for a net/rpc response error.
+func serverException(msg string) *ApplicationException {
+	if strings.HasPrefix(msg, "rpc: can't find") {
+		return &ApplicationException{msg, ExceptionUnknownMethod}
 	}
-	if err := c.conn.WriteMessageEnd(); err != nil {
-		return err
+	// net/rpc only passes along the string of an ApplicationException
+	// returned by the handler so recover its type from it
+	if t, _ := exceptionType(rpc.ServerError(msg)); t != ExceptionUnknown {
+		return &ApplicationException{msg[strings.Index(msg, ": ")+2:], t}
 	}
-	return c.conn.Flush()
+	return &ApplicationException{msg, ExceptionInternalError}
+}
+
+// ReadHeaders returns the headers of the last request read if the
+// transport carries them.
+func (c *serverCodec) ReadHeaders() map[string]string {
+	return readCodecHeaders(c.conn)
+}
+
+// SetWriteHeader sets a header to send with the next response if the
+// transport carries them.
+func (c *serverCodec) SetWriteHeader(key, value string) {
+	setCodecHeader(c.conn, key, value)
 }
 
 func (c *serverCodec) Close() error {
@@ -0,0 +1,483 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by Server.Serve and Server.ServeTransport
+// after the server has been shut down or closed.
+var ErrServerClosed = errors.New("thrift: server closed")
+
+// DefaultHandshakeTimeout is the default time a Server allows for the TLS
+// handshake of a connection.
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// Server is a Thrift RPC server that dispatches requests to a Processor
+// without using net/rpc. Method names are passed to the processor as sent
+// by the client.
+type Server struct {
+	processor        Processor
+	newTransport     func(io.ReadWriteCloser) (Transport, error)
+	interceptors     []ServerInterceptor
+	handler          ServerHandler
+	concurrency      int
+	handshakeTimeout time.Duration
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*serverConn]struct{}
+	closing   bool
+}
+
+type serverConn struct {
+	rwc     io.Closer // the connection until t has been created
+	t       Transport
+	writeMu sync.Mutex // serializes replies
+	active  int        // number of requests being handled (guarded by Server.mu)
+	closed  bool       // guarded by Server.mu
+}
+
+// close closes the connection. Server.mu must be held.
+func (c *serverConn) close() {
+	c.closed = true
+	if c.t != nil {
+		c.t.Close()
+	} else {
+		c.rwc.Close()
+	}
+}
+
+// serverRequest is a request that's been read from a connection.
+type serverRequest struct {
+	name      string
+	replyName string
+	seq       int32
+	req       interface{}
+	oneway    bool
+	md        map[string]string     // headers received with the request
+	exc       *ApplicationException // sent instead of processing the request
+}
+
+// NewServer returns a Server dispatching requests to processor.
+// newTransport is used to create a Transport for every accepted connection.
+// If it's nil then NewSniffingTransport is used which accepts any of the
+// supported framing and protocols.
+func NewServer(processor Processor, newTransport func(io.ReadWriteCloser) (Transport, error)) *Server {
+	if newTransport == nil {
+		newTransport = func(rwc io.ReadWriteCloser) (Transport, error) {
+			return NewSniffingTransport(rwc, 0)
+		}
+	}
+	s := &Server{
+		processor:    processor,
+		newTransport: newTransport,
+		listeners:    make(map[net.Listener]struct{}),
+		conns:        make(map[*serverConn]struct{}),
+		concurrency:  1,
+
+		handshakeTimeout: DefaultHandshakeTimeout,
+	}
+	s.handler = s.invoke
+	return s
+}
+
+// SetConcurrency sets the maximum number of requests from one connection
+// that are handled concurrently. Replies are written in the order the
+// requests finish so clients must match them by sequence ID (e.g. using
+// NewPipelinedClientCodec). Once n requests are being handled no more are
+// read from the connection until one finishes. The default of 1 handles
+// requests in order. HeaderTransport connections are always handled in
+// order since replies use the protocol and headers of the last request
+// read. It must be called before the server starts serving.
+func (s *Server) SetConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	s.concurrency = n
+}
+
+// SetHandshakeTimeout sets the time allowed for the TLS handshake of a
+// connection. It defaults to DefaultHandshakeTimeout and zero means no
+// limit. It must be called
+// before the server starts serving.
+func (s *Server) SetHandshakeTimeout(d time.Duration) {
+	s.handshakeTimeout = d
+}
+
+// Use adds interceptors that are called in order for every request. It
+// must be called before the server starts serving.
+func (s *Server) Use(interceptors ...ServerInterceptor) {
+	s.interceptors = append(s.interceptors, interceptors...)
+	s.handler = chainServer(s.interceptors, s.invoke)
+}
+
+// Serve accepts connections on l, serving each in a new goroutine. It
+// blocks until l returns a permanent error or the server is shut down in
+// which case it returns ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosing() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(rwc io.ReadWriteCloser) {
+	// Track the connection before the handshake and creating the transport
+	// (which may wait for the first request) so Shutdown can close it
+	c := &serverConn{rwc: rwc}
+	if !s.addConn(c) {
+		return
+	}
+	ctx := context.Background()
+	if tc, ok := rwc.(*tls.Conn); ok {
+		// Handshake now so the peer's identity is known before any request
+		if s.handshakeTimeout > 0 {
+			tc.SetDeadline(time.Now().Add(s.handshakeTimeout))
+		}
+		err := tc.Handshake()
+		tc.SetDeadline(time.Time{})
+		if err != nil {
+			s.removeConn(c)
+			return
+		}
+		ctx = withTLSState(ctx, tc.ConnectionState())
+	}
+	t, err := s.newTransport(rwc)
+	if err != nil {
+		s.removeConn(c)
+		return
+	}
+	s.mu.Lock()
+	c.t = t
+	closed := c.closed
+	s.mu.Unlock()
+	if closed {
+		s.removeConn(c)
+		return
+	}
+	s.serveTransport(ctx, c)
+}
+
+// ServeTransport serves requests on t until the client hangs up or the
+// server is shut down. It blocks so is typically called in a go statement.
+func (s *Server) ServeTransport(t Transport) error {
+	c := &serverConn{t: t}
+	if !s.addConn(c) {
+		return ErrServerClosed
+	}
+	return s.serveTransport(context.Background(), c)
+}
+
+// addConn tracks c. If the server is closing it closes c and returns false.
+func (s *Server) addConn(c *serverConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		c.close()
+		return false
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+// removeConn closes c and stops tracking it.
+func (s *Server) removeConn(c *serverConn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	c.close()
+	s.mu.Unlock()
+}
+
+// serveTransport serves requests on the transport of c, which must have
+// been added with addConn, with contexts derived from ctx.
+func (s *Server) serveTransport(ctx context.Context, c *serverConn) error {
+	t := c.t
+	concurrency := s.concurrency
+	if _, ok := t.(*HeaderTransport); ok {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		s.removeConn(c)
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for first := true; ; first = false {
+		// Don't read another request until there's room to handle it
+		sem <- struct{}{}
+		if s.isClosing() {
+			return ErrServerClosed
+		}
+		name, mtype, seq, err := t.ReadMessageBegin()
+		if err != nil {
+			if s.isClosing() {
+				return ErrServerClosed
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		// Mark the request active before reading the rest of it so that
+		// Shutdown waits for it
+		if !s.startRequest(c) {
+			return ErrServerClosed
+		}
+		if first && name == TTwitterUpgradeMethod && mtype == MessageTypeCall {
+			if _, ok := t.(HeaderReadWriter); !ok {
+				// No requests are being handled yet so t can be replaced
+				tt, err := acceptTTwitter(t, seq)
+				if err != nil {
+					return err
+				}
+				s.mu.Lock()
+				c.t = tt
+				s.mu.Unlock()
+				t = tt
+				s.setActive(c, -1)
+				<-sem
+				continue
+			}
+		}
+		r, err := s.readRequest(t, name, mtype, seq)
+		if err != nil {
+			return err
+		}
+		if concurrency == 1 {
+			err := s.serveRequest(ctx, c, r)
+			<-sem
+			s.setActive(c, -1)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.serveRequest(ctx, c, r); err != nil {
+				// Stop reading from the connection
+				t.Close()
+			}
+			<-sem
+			s.setActive(c, -1)
+		}()
+	}
+}
+
+// startRequest marks a request as being handled by c. It returns false if
+// c has already been closed.
+func (s *Server) startRequest(c *serverConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.active++
+	return true
+}
+
+// setActive adds delta to the number of requests c is handling. If the
+// server is closing and c is now idle then it's closed.
+func (s *Server) setActive(c *serverConn, delta int) {
+	s.mu.Lock()
+	c.active += delta
+	if s.closing && c.active == 0 {
+		c.close()
+	}
+	s.mu.Unlock()
+}
+
+func (s *Server) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+// readRequest reads the rest of a request after the message header. A
+// non-nil error means the connection is no longer usable.
+func (s *Server) readRequest(t Transport, name string, mtype byte, seq int32) (*serverRequest, error) {
+	r := &serverRequest{name: name, replyName: name, seq: seq}
+	if h, ok := t.(HeaderReadWriter); ok {
+		if headers := h.ReadHeaders(); len(headers) > 0 {
+			r.md = make(map[string]string, len(headers))
+			for k, v := range headers {
+				r.md[k] = v
+			}
+		}
+	}
+	if rn, ok := s.processor.(replyNamer); ok {
+		r.replyName = rn.replyName(name)
+	}
+	r.oneway = mtype == MessageTypeOneway
+	if mtype != MessageTypeCall && !r.oneway {
+		r.exc = &ApplicationException{"expected Call or Oneway message type", ExceptionInvalidMessageType}
+	} else if r.req = s.processor.NewRequest(name); r.req == nil {
+		r.exc = &ApplicationException{"Invalid method name: '" + name + "'", ExceptionUnknownMethod}
+	}
+	if r.exc != nil {
+		if err := SkipValue(t, TypeStruct); err != nil {
+			return nil, err
+		}
+		return r, t.ReadMessageEnd()
+	}
+	// Older clients send oneway requests with the Call message type
+	if o, ok := r.req.(oneway); ok && o.Oneway() {
+		r.oneway = true
+	}
+
+	if err := DecodeStruct(t, r.req); err != nil {
+		if _, ok := err.(*MissingRequiredField); !ok {
+			return nil, err
+		}
+		// The whole request was read so the connection is still usable
+		r.exc = &ApplicationException{err.Error(), ExceptionProtocolError}
+	}
+	return r, t.ReadMessageEnd()
+}
+
+// serveRequest processes a request that's been read and writes the reply.
+func (s *Server) serveRequest(ctx context.Context, c *serverConn, r *serverRequest) error {
+	if r.exc != nil {
+		if r.oneway {
+			// Errors can't be returned for oneway requests
+			return nil
+		}
+		return s.reply(c, r, MessageTypeException, r.exc)
+	}
+
+	if r.md != nil {
+		ctx = withIncomingMetadata(ctx, r.md)
+	}
+	res, err := s.process(ctx, r.name, r.seq, r.req)
+	if r.oneway {
+		return nil
+	}
+	if err != nil {
+		exc, ok := err.(*ApplicationException)
+		if !ok {
+			exc = &ApplicationException{err.Error(), ExceptionInternalError}
+		}
+		return s.reply(c, r, MessageTypeException, exc)
+	}
+	return s.reply(c, r, MessageTypeReply, res)
+}
+
+func (s *Server) reply(c *serverConn, r *serverRequest, mtype byte, v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return writeMessage(c.t, r.replyName, mtype, r.seq, v)
+}
+
+// process calls the handler recovering from panics in interceptors.
+func (s *Server) process(ctx context.Context, name string, seq int32, req interface{}) (res interface{}, err error) {
+	defer recoverPanic(name, &err)
+	return s.handler(ctx, name, seq, req)
+}
+
+// invoke calls the processor. Panics are recovered here so that
+// interceptors see them as internal errors.
+func (s *Server) invoke(ctx context.Context, name string, seq int32, req interface{}) (res interface{}, err error) {
+	defer recoverPanic(name, &err)
+	return s.processor.Process(ctx, name, req)
+}
+
+func recoverPanic(name string, err *error) {
+	if r := recover(); r != nil {
+		*err = &ApplicationException{fmt.Sprintf("panic in %s: %v", name, r), ExceptionInternalError}
+	}
+}
+
+// Shutdown gracefully shuts down the server. It stops accepting
+// connections, closes idle connections, and waits for requests being
+// handled to finish before closing their connections. If ctx is done first
+// the remaining connections are closed and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		if c.active == 0 {
+			c.close()
+		}
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		n := len(s.conns)
+		s.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close immediately closes all listeners and connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closing = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.close()
+	}
+	return nil
+}
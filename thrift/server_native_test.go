@@ -0,0 +1,383 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	gentest "github.com/samuel/go-thrift/testfiles/generator/withFlags/go.context"
+)
+
+type testStore struct {
+	mu      sync.Mutex
+	values  map[string]string
+	put     chan struct{}
+	pinging chan struct{} // closed when Ping is called
+	unblock chan struct{} // Ping waits on this if not nil
+}
+
+func newTestStore() *testStore {
+	return &testStore{
+		values: make(map[string]string),
+		put:    make(chan struct{}, 10),
+	}
+}
+
+func (s *testStore) Get(ctx context.Context, key string) (string, error) {
+	if key == "panic" {
+		panic("boom")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	if !ok {
+		return "", &gentest.NotFound{Key: key}
+	}
+	return v, nil
+}
+
+func (s *testStore) Ping(ctx context.Context) error {
+	if s.pinging != nil {
+		close(s.pinging)
+	}
+	if s.unblock != nil {
+		<-s.unblock
+	}
+	return nil
+}
+
+func (s *testStore) Put(ctx context.Context, key, value string) error {
+	s.mu.Lock()
+	s.values[key] = value
+	s.mu.Unlock()
+	s.put <- struct{}{}
+	return nil
+}
+
+func startNativeServer(t *testing.T, impl gentest.Store) (*Server, string) {
+	ln, addr := listenTCP()
+	s := NewServer(gentest.NewStoreProcessor(impl), nil)
+	go s.Serve(ln)
+	return s, addr
+}
+
+func TestServer(t *testing.T) {
+	store := newTestStore()
+	s, addr := startNativeServer(t, store)
+	defer s.Close()
+
+	c, err := DialContext(context.Background(), "tcp", addr, true, CompactProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client := &gentest.StoreClient{Client: c}
+	ctx := context.Background()
+
+	if err := client.Ping(ctx); err != nil {
+		t.Fatalf("Ping returned error: %+v", err)
+	}
+	if err := client.Put(ctx, "key", "value"); err != nil {
+		t.Fatalf("Put returned error: %+v", err)
+	}
+	<-store.put
+	if v, err := client.Get(ctx, "key"); err != nil {
+		t.Fatalf("Get returned error: %+v", err)
+	} else if v != "value" {
+		t.Fatalf("Expected 'value' instead of '%s'", v)
+	}
+
+	// Declared exception
+	if _, err := client.Get(ctx, "other"); err == nil {
+		t.Fatal("Expected NotFound error")
+	} else if nf, ok := err.(*gentest.NotFound); !ok || nf.Key != "other" {
+		t.Fatalf("Expected NotFound error instead of %+v", err)
+	}
+
+	// Panics in the implementation are returned as internal errors
+	if _, err := client.Get(ctx, "panic"); err == nil {
+		t.Fatal("Expected an error")
+	} else if e, ok := err.(*ApplicationException); !ok || e.Type != ExceptionInternalError {
+		t.Fatalf("Expected an internal error ApplicationException instead of %+v", err)
+	}
+
+	// Unknown method
+	if err := c.Call(ctx, "unknown", &gentest.StorePingRequest{}, &gentest.StorePingResponse{}); err == nil {
+		t.Fatal("Expected an error")
+	} else if e, ok := err.(*ApplicationException); !ok || e.Type != ExceptionUnknownMethod {
+		t.Fatalf("Expected an unknown method ApplicationException instead of %+v", err)
+	}
+
+	// Connection is still usable after exceptions
+	if err := client.Ping(ctx); err != nil {
+		t.Fatalf("Ping returned error: %+v", err)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	store := newTestStore()
+	store.pinging = make(chan struct{})
+	store.unblock = make(chan struct{})
+	s, addr := startNativeServer(t, store)
+
+	idle, err := DialContext(context.Background(), "tcp", addr, false, BinaryProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	if err := idle.Call(context.Background(), "put", &gentest.StorePutRequest{Key: "a"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-store.put
+
+	c, err := DialContext(context.Background(), "tcp", addr, false, BinaryProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client := &gentest.StoreClient{Client: c}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- client.Ping(context.Background())
+	}()
+	<-store.pinging
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned before the active request finished: %+v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Fatal("Server still accepting connections after Shutdown")
+	}
+
+	close(store.unblock)
+	if err := <-errc; err != nil {
+		t.Fatalf("Active request failed during shutdown: %+v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Shutdown returned error: %+v", err)
+	}
+	ln, _ := listenTCP()
+	if err := s.Serve(ln); err != ErrServerClosed {
+		t.Fatalf("Expected ErrServerClosed instead of %+v", err)
+	}
+}
+
+func TestServerShutdownPartialRequest(t *testing.T) {
+	ln, addr := listenTCP()
+	s := NewServer(gentest.NewStoreProcessor(newTestStore()), func(rwc io.ReadWriteCloser) (Transport, error) {
+		return NewTransport(rwc, BinaryProtocol), nil
+	})
+	go s.Serve(ln)
+
+	buf := &bytes.Buffer{}
+	if err := writeMessage(NewTransport(&ClosingBuffer{buf}, BinaryProtocol), "get", MessageTypeCall, 1, &gentest.StoreGetRequest{Key: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	msg := buf.Bytes()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Only send the message header (version, name, and sequence ID)
+	if _, err := conn.Write(msg[:15]); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned before the started request finished: %+v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, err := conn.Write(msg[15:]); err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTransport(conn, BinaryProtocol)
+	if _, mtype, seq, err := tr.ReadMessageBegin(); err != nil {
+		t.Fatalf("Started request failed during shutdown: %+v", err)
+	} else if mtype != MessageTypeReply || seq != 1 {
+		t.Fatalf("Expected a reply to 1 instead of type %d to %d", mtype, seq)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Shutdown returned error: %+v", err)
+	}
+}
+
+func TestServerOnewayNative(t *testing.T) {
+	store := newTestStore()
+	s, addr := startNativeServer(t, store)
+	defer s.Close()
+
+	protocols := []struct {
+		name    string
+		builder ProtocolBuilder
+	}{
+		{"binary", BinaryProtocol},
+		{"compact", CompactProtocol},
+		{"json", JSONProtocol},
+	}
+	ctx := context.Background()
+	for _, p := range protocols {
+		for _, framed := range []bool{false, true} {
+			c, err := DialContext(ctx, "tcp", addr, framed, p.builder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &gentest.StoreClient{Client: c}
+			if err := client.Put(ctx, p.name, "value"); err != nil {
+				t.Fatalf("%s framed=%t: Put returned error: %+v", p.name, framed, err)
+			}
+			select {
+			case <-store.put:
+			case <-time.After(time.Second):
+				t.Fatalf("%s framed=%t: oneway request wasn't handled", p.name, framed)
+			}
+			// A reply to the oneway request would fail the client
+			if err := client.Ping(ctx); err != nil {
+				t.Fatalf("%s framed=%t: Ping returned error: %+v", p.name, framed, err)
+			}
+			c.Close()
+		}
+	}
+}
+
+// blockingStore blocks in Ping until released.
+type blockingStore struct {
+	*testStore
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingStore() *blockingStore {
+	return &blockingStore{
+		testStore: newTestStore(),
+		started:   make(chan struct{}, 10),
+		release:   make(chan struct{}),
+	}
+}
+
+func (s *blockingStore) Ping(ctx context.Context) error {
+	s.started <- struct{}{}
+	<-s.release
+	return nil
+}
+
+func waitStarted(t *testing.T, started chan struct{}) {
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("Request wasn't started")
+	}
+}
+
+func expectNotStarted(t *testing.T, started chan struct{}) {
+	select {
+	case <-started:
+		t.Fatal("Request was started past the concurrency limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// readReply reads a reply into res and returns its sequence ID.
+func readReply(t *testing.T, tr Transport, res interface{}) int32 {
+	_, mtype, seq, err := tr.ReadMessageBegin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mtype != MessageTypeReply {
+		t.Fatalf("Expected a reply instead of message type %d", mtype)
+	}
+	if err := DecodeStruct(tr, res); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.ReadMessageEnd(); err != nil {
+		t.Fatal(err)
+	}
+	return seq
+}
+
+func TestServerConcurrency(t *testing.T) {
+	for _, framed := range []bool{false, true} {
+		store := newBlockingStore()
+		ln, addr := listenTCP()
+		s := NewServer(gentest.NewStoreProcessor(store), nil)
+		s.SetConcurrency(2)
+		go s.Serve(ln)
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rwc io.ReadWriteCloser = conn
+		if framed {
+			rwc = NewFramedReadWriteCloser(conn, DefaultMaxFrameSize)
+		}
+		tr := NewTransport(rwc, BinaryProtocol)
+		send := func(name string, seq int32, req interface{}) {
+			if err := writeMessage(tr, name, MessageTypeCall, seq, req); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// A blocked request doesn't hold up the next one
+		send("ping", 1, &gentest.StorePingRequest{})
+		waitStarted(t, store.started)
+		send("get", 2, &gentest.StoreGetRequest{Key: "missing"})
+		res := &gentest.StoreGetResponse{}
+		if seq := readReply(t, tr, res); seq != 2 || res.Nf == nil {
+			t.Fatalf("framed=%t: expected NotFound reply to request 2 instead of %d %+v", framed, seq, res)
+		}
+
+		// No more than 2 requests are handled at once
+		send("ping", 3, &gentest.StorePingRequest{})
+		waitStarted(t, store.started)
+		send("ping", 4, &gentest.StorePingRequest{})
+		expectNotStarted(t, store.started)
+		store.release <- struct{}{}
+		waitStarted(t, store.started)
+
+		// Shutdown waits for the requests being handled
+		done := make(chan error, 1)
+		go func() {
+			done <- s.Shutdown(context.Background())
+		}()
+		store.release <- struct{}{}
+		store.release <- struct{}{}
+		seqs := make(map[int32]bool)
+		for i := 0; i < 3; i++ {
+			seqs[readReply(t, tr, &gentest.StorePingResponse{})] = true
+		}
+		if !seqs[1] || !seqs[3] || !seqs[4] {
+			t.Fatalf("framed=%t: expected replies to requests 1, 3, and 4 instead of %+v", framed, seqs)
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("framed=%t: Shutdown returned error: %+v", framed, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("framed=%t: Shutdown didn't return", framed)
+		}
+		tr.Close()
+	}
+}
@@ -6,8 +6,11 @@ package thrift
 
 import (
 	"bytes"
+	"io"
+	"net"
 	"net/rpc"
 	"testing"
+	"time"
 )
 
 // Make sure the ServerCodec returns the same method name
@@ -58,3 +61,136 @@ func TestServerMethodName(t *testing.T) {
 		t.Fatalf("Expected ServiceMethod of '%s' instead of '%s'", req.ServiceMethod, res2.ServiceMethod)
 	}
 }
+
+func TestServerServiceMethodName(t *testing.T) {
+	cases := map[string]string{
+		"some_method":     "Thrift.SomeMethod",
+		"Foo.bar":         "Foo.bar",
+		"Foo:some_method": "Foo.SomeMethod",
+	}
+	for name, expected := range cases {
+		buf := &ClosingBuffer{&bytes.Buffer{}}
+		clientCodec := NewClientCodec(NewTransport(buf, BinaryProtocol), false)
+		serverCodec := NewServerCodec(NewTransport(buf, BinaryProtocol))
+		if err := clientCodec.WriteRequest(&rpc.Request{ServiceMethod: name, Seq: 1}, &struct{}{}); err != nil {
+			t.Fatal(err)
+		}
+		var req rpc.Request
+		if err := serverCodec.ReadRequestHeader(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req.ServiceMethod != expected {
+			t.Errorf("Expected %s to be routed to %s instead of %s", name, expected, req.ServiceMethod)
+		}
+	}
+}
+
+type onewayTestService struct {
+	received chan int32
+}
+
+func (s *onewayTestService) Notify(req *TestOneWayRequest, _ *struct{}) error {
+	s.received <- req.Value
+	return nil
+}
+
+func (s *onewayTestService) Success(req *TestRequest, res *TestResponse) error {
+	res.Value = req.Value
+	return nil
+}
+
+var onewayTransports = []struct {
+	name         string
+	newTransport func(io.ReadWriteCloser) Transport
+}{
+	{"binary", func(rwc io.ReadWriteCloser) Transport { return NewTransport(rwc, BinaryProtocol) }},
+	{"compact", func(rwc io.ReadWriteCloser) Transport { return NewTransport(rwc, CompactProtocol) }},
+	{"json", func(rwc io.ReadWriteCloser) Transport { return NewTransport(rwc, JSONProtocol) }},
+	{"framed-binary", func(rwc io.ReadWriteCloser) Transport {
+		return NewTransport(NewFramedReadWriteCloser(rwc, 0), BinaryProtocol)
+	}},
+	{"framed-compact", func(rwc io.ReadWriteCloser) Transport {
+		return NewTransport(NewFramedReadWriteCloser(rwc, 0), CompactProtocol)
+	}},
+	{"framed-json", func(rwc io.ReadWriteCloser) Transport {
+		return NewTransport(NewFramedReadWriteCloser(rwc, 0), JSONProtocol)
+	}},
+	{"header-binary", func(rwc io.ReadWriteCloser) Transport { return NewHeaderTransport(rwc, HeaderProtocolBinary, 0) }},
+	{"header-compact", func(rwc io.ReadWriteCloser) Transport { return NewHeaderTransport(rwc, HeaderProtocolCompact, 0) }},
+}
+
+func TestServerOneway(t *testing.T) {
+	for _, tr := range onewayTransports {
+		svc := &onewayTestService{received: make(chan int32, 1)}
+		srv := rpc.NewServer()
+		if err := srv.RegisterName("Thrift", svc); err != nil {
+			t.Fatal(err)
+		}
+		cconn, sconn := net.Pipe()
+		go srv.ServeCodec(NewServerCodec(tr.newTransport(sconn)))
+		client := NewClient(tr.newTransport(cconn), true)
+
+		if err := client.Call("Notify", &TestOneWayRequest{123}, nil); err != nil {
+			t.Fatalf("%s: oneway call returned error: %+v", tr.name, err)
+		}
+		select {
+		case v := <-svc.received:
+			if v != 123 {
+				t.Fatalf("%s: expected 123 instead of %d", tr.name, v)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: oneway request wasn't handled", tr.name)
+		}
+
+		// A reply to the oneway request would be read as the reply to this
+		res := &TestResponse{}
+		if err := client.Call("Success", &TestRequest{456}, res); err != nil {
+			t.Fatalf("%s: call returned error: %+v", tr.name, err)
+		} else if res.Value != 456 {
+			t.Fatalf("%s: expected 456 instead of %d", tr.name, res.Value)
+		}
+		client.Close()
+	}
+}
+
+type blockingTestService struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingTestService) Success(req *TestRequest, res *TestResponse) error {
+	s.started <- struct{}{}
+	<-s.release
+	res.Value = req.Value
+	return nil
+}
+
+func TestLimitedServerCodec(t *testing.T) {
+	svc := &blockingTestService{
+		started: make(chan struct{}, 10),
+		release: make(chan struct{}),
+	}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Thrift", svc); err != nil {
+		t.Fatal(err)
+	}
+	cconn, sconn := tcpPipe(t)
+	go srv.ServeCodec(NewLimitedServerCodec(NewServerCodec(NewTransport(sconn, BinaryProtocol)), 1))
+	client := NewPipelinedClient(NewTransport(cconn, BinaryProtocol))
+	defer client.Close()
+
+	call1 := client.Go("Success", &TestRequest{1}, &TestResponse{}, nil)
+	waitStarted(t, svc.started)
+	call2 := client.Go("Success", &TestRequest{2}, &TestResponse{}, nil)
+	expectNotStarted(t, svc.started)
+	svc.release <- struct{}{}
+	waitStarted(t, svc.started)
+	svc.release <- struct{}{}
+	for i, c := range []*rpc.Call{call1, call2} {
+		if call := <-c.Done; call.Error != nil {
+			t.Fatalf("Call %d returned error: %+v", i+1, call.Error)
+		} else if v := call.Reply.(*TestResponse).Value; v != int32(i+1) {
+			t.Fatalf("Expected %d instead of %d", i+1, v)
+		}
+	}
+}
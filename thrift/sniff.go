@@ -0,0 +1,106 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"bufio"
+	"compress/zlib"
+	"io"
+)
+
+// BinaryProtocolNonStrict reads either strict or non-strict binary messages
+// but writes non-strict (no version header) messages for old clients.
+var BinaryProtocolNonStrict = NewProtocolBuilder(
+	func(r io.Reader) ProtocolReader { return NewBinaryProtocolReader(r, false) },
+	func(w io.Writer) ProtocolWriter { return NewBinaryProtocolWriter(w, false) },
+)
+
+type peekedReadWriteCloser struct {
+	*bufio.Reader
+	io.Writer
+	io.Closer
+}
+
+func (p *peekedReadWriteCloser) Flush() error {
+	if f, ok := p.Writer.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// NewSniffingTransport peeks at the first bytes sent on the connection to
+// detect the framing and protocol the client is using, and returns a
+// Transport that speaks the same. It recognizes the header transport, and
+// framed or unframed binary (strict or non-strict), compact, and JSON
+// protocols, optionally wrapped in zlib compression. It blocks until
+// enough of the first message has been read so it should be called from
+// the goroutine that serves the connection.
+func NewSniffingTransport(rwc io.ReadWriteCloser, maxFrameSize int) (Transport, error) {
+	br := bufio.NewReader(rwc)
+	conn := &peekedReadWriteCloser{Reader: br, Writer: rwc, Closer: rwc}
+
+	b, err := br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if p := sniffProtocol(b[0]); p != nil {
+		return NewTransport(conn, p), nil
+	}
+	if b[0] == zlibCMF {
+		// A zlib stream header is a multiple of 31
+		if b, err = br.Peek(2); err != nil {
+			return nil, err
+		}
+		if (uint(b[0])<<8|uint(b[1]))%31 == 0 {
+			return NewSniffingTransport(NewZlibReadWriteCloser(conn, zlib.DefaultCompression), maxFrameSize)
+		}
+	}
+
+	// The first 4 bytes are either a frame size or the length of the
+	// message name for a non-strict binary message. Every possible message
+	// is longer than 5 bytes.
+	if b, err = br.Peek(5); err != nil {
+		return nil, err
+	}
+	if p := sniffProtocol(b[4]); p != nil {
+		return NewTransport(NewFramedReadWriteCloser(conn, maxFrameSize), p), nil
+	}
+	if b[4] == headerMagic>>8 {
+		if b, err = br.Peek(6); err != nil {
+			return nil, err
+		}
+		if b[5] == headerMagic&0xff {
+			return NewHeaderTransport(conn, HeaderProtocolBinary, maxFrameSize), nil
+		}
+	}
+
+	// Non-strict binary. If framed then the message starts with the name
+	// length where the high byte will always be 0, otherwise it's the first
+	// byte of the name (or the message type for an empty name).
+	if b[4] == 0 {
+		return NewTransport(NewFramedReadWriteCloser(conn, maxFrameSize), BinaryProtocolNonStrict), nil
+	}
+	if b[0] != 0 {
+		return nil, ProtocolError{"SniffingTransport", "unable to detect protocol"}
+	}
+	return NewTransport(conn, BinaryProtocolNonStrict), nil
+}
+
+// zlibCMF is the first byte of a zlib stream using deflate with a 32K window
+const zlibCMF = 0x78
+
+// sniffProtocol returns the protocol for a message beginning with b, or nil
+// if it's not a protocol with a message header.
+func sniffProtocol(b byte) ProtocolBuilder {
+	switch b {
+	case byte(version1 >> 24):
+		return BinaryProtocol
+	case compactProtocolID:
+		return CompactProtocol
+	case '[':
+		return JSONProtocol
+	}
+	return nil
+}
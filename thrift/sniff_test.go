@@ -0,0 +1,60 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"compress/zlib"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestSniffingTransport(t *testing.T) {
+	once.Do(startServer)
+
+	framed := func(c io.ReadWriteCloser) io.ReadWriteCloser { return NewFramedReadWriteCloser(c, 0) }
+	unframed := func(c io.ReadWriteCloser) io.ReadWriteCloser { return c }
+	cases := []struct {
+		name      string
+		transport func(net.Conn) Transport
+	}{
+		{"framed binary", func(c net.Conn) Transport { return NewTransport(framed(c), BinaryProtocol) }},
+		{"unframed binary", func(c net.Conn) Transport { return NewTransport(unframed(c), BinaryProtocol) }},
+		{"framed non-strict binary", func(c net.Conn) Transport { return NewTransport(framed(c), BinaryProtocolNonStrict) }},
+		{"unframed non-strict binary", func(c net.Conn) Transport { return NewTransport(unframed(c), BinaryProtocolNonStrict) }},
+		{"framed compact", func(c net.Conn) Transport { return NewTransport(framed(c), CompactProtocol) }},
+		{"unframed compact", func(c net.Conn) Transport { return NewTransport(unframed(c), CompactProtocol) }},
+		{"framed json", func(c net.Conn) Transport { return NewTransport(framed(c), JSONProtocol) }},
+		{"unframed json", func(c net.Conn) Transport { return NewTransport(unframed(c), JSONProtocol) }},
+		{"header", func(c net.Conn) Transport { return NewHeaderTransport(c, HeaderProtocolCompact, 0) }},
+		{"zlib framed compact", func(c net.Conn) Transport {
+			return NewTransport(framed(NewZlibReadWriteCloser(c, zlib.DefaultCompression)), CompactProtocol)
+		}},
+		{"zlib unframed binary", func(c net.Conn) Transport {
+			return NewTransport(NewZlibReadWriteCloser(c, zlib.DefaultCompression), BinaryProtocol)
+		}},
+	}
+	for _, tc := range cases {
+		cli, srv := net.Pipe()
+		go func() {
+			st, err := NewSniffingTransport(srv, 0)
+			if err != nil {
+				srv.Close()
+				return
+			}
+			ServeConn(st)
+		}()
+		c := NewClient(tc.transport(cli), false)
+		req := &TestRequest{123}
+		res := &TestResponse{789}
+		if err := c.Call("Success", req, res); err != nil {
+			t.Fatalf("%s: Client.Call returned error: %+v", tc.name, err)
+		}
+		if res.Value != req.Value {
+			t.Fatalf("%s: Response value wrong: %d != %d", tc.name, res.Value, req.Value)
+		}
+		c.Close()
+	}
+}
@@ -7,8 +7,10 @@ package thrift
 import (
 	"errors"
 	"fmt"
+	"net/rpc"
 	"reflect"
 	"sort"
+	"strings"
 	"sync"
 )
 
@@ -112,61 +114,95 @@ type ApplicationException struct {
 	Type    int32  `thrift:"2"`
 }
 
+func (e *ApplicationException) Error() string {
+	return e.String()
+}
+
 func (e *ApplicationException) String() string {
-	typeStr := "Unknown Exception"
-	switch e.Type {
-	case ExceptionUnknownMethod:
-		typeStr = "Unknown Method"
-	case ExceptionInvalidMessageType:
-		typeStr = "Invalid Message Type"
-	case ExceptionWrongMethodName:
-		typeStr = "Wrong Method Name"
-	case ExceptionBadSequenceID:
-		typeStr = "Bad Sequence ID"
-	case ExceptionMissingResult:
-		typeStr = "Missing Result"
-	case ExceptionInternalError:
-		typeStr = "Internal Error"
-	case ExceptionProtocolError:
-		typeStr = "Protocol Error"
+	typeStr, ok := exceptionTypeStrings[e.Type]
+	if !ok {
+		typeStr = "Unknown Exception"
 	}
 	return fmt.Sprintf("%s: %s", typeStr, e.Message)
 }
 
-func fieldType(t reflect.Type) byte {
+var exceptionTypeStrings = map[int32]string{
+	ExceptionUnknownMethod:      "Unknown Method",
+	ExceptionInvalidMessageType: "Invalid Message Type",
+	ExceptionWrongMethodName:    "Wrong Method Name",
+	ExceptionBadSequenceID:      "Bad Sequence ID",
+	ExceptionMissingResult:      "Missing Result",
+	ExceptionInternalError:      "Internal Error",
+	ExceptionProtocolError:      "Protocol Error",
+}
+
+// exceptionType returns the type of the ApplicationException that err is
+// or that the server replied with, and false if err isn't an exception.
+// net/rpc clients only get the exception as an rpc.ServerError holding its
+// String so the type is recovered from that.
+func exceptionType(err error) (int32, bool) {
+	switch e := err.(type) {
+	case *ApplicationException:
+		return e.Type, true
+	case rpc.ServerError:
+		if i := strings.Index(string(e), ": "); i >= 0 {
+			for t, s := range exceptionTypeStrings {
+				if s == string(e)[:i] {
+					return t, true
+				}
+			}
+		}
+		return ExceptionUnknown, true
+	}
+	return 0, false
+}
+
+// fieldType returns the Thrift type that values of t are encoded as, or
+// false if t can't be encoded.
+func fieldType(t reflect.Type) (byte, bool) {
 	switch t.Kind() {
 	case reflect.Bool:
-		return TypeBool
+		return TypeBool, true
 	case reflect.Int8, reflect.Uint8:
-		return TypeByte
+		return TypeByte, true
 	case reflect.Int16:
-		return TypeI16
+		return TypeI16, true
 	case reflect.Int32, reflect.Uint32, reflect.Int:
-		return TypeI32
+		return TypeI32, true
 	case reflect.Int64, reflect.Uint64:
-		return TypeI64
+		return TypeI64, true
 	case reflect.Float64:
-		return TypeDouble
+		return TypeDouble, true
 	case reflect.Map:
 		valueType := t.Elem()
 		if valueType.Kind() == reflect.Struct && valueType.Name() == "" && valueType.NumField() == 0 {
-			return TypeSet
+			return TypeSet, true
 		}
-		return TypeMap
+		return TypeMap, true
 	case reflect.Slice:
 		elemType := t.Elem()
 		if elemType.Kind() == reflect.Uint8 {
-			return TypeString
+			return TypeString, true
 		}
-		return TypeList
+		return TypeList, true
 	case reflect.Struct:
-		return TypeStruct
+		return TypeStruct, true
 	case reflect.String:
-		return TypeString
-	case reflect.Interface, reflect.Ptr:
+		return TypeString, true
+	case reflect.Ptr:
 		return fieldType(t.Elem())
 	}
-	panic(&UnsupportedTypeError{t})
+	return 0, false
+}
+
+// mustFieldType is fieldType for the encoder which panics with an
+// UnsupportedTypeError if t can't be encoded.
+func mustFieldType(t reflect.Type) byte {
+	ft, ok := fieldType(t)
+	if !ok {
+		panic(&UnsupportedTypeError{t})
+	}
+	return ft
 }
 
 func isEmptyValue(v reflect.Value) bool {
@@ -266,7 +302,7 @@ func encodeFields(t reflect.Type) structMeta {
 			if opts.Contains("set") {
 				ef.fieldType = TypeSet
 			} else {
-				ef.fieldType = fieldType(f.Type)
+				ef.fieldType = mustFieldType(f.Type)
 			}
 
 			fs[ef.id] = ef
@@ -299,7 +335,10 @@ func SkipValue(r ProtocolReader, thriftType byte) error {
 	case TypeDouble:
 		_, err = r.ReadDouble()
 	case TypeString:
-		_, err = r.ReadBytes()
+		// Use ReadString rather than ReadBytes since JSON encodes binary
+		// as base64 which a string may not be. The text protocol reads
+		// binary values with either.
+		_, err = r.ReadString()
 	case TypeStruct:
 		if err := r.ReadStructBegin(); err != nil {
 			return err
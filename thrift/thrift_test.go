@@ -5,6 +5,7 @@
 package thrift
 
 import (
+	"bytes"
 	"reflect"
 	"testing"
 )
@@ -25,3 +26,22 @@ func TestEncodeFields(t *testing.T) {
 		t.Fatalf("Type map[...]struct{} not handled as a Set")
 	}
 }
+
+func TestSkipBinaryField(t *testing.T) {
+	in := &TestStruct2{Str: "foo", Binary: []byte{0, 1, 0xff, '"'}}
+	protocols := map[string]ProtocolBuilder{
+		"binary":  BinaryProtocol,
+		"compact": CompactProtocol,
+		"json":    JSONProtocol,
+		"text":    TextProtocol,
+	}
+	for name, p := range protocols {
+		b := &bytes.Buffer{}
+		if err := EncodeStruct(p.NewProtocolWriter(b), in); err != nil {
+			t.Fatalf("%s: %+v", name, err)
+		}
+		if err := SkipValue(p.NewProtocolReader(b), TypeStruct); err != nil {
+			t.Fatalf("%s: failed to skip struct with a binary field: %+v", name, err)
+		}
+	}
+}
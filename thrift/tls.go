@@ -0,0 +1,73 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/rpc"
+)
+
+type tlsStateKey struct{}
+
+func withTLSState(ctx context.Context, state tls.ConnectionState) context.Context {
+	return context.WithValue(ctx, tlsStateKey{}, &state)
+}
+
+// TLSConnectionState returns the state of the TLS connection a request was
+// received on. It's available to processors and interceptors of a Server
+// serving a TLS listener (or HTTPS through Server.HTTPHandler).
+func TLSConnectionState(ctx context.Context) (*tls.ConnectionState, bool) {
+	state, ok := ctx.Value(tlsStateKey{}).(*tls.ConnectionState)
+	return state, ok
+}
+
+// PeerCertificate returns the client certificate a request was received
+// with if it was verified against the server's tls.Config (ClientAuth set
+// to tls.VerifyClientCertIfGiven or tls.RequireAndVerifyClientCert), or nil
+// otherwise.
+func PeerCertificate(ctx context.Context) *x509.Certificate {
+	state, ok := TLSConnectionState(ctx)
+	if !ok || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
+// DialTLS is like Dial but connects using TLS with config. For mutual TLS
+// config should include the client's certificate.
+func DialTLS(network, address string, framed bool, protocol ProtocolBuilder, supportOnewayRequests bool, config *tls.Config) (*rpc.Client, error) {
+	conn, err := tls.Dial(network, address, config)
+	if err != nil {
+		return nil, err
+	}
+	return newConnClient(conn, framed, protocol, supportOnewayRequests), nil
+}
+
+// DialContextTLS is like DialContext but connects using TLS with config.
+// The handshake is completed before it returns.
+func DialContextTLS(ctx context.Context, network, address string, framed bool, protocol ProtocolBuilder, config *tls.Config) (*ContextClient, error) {
+	d := &tls.Dialer{Config: config}
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return newConnContextClient(conn, framed, protocol), nil
+}
+
+// ListenTLS announces on the local network address and returns a listener
+// accepting TLS connections with config. Set config.ClientAuth to
+// tls.RequireAndVerifyClientCert and config.ClientCAs to require mutual
+// TLS.
+func ListenTLS(network, address string, config *tls.Config) (net.Listener, error) {
+	return tls.Listen(network, address, config)
+}
+
+// ServeTLS is like Serve but accepts TLS connections on l using config.
+func (s *Server) ServeTLS(l net.Listener, config *tls.Config) error {
+	return s.Serve(tls.NewListener(l, config))
+}
@@ -0,0 +1,173 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	gentest "github.com/samuel/go-thrift/testfiles/generator/withFlags/go.context"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert, key, pool}
+}
+
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "server", x509.ExtKeyUsageServerAuth)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	}
+	clientConfig := &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "client-a", x509.ExtKeyUsageClientAuth)},
+		RootCAs:      ca.pool,
+	}
+
+	peers := make(chan string, 10)
+	s := NewServer(gentest.NewStoreProcessor(newTestStore()), nil)
+	s.Use(func(ctx context.Context, method string, seq int32, req interface{}, next ServerHandler) (interface{}, error) {
+		if cert := PeerCertificate(ctx); cert != nil {
+			peers <- cert.Subject.CommonName
+		} else {
+			peers <- ""
+		}
+		return next(ctx, method, seq, req)
+	})
+	ln, addr := listenTCP()
+	go s.ServeTLS(ln, serverConfig)
+	defer s.Close()
+
+	ctx := context.Background()
+	c, err := DialContextTLS(ctx, "tcp", addr, true, BinaryProtocol, clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := (&gentest.StoreClient{Client: c}).Ping(ctx); err != nil {
+		t.Fatalf("Ping returned error: %+v", err)
+	}
+	if p := <-peers; p != "client-a" {
+		t.Fatalf("Expected peer client-a instead of '%s'", p)
+	}
+
+	// net/rpc client
+	rc, err := DialTLS("tcp", addr, false, CompactProtocol, false, clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if err := rc.Call("ping", &gentest.StorePingRequest{}, &gentest.StorePingResponse{}); err != nil {
+		t.Fatalf("Ping returned error: %+v", err)
+	}
+	if p := <-peers; p != "client-a" {
+		t.Fatalf("Expected peer client-a instead of '%s'", p)
+	}
+
+	// Clients without a certificate are rejected
+	c2, err := DialContextTLS(ctx, "tcp", addr, true, BinaryProtocol, &tls.Config{RootCAs: ca.pool})
+	if err == nil {
+		defer c2.Close()
+		err = (&gentest.StoreClient{Client: c2}).Ping(ctx)
+	}
+	if err == nil {
+		t.Fatal("Expected an error without a client certificate")
+	}
+}
+
+func TestServerTLSHandshake(t *testing.T) {
+	ca := newTestCA(t)
+	config := &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "server", x509.ExtKeyUsageServerAuth)},
+	}
+	for _, timeout := range []time.Duration{20 * time.Millisecond, 0} {
+		s := NewServer(gentest.NewStoreProcessor(newTestStore()), nil)
+		s.SetHandshakeTimeout(timeout)
+		ln, addr := listenTCP()
+		go s.ServeTLS(ln, config)
+
+		// A client that never starts the handshake
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if timeout == 0 {
+			// Shutdown closes connections waiting for the handshake
+			time.Sleep(20 * time.Millisecond)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			if err := s.Shutdown(ctx); err != nil {
+				t.Fatalf("Shutdown returned error: %+v", err)
+			}
+			cancel()
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("timeout=%s: expected the server to close the connection instead of %+v", timeout, err)
+		}
+		conn.Close()
+		s.Close()
+	}
+}
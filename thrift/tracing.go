@@ -0,0 +1,365 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"math/rand"
+	"net/rpc"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Metadata keys used to propagate spans (as Zipkin B3 headers). IDs are
+// hex encoded. On TTwitter connections they're carried in the fields of
+// the request header instead.
+const (
+	TraceIDKey      = "x-b3-traceid"
+	SpanIDKey       = "x-b3-spanid"
+	ParentSpanIDKey = "x-b3-parentspanid"
+	SampledKey      = "x-b3-sampled"
+)
+
+// SpanKind is whether a span is for making or handling a call.
+type SpanKind int
+
+const (
+	SpanKindClient SpanKind = iota
+	SpanKindServer
+)
+
+func (k SpanKind) String() string {
+	if k == SpanKindServer {
+		return "server"
+	}
+	return "client"
+}
+
+// SpanContext identifies a span. A zero TraceID means there is no span.
+type SpanContext struct {
+	TraceID  uint64
+	SpanID   uint64
+	ParentID uint64
+	Sampled  bool
+}
+
+// Span is a call in progress.
+type Span interface {
+	Context() SpanContext
+	// Finish ends the span. err is the error the call failed with if any.
+	Finish(err error)
+}
+
+// Tracer starts spans for calls. It's the integration point for tracing
+// libraries.
+type Tracer interface {
+	// StartSpan starts a span for method. parent is the span context of
+	// the caller (propagated from the client for server spans) and is zero
+	// if there is none.
+	StartSpan(method string, kind SpanKind, parent SpanContext) Span
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span.
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx or nil. Server handlers
+// get the span of the request they're handling when the server uses
+// TracingServerInterceptor.
+func SpanFromContext(ctx context.Context) Span {
+	span, _ := ctx.Value(spanKey{}).(Span)
+	return span
+}
+
+// injectSpan returns the metadata propagating sc.
+func injectSpan(sc SpanContext) map[string]string {
+	md := map[string]string{
+		TraceIDKey: strconv.FormatUint(sc.TraceID, 16),
+		SpanIDKey:  strconv.FormatUint(sc.SpanID, 16),
+		SampledKey: "0",
+	}
+	if sc.ParentID != 0 {
+		md[ParentSpanIDKey] = strconv.FormatUint(sc.ParentID, 16)
+	}
+	if sc.Sampled {
+		md[SampledKey] = "1"
+	}
+	return md
+}
+
+// extractSpan returns the span context propagated in md. It's zero if
+// there isn't one.
+func extractSpan(md map[string]string) SpanContext {
+	var sc SpanContext
+	var err error
+	if sc.TraceID, err = strconv.ParseUint(md[TraceIDKey], 16, 64); err != nil {
+		return SpanContext{}
+	}
+	if sc.SpanID, err = strconv.ParseUint(md[SpanIDKey], 16, 64); err != nil {
+		return SpanContext{}
+	}
+	sc.ParentID, _ = strconv.ParseUint(md[ParentSpanIDKey], 16, 64)
+	sc.Sampled = md[SampledKey] == "1" || md[SampledKey] == "true"
+	return sc
+}
+
+// TracingClientInterceptor returns a ContextClient interceptor that starts
+// a client span for every call as a child of the span in the call's
+// context, and propagates it to the server in the call's metadata.
+func TracingClientInterceptor(tracer Tracer) ClientInterceptor {
+	return func(ctx context.Context, method string, seq int32, req, res interface{}, next ClientInvoker) error {
+		var parent SpanContext
+		if span := SpanFromContext(ctx); span != nil {
+			parent = span.Context()
+		}
+		span := tracer.StartSpan(method, SpanKindClient, parent)
+		ctx = WithOutgoingMetadata(ContextWithSpan(ctx, span), injectSpan(span.Context()))
+		err := next(ctx, method, seq, req, res)
+		span.Finish(err)
+		return err
+	}
+}
+
+// TracingServerInterceptor returns a Server interceptor that starts a
+// server span for every request as a child of the span propagated by the
+// client. Handlers get it with SpanFromContext so that calls they make
+// with TracingClientInterceptor are its children.
+func TracingServerInterceptor(tracer Tracer) ServerInterceptor {
+	return func(ctx context.Context, method string, seq int32, req interface{}, next ServerHandler) (interface{}, error) {
+		span := tracer.StartSpan(method, SpanKindServer, extractSpan(IncomingMetadata(ctx)))
+		res, err := next(ContextWithSpan(ctx, span), method, seq, req)
+		span.Finish(err)
+		return res, err
+	}
+}
+
+type tracingClientCodec struct {
+	rpc.ClientCodec
+	tracer Tracer
+
+	mu    sync.Mutex
+	spans map[uint64]Span
+	seq   uint64 // sequence ID of the response being read
+	err   string // error of the response being read
+}
+
+// NewTracingClientCodec wraps codec to start a client span in WriteRequest
+// for every call and finish it in ReadResponseBody. net/rpc calls have no
+// context so the spans have no parent. They're propagated to the server if
+// the codec's transport carries headers.
+func NewTracingClientCodec(codec rpc.ClientCodec, tracer Tracer) rpc.ClientCodec {
+	return &tracingClientCodec{
+		ClientCodec: codec,
+		tracer:      tracer,
+		spans:       make(map[uint64]Span),
+	}
+}
+
+func (c *tracingClientCodec) WriteRequest(request *rpc.Request, thriftStruct interface{}) error {
+	span := c.tracer.StartSpan(request.ServiceMethod, SpanKindClient, SpanContext{})
+	for k, v := range injectSpan(span.Context()) {
+		setCodecHeader(c.ClientCodec, k, v)
+	}
+	c.mu.Lock()
+	c.spans[request.Seq] = span
+	c.mu.Unlock()
+	err := c.ClientCodec.WriteRequest(request, thriftStruct)
+	if err != nil {
+		c.finish(request.Seq, err)
+	}
+	return err
+}
+
+func (c *tracingClientCodec) ReadResponseHeader(response *rpc.Response) error {
+	err := c.ClientCodec.ReadResponseHeader(response)
+	c.seq = response.Seq
+	c.err = response.Error
+	return err
+}
+
+func (c *tracingClientCodec) ReadResponseBody(thriftStruct interface{}) error {
+	err := c.ClientCodec.ReadResponseBody(thriftStruct)
+	spanErr := err
+	if c.err != "" {
+		spanErr = rpc.ServerError(c.err)
+	}
+	c.finish(c.seq, spanErr)
+	return err
+}
+
+func (c *tracingClientCodec) ReadHeaders() map[string]string {
+	return readCodecHeaders(c.ClientCodec)
+}
+
+func (c *tracingClientCodec) SetWriteHeader(key, value string) {
+	setCodecHeader(c.ClientCodec, key, value)
+}
+
+func (c *tracingClientCodec) Close() error {
+	err := c.ClientCodec.Close()
+	c.mu.Lock()
+	spans := c.spans
+	c.spans = make(map[uint64]Span)
+	c.mu.Unlock()
+	for _, span := range spans {
+		span.Finish(rpc.ErrShutdown)
+	}
+	return err
+}
+
+func (c *tracingClientCodec) finish(seq uint64, err error) {
+	c.mu.Lock()
+	span := c.spans[seq]
+	delete(c.spans, seq)
+	c.mu.Unlock()
+	if span != nil {
+		span.Finish(err)
+	}
+}
+
+type tracingServerCodec struct {
+	rpc.ServerCodec
+	tracer Tracer
+
+	mu    sync.Mutex
+	spans map[uint64]Span
+}
+
+// NewTracingServerCodec wraps codec to start a server span in
+// ReadRequestHeader for every request and finish it once the response is
+// written. If the codec's transport carries headers the span is a child of
+// the one propagated by the client. net/rpc handlers have no context so
+// they can't see the span.
+func NewTracingServerCodec(codec rpc.ServerCodec, tracer Tracer) rpc.ServerCodec {
+	return &tracingServerCodec{
+		ServerCodec: codec,
+		tracer:      tracer,
+		spans:       make(map[uint64]Span),
+	}
+}
+
+func (c *tracingServerCodec) ReadRequestHeader(request *rpc.Request) error {
+	if err := c.ServerCodec.ReadRequestHeader(request); err != nil {
+		return err
+	}
+	parent := extractSpan(readCodecHeaders(c.ServerCodec))
+	span := c.tracer.StartSpan(request.ServiceMethod, SpanKindServer, parent)
+	c.mu.Lock()
+	c.spans[request.Seq] = span
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *tracingServerCodec) ReadHeaders() map[string]string {
+	return readCodecHeaders(c.ServerCodec)
+}
+
+func (c *tracingServerCodec) SetWriteHeader(key, value string) {
+	setCodecHeader(c.ServerCodec, key, value)
+}
+
+func (c *tracingServerCodec) WriteResponse(response *rpc.Response, thriftStruct interface{}) error {
+	c.mu.Lock()
+	span := c.spans[response.Seq]
+	delete(c.spans, response.Seq)
+	c.mu.Unlock()
+	err := c.ServerCodec.WriteResponse(response, thriftStruct)
+	if span != nil {
+		spanErr := err
+		if response.Error != "" {
+			spanErr = rpc.ServerError(response.Error)
+		}
+		span.Finish(spanErr)
+	}
+	return err
+}
+
+// RecordedSpan is a span finished by a MemoryTracer.
+type RecordedSpan struct {
+	Method  string
+	Kind    SpanKind
+	Context SpanContext
+	Start   time.Time
+	End     time.Time
+	Err     error
+}
+
+// MemoryTracer is a Tracer that records finished spans in memory. It's
+// meant for tests.
+type MemoryTracer struct {
+	mu    sync.Mutex
+	spans []RecordedSpan
+}
+
+type memorySpan struct {
+	tracer *MemoryTracer
+	span   RecordedSpan
+	once   sync.Once
+}
+
+// NewMemoryTracer returns a new MemoryTracer.
+func NewMemoryTracer() *MemoryTracer {
+	return &MemoryTracer{}
+}
+
+// StartSpan starts a span in the trace of parent, or a new sampled trace
+// if parent is zero.
+func (t *MemoryTracer) StartSpan(method string, kind SpanKind, parent SpanContext) Span {
+	sc := SpanContext{
+		TraceID:  parent.TraceID,
+		SpanID:   newSpanID(),
+		ParentID: parent.SpanID,
+		Sampled:  parent.Sampled,
+	}
+	if sc.TraceID == 0 {
+		sc.TraceID = sc.SpanID
+		sc.Sampled = true
+	}
+	return &memorySpan{
+		tracer: t,
+		span:   RecordedSpan{Method: method, Kind: kind, Context: sc, Start: time.Now()},
+	}
+}
+
+// Spans returns the spans finished so far in the order they finished.
+func (t *MemoryTracer) Spans() []RecordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]RecordedSpan(nil), t.spans...)
+}
+
+// Reset discards the recorded spans.
+func (t *MemoryTracer) Reset() {
+	t.mu.Lock()
+	t.spans = nil
+	t.mu.Unlock()
+}
+
+func (s *memorySpan) Context() SpanContext {
+	return s.span.Context
+}
+
+func (s *memorySpan) Finish(err error) {
+	s.once.Do(func() {
+		s.span.End = time.Now()
+		s.span.Err = err
+		s.tracer.mu.Lock()
+		s.tracer.spans = append(s.tracer.spans, s.span)
+		s.tracer.mu.Unlock()
+	})
+}
+
+// newSpanID returns a random non-zero ID.
+func newSpanID() uint64 {
+	for {
+		if id := rand.Uint64(); id != 0 {
+			return id
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"testing"
+
+	gentest "github.com/samuel/go-thrift/testfiles/generator/withFlags/go.context"
+)
+
+// checkChildSpan verifies that the server span is a child of the client
+// span.
+func checkChildSpan(t *testing.T, name string, spans []RecordedSpan) {
+	if len(spans) != 2 {
+		t.Fatalf("%s: expected 2 spans instead of %+v", name, spans)
+	}
+	server, client := spans[0], spans[1]
+	if server.Kind == SpanKindClient {
+		server, client = client, server
+	}
+	if server.Kind != SpanKindServer || client.Kind != SpanKindClient {
+		t.Fatalf("%s: expected a server and a client span instead of %+v", name, spans)
+	}
+	if server.Context.TraceID != client.Context.TraceID || server.Context.ParentID != client.Context.SpanID {
+		t.Fatalf("%s: server span %+v isn't a child of client span %+v", name, server.Context, client.Context)
+	}
+	if !server.Context.Sampled {
+		t.Fatalf("%s: expected the server span to be sampled", name)
+	}
+}
+
+func TestTracingInterceptors(t *testing.T) {
+	tracer := NewMemoryTracer()
+	s := NewServer(gentest.NewStoreProcessor(newTestStore()), nil)
+	s.Use(TracingServerInterceptor(tracer))
+	ln, addr := listenTCP()
+	go s.Serve(ln)
+	defer s.Close()
+
+	transports := []struct {
+		name string
+		new  func(net.Conn) (Transport, error)
+	}{
+		{"header", func(conn net.Conn) (Transport, error) {
+			return NewHeaderTransport(conn, HeaderProtocolBinary, 0), nil
+		}},
+		{"ttwitter", func(conn net.Conn) (Transport, error) {
+			return UpgradeTTwitter(NewTransport(NewFramedReadWriteCloser(conn, 0), BinaryProtocol))
+		}},
+	}
+	for _, tr := range transports {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tt, err := tr.new(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := NewContextClient(tt, conn)
+		c.Use(TracingClientInterceptor(tracer))
+		client := &gentest.StoreClient{Client: c}
+
+		tracer.Reset()
+		if err := client.Ping(context.Background()); err != nil {
+			t.Fatalf("%s: Ping returned error: %+v", tr.name, err)
+		}
+		checkChildSpan(t, tr.name, tracer.Spans())
+
+		// Client spans are children of the span in the call's context
+		tracer.Reset()
+		parent := tracer.StartSpan("parent", SpanKindServer, SpanContext{})
+		if err := client.Ping(ContextWithSpan(context.Background(), parent)); err != nil {
+			t.Fatalf("%s: Ping returned error: %+v", tr.name, err)
+		}
+		spans := tracer.Spans()
+		if len(spans) != 2 || spans[1].Context.ParentID != parent.Context().SpanID || spans[1].Context.TraceID != parent.Context().TraceID {
+			t.Fatalf("%s: expected a client span child of %+v instead of %+v", tr.name, parent.Context(), spans)
+		}
+		c.Close()
+	}
+}
+
+func TestTracingCodecs(t *testing.T) {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Thrift", new(TestService)); err != nil {
+		t.Fatal(err)
+	}
+	tracer := NewMemoryTracer()
+	cconn, sconn := tcpPipe(t)
+	st := NewHeaderTransport(sconn, HeaderProtocolBinary, 0)
+	// Headers pass through other wrapping codecs
+	go srv.ServeCodec(NewTracingServerCodec(NewMetricsServerCodec(NewServerCodec(st), newTestExpvarMetrics()), tracer))
+	ct := NewHeaderTransport(cconn, HeaderProtocolBinary, 0)
+	client := rpc.NewClientWithCodec(NewTracingClientCodec(NewInterceptedClientCodec(NewClientCodec(ct, false)), tracer))
+	defer client.Close()
+
+	res := &TestResponse{}
+	if err := client.Call("Success", &TestRequest{123}, res); err != nil {
+		t.Fatal(err)
+	}
+	checkChildSpan(t, "success", tracer.Spans())
+
+	tracer.Reset()
+	if err := client.Call("Fail", &TestRequest{1}, res); err == nil {
+		t.Fatal("Expected an error")
+	}
+	spans := tracer.Spans()
+	checkChildSpan(t, "fail", spans)
+	for _, s := range spans {
+		if s.Err == nil {
+			t.Fatalf("Expected the %s span to record the error", s.Kind)
+		}
+	}
+}
@@ -7,6 +7,7 @@ package thrift
 import (
 	"bufio"
 	"io"
+	"sync"
 )
 
 type Transport interface {
@@ -20,31 +21,132 @@ type transport struct {
 	ProtocolReader
 	ProtocolWriter
 	io.Closer
-	f Flusher
+	f      Flusher
+	rf     Flusher                // flushed after f when wrapping a Flusher in a buffer
+	framed *FramedReadWriteCloser // told the method of messages for metrics
+
+	pool *PooledProtocolBuilder // set until Close if the protocols are pooled
+	br   *bufio.Reader
+	bw   *bufio.Writer
 }
 
+// Buffers used by transports that aren't framed when their protocols come
+// from a PooledProtocolBuilder. They're returned to the pools by Close.
+var (
+	bufReaderPool sync.Pool
+	bufWriterPool sync.Pool
+)
+
+// NewTransport returns a Transport using protocols built by p on rwc.
+// Unless rwc is a FramedReadWriteCloser reads and writes are buffered.
+//
+// If p is a PooledProtocolBuilder the buffers are taken from a pool as
+// well, and Close returns them and the protocol reader and writer to their
+// pools. The transport must then not be used once Close is called, which
+// includes calling Close to unblock a read or write in progress in another
+// goroutine (close rwc for that instead).
 func NewTransport(rwc io.ReadWriteCloser, p ProtocolBuilder) Transport {
 	t := &transport{
 		Closer: rwc,
 	}
-	if _, ok := rwc.(*FramedReadWriteCloser); ok {
+	t.pool, _ = p.(*PooledProtocolBuilder)
+	if framed, ok := rwc.(*FramedReadWriteCloser); ok {
+		t.framed = framed
 		t.ProtocolReader = p.NewProtocolReader(rwc)
 		t.ProtocolWriter = p.NewProtocolWriter(rwc)
 		if f, ok := rwc.(Flusher); ok {
 			t.f = f
 		}
 	} else {
-		w := bufio.NewWriter(rwc)
-		t.ProtocolWriter = p.NewProtocolWriter(w)
-		t.ProtocolReader = p.NewProtocolReader(bufio.NewReader(rwc))
-		t.f = w
+		if t.pool != nil {
+			t.br, _ = bufReaderPool.Get().(*bufio.Reader)
+			t.bw, _ = bufWriterPool.Get().(*bufio.Writer)
+		}
+		if t.br == nil {
+			t.br = bufio.NewReader(rwc)
+		} else {
+			t.br.Reset(rwc)
+		}
+		if t.bw == nil {
+			t.bw = bufio.NewWriter(rwc)
+		} else {
+			t.bw.Reset(rwc)
+		}
+		t.ProtocolWriter = p.NewProtocolWriter(t.bw)
+		t.ProtocolReader = p.NewProtocolReader(t.br)
+		t.f = t.bw
+		if f, ok := rwc.(Flusher); ok {
+			t.rf = f
+		}
 	}
 	return t
 }
 
+// Close closes the wrapped value and, if the protocols came from a
+// PooledProtocolBuilder, returns them and the buffers to their pools.
+func (t *transport) Close() error {
+	err := t.Closer.Close()
+	if pool := t.pool; pool != nil {
+		t.pool = nil
+		pool.PutProtocolReader(t.ProtocolReader)
+		pool.PutProtocolWriter(t.ProtocolWriter)
+		if t.br != nil {
+			// Don't hold on to the stream while in the pool
+			t.br.Reset(nil)
+			bufReaderPool.Put(t.br)
+			t.bw.Reset(nil)
+			bufWriterPool.Put(t.bw)
+		}
+	}
+	return err
+}
+
+func (t *transport) ReadMessageBegin() (string, byte, int32, error) {
+	name, messageType, seqid, err := t.ProtocolReader.ReadMessageBegin()
+	if t.framed != nil && err == nil {
+		t.framed.readMethod(name)
+	}
+	return name, messageType, seqid, err
+}
+
+func (t *transport) WriteMessageBegin(name string, messageType byte, seqid int32) error {
+	if t.framed != nil {
+		t.framed.setWriteMethod(name)
+	}
+	return t.ProtocolWriter.WriteMessageBegin(name, messageType, seqid)
+}
+
 func (t *transport) Flush() error {
 	if t.f != nil {
-		return t.f.Flush()
+		if err := t.f.Flush(); err != nil {
+			return err
+		}
+	}
+	if t.rf != nil {
+		return t.rf.Flush()
 	}
 	return nil
 }
+
+// messageWriter is implemented by transports that write whole messages
+// themselves (e.g. to encode them more than once).
+type messageWriter interface {
+	WriteMessage(name string, messageType byte, seqid int32, v interface{}) error
+}
+
+// writeMessage writes v as a message and flushes it.
+func writeMessage(t Transport, name string, mtype byte, seq int32, v interface{}) error {
+	if mw, ok := t.(messageWriter); ok {
+		return mw.WriteMessage(name, mtype, seq, v)
+	}
+	if err := t.WriteMessageBegin(name, mtype, seq); err != nil {
+		return err
+	}
+	if err := EncodeStruct(t, v); err != nil {
+		return err
+	}
+	if err := t.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return t.Flush()
+}
@@ -0,0 +1,232 @@
+// Copyright 2012-2015 Samuel Stauffer. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package thrift
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// TTwitterUpgradeMethod is the name of the call Finagle clients make to
+// upgrade a connection to the TTwitter protocol in which every request is
+// prefixed with a TTwitterRequestHeader and every reply with a
+// TTwitterResponseHeader.
+const TTwitterUpgradeMethod = "__can__finagle__trace__v3__"
+
+// TTwitterRequestHeader is the header sent before every request on an
+// upgraded connection (RequestHeader in Finagle's tracing.thrift).
+type TTwitterRequestHeader struct {
+	TraceID      int64                     `thrift:"1,required"`
+	SpanID       int64                     `thrift:"2,required"`
+	ParentSpanID *int64                    `thrift:"3"`
+	Sampled      *bool                     `thrift:"5"`
+	ClientID     *TTwitterClientID         `thrift:"6"`
+	Flags        *int64                    `thrift:"7"`
+	Contexts     []*TTwitterRequestContext `thrift:"8"`
+	TraceIDHigh  *int64                    `thrift:"11"`
+}
+
+// TTwitterResponseHeader is the header sent before every reply on an
+// upgraded connection.
+type TTwitterResponseHeader struct {
+	Contexts []*TTwitterRequestContext `thrift:"1"`
+}
+
+// TTwitterClientID identifies the calling service.
+type TTwitterClientID struct {
+	Name string `thrift:"1,required"`
+}
+
+// TTwitterRequestContext is a key/value pair carried in a header.
+type TTwitterRequestContext struct {
+	Key   string `thrift:"1,required"`
+	Value string `thrift:"2,required"`
+}
+
+type tTwitterConnectionOptions struct{}
+
+type tTwitterUpgradeReply struct{}
+
+// TTwitterTransport is a Transport on a connection upgraded to the TTwitter
+// protocol. Headers set with SetWriteHeader and read with ReadHeaders are
+// carried as the contexts of the request and response headers. They're
+// guarded by a mutex so handlers may set headers while other messages are
+// read and written.
+type TTwitterTransport struct {
+	Transport
+
+	server bool
+
+	mu            sync.Mutex // guards the fields below
+	readHeaders   map[string]string
+	requestHeader *TTwitterRequestHeader
+	writeHeaders  map[string]string
+}
+
+func newTTwitterTransport(t Transport, server bool) *TTwitterTransport {
+	return &TTwitterTransport{
+		Transport:    t,
+		server:       server,
+		readHeaders:  make(map[string]string),
+		writeHeaders: make(map[string]string),
+	}
+}
+
+// UpgradeTTwitter asks the server on t to upgrade the connection to the
+// TTwitter protocol and returns a transport using it. It must be called
+// before any other requests are made on t. If the server doesn't support
+// the protocol the returned error is the *ApplicationException it replied
+// with and t may still be used without upgrading.
+func UpgradeTTwitter(t Transport) (*TTwitterTransport, error) {
+	if err := writeMessage(t, TTwitterUpgradeMethod, MessageTypeCall, 0, &tTwitterConnectionOptions{}); err != nil {
+		return nil, err
+	}
+	_, mtype, _, err := t.ReadMessageBegin()
+	if err != nil {
+		return nil, err
+	}
+	var exc *ApplicationException
+	if mtype == MessageTypeException {
+		exc = &ApplicationException{}
+		err = DecodeStruct(t, exc)
+	} else {
+		err = SkipValue(t, TypeStruct)
+	}
+	if err == nil {
+		err = t.ReadMessageEnd()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if exc != nil {
+		return nil, exc
+	}
+	return newTTwitterTransport(t, false), nil
+}
+
+// acceptTTwitter replies to an upgrade request whose message header has
+// been read from t.
+func acceptTTwitter(t Transport, seq int32) (*TTwitterTransport, error) {
+	if err := SkipValue(t, TypeStruct); err != nil {
+		return nil, err
+	}
+	if err := t.ReadMessageEnd(); err != nil {
+		return nil, err
+	}
+	if err := writeMessage(t, TTwitterUpgradeMethod, MessageTypeReply, seq, &tTwitterUpgradeReply{}); err != nil {
+		return nil, err
+	}
+	return newTTwitterTransport(t, true), nil
+}
+
+// ReadHeaders returns the contexts of the header of the last message read.
+func (t *TTwitterTransport) ReadHeaders() map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.readHeaders
+}
+
+// SetWriteHeader sets a context to send in the header of the next message.
+func (t *TTwitterTransport) SetWriteHeader(key, value string) {
+	t.mu.Lock()
+	t.writeHeaders[key] = value
+	t.mu.Unlock()
+}
+
+// RequestHeader returns the header of the last request read by a server or
+// nil on a client.
+func (t *TTwitterTransport) RequestHeader() *TTwitterRequestHeader {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.requestHeader
+}
+
+func (t *TTwitterTransport) ReadMessageBegin() (name string, messageType byte, seqid int32, err error) {
+	var contexts []*TTwitterRequestContext
+	var rh *TTwitterRequestHeader
+	if t.server {
+		rh = &TTwitterRequestHeader{}
+		if err = DecodeStruct(t.Transport, rh); err != nil {
+			return
+		}
+		contexts = rh.Contexts
+	} else {
+		h := &TTwitterResponseHeader{}
+		if err = DecodeStruct(t.Transport, h); err != nil {
+			return
+		}
+		contexts = h.Contexts
+	}
+	headers := make(map[string]string, len(contexts))
+	for _, c := range contexts {
+		if c != nil {
+			headers[c.Key] = c.Value
+		}
+	}
+	if rh != nil && rh.TraceID != 0 {
+		// Expose the span as metadata as it would be on other transports
+		sc := SpanContext{TraceID: uint64(rh.TraceID), SpanID: uint64(rh.SpanID)}
+		if rh.ParentSpanID != nil {
+			sc.ParentID = uint64(*rh.ParentSpanID)
+		}
+		if rh.Sampled != nil {
+			sc.Sampled = *rh.Sampled
+		}
+		for k, v := range injectSpan(sc) {
+			headers[k] = v
+		}
+	}
+	t.mu.Lock()
+	t.readHeaders = headers
+	if rh != nil {
+		t.requestHeader = rh
+	}
+	t.mu.Unlock()
+	return t.Transport.ReadMessageBegin()
+}
+
+func (t *TTwitterTransport) WriteMessageBegin(name string, messageType byte, seqid int32) error {
+	t.mu.Lock()
+	headers := t.writeHeaders
+	t.writeHeaders = make(map[string]string)
+	t.mu.Unlock()
+	var sc SpanContext
+	if !t.server {
+		// Span metadata is sent in the header's fields
+		sc = extractSpan(headers)
+		if sc.TraceID != 0 {
+			for _, k := range []string{TraceIDKey, SpanIDKey, ParentSpanIDKey, SampledKey} {
+				delete(headers, k)
+			}
+		}
+	}
+	var contexts []*TTwitterRequestContext
+	for k, v := range headers {
+		contexts = append(contexts, &TTwitterRequestContext{k, v})
+	}
+	var h interface{}
+	if t.server {
+		h = &TTwitterResponseHeader{Contexts: contexts}
+	} else if sc.TraceID == 0 {
+		id := rand.Int63()
+		h = &TTwitterRequestHeader{TraceID: id, SpanID: id, Contexts: contexts}
+	} else {
+		rh := &TTwitterRequestHeader{
+			TraceID:  int64(sc.TraceID),
+			SpanID:   int64(sc.SpanID),
+			Sampled:  &sc.Sampled,
+			Contexts: contexts,
+		}
+		if sc.ParentID != 0 {
+			parentID := int64(sc.ParentID)
+			rh.ParentSpanID = &parentID
+		}
+		h = rh
+	}
+	if err := EncodeStruct(t.Transport, h); err != nil {
+		return err
+	}
+	return t.Transport.WriteMessageBegin(name, messageType, seqid)
+}